@@ -0,0 +1,150 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthProvider sets credentials on outgoing gateway requests.
+type AuthProvider interface {
+	Apply(ctx context.Context, req *http.Request) error
+}
+
+// Refresher is implemented by providers that can renew their credentials.
+//
+// When the gateway answers 401 Unauthorized, the client calls Refresh and
+// retries the request once.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// WithAuthProvider replaces the auth key passed to New with a provider.
+func WithAuthProvider(provider AuthProvider) Option {
+	return func(c *Client) error {
+		if provider == nil {
+			return fmt.Errorf("auth provider cannot be nil")
+		}
+		c.auth = provider
+		return nil
+	}
+}
+
+type headerAuth struct {
+	value string
+}
+
+func (a *headerAuth) Apply(_ context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", a.value)
+	return nil
+}
+
+// StaticAuth sends key verbatim in the Authorization header. This is what New
+// does with its authKey argument.
+func StaticAuth(key string) (AuthProvider, error) {
+	if key == "" {
+		return nil, ErrInvalidAuth
+	}
+	return &headerAuth{value: key}, nil
+}
+
+// BearerAuth sends "Authorization: Bearer <token>".
+func BearerAuth(token string) (AuthProvider, error) {
+	if token == "" {
+		return nil, ErrInvalidAuth
+	}
+	return &headerAuth{value: "Bearer " + token}, nil
+}
+
+// BasicAuth sends HTTP basic credentials.
+func BasicAuth(username, password string) (AuthProvider, error) {
+	if username == "" {
+		return nil, ErrInvalidAuth
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return &headerAuth{value: "Basic " + credentials}, nil
+}
+
+// TokenFunc returns the current credential of a RefreshingAuth provider.
+type TokenFunc func(ctx context.Context) (string, error)
+
+type refreshingAuth struct {
+	fetch  TokenFunc
+	scheme string
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	token   string
+	fetched time.Time
+}
+
+// RefreshingAuth obtains its credential from fetch, caching it for ttl (zero
+// caches until the gateway rejects it). When scheme is non-empty the header is
+// "<scheme> <token>", otherwise the token is sent verbatim.
+func RefreshingAuth(fetch TokenFunc, scheme string, ttl time.Duration) (AuthProvider, error) {
+	if fetch == nil {
+		return nil, fmt.Errorf("token func cannot be nil")
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("token TTL cannot be negative")
+	}
+	return &refreshingAuth{fetch: fetch, scheme: scheme, ttl: ttl}, nil
+}
+
+// FileAuth reads the credential from path, e.g. a mounted Kubernetes secret,
+// and re-reads it every ttl or after a 401.
+func FileAuth(path, scheme string, ttl time.Duration) (AuthProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("auth file path cannot be empty")
+	}
+	return RefreshingAuth(func(context.Context) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read auth file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}, scheme, ttl)
+}
+
+func (a *refreshingAuth) Apply(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stale := a.ttl > 0 && time.Since(a.fetched) >= a.ttl
+	if a.token == "" || stale {
+		if err := a.refreshLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	if a.scheme != "" {
+		req.Header.Set("Authorization", a.scheme+" "+a.token)
+	} else {
+		req.Header.Set("Authorization", a.token)
+	}
+	return nil
+}
+
+func (a *refreshingAuth) Refresh(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.refreshLocked(ctx)
+}
+
+func (a *refreshingAuth) refreshLocked(ctx context.Context) error {
+	token, err := a.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrInvalidAuth
+	}
+	a.token = token
+	a.fetched = time.Now()
+	return nil
+}
@@ -0,0 +1,135 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func() (AuthProvider, error)
+		want     string
+	}{
+		{
+			name:     "static",
+			provider: func() (AuthProvider, error) { return StaticAuth("secret") },
+			want:     "secret",
+		},
+		{
+			name:     "bearer",
+			provider: func() (AuthProvider, error) { return BearerAuth("token") },
+			want:     "Bearer token",
+		},
+		{
+			name:     "basic",
+			provider: func() (AuthProvider, error) { return BasicAuth("user", "pass") },
+			want:     "Basic dXNlcjpwYXNz",
+		},
+		{
+			name: "refreshing",
+			provider: func() (AuthProvider, error) {
+				return RefreshingAuth(func(context.Context) (string, error) {
+					return "rotated", nil
+				}, "Bearer", time.Minute)
+			},
+			want: "Bearer rotated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Authorization")
+				writePDFResponse(w, fakePDF)
+			})
+
+			provider, err := tt.provider()
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+
+			client, err := New("", server.URL, WithAuthProvider(provider))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthProviderValidation(t *testing.T) {
+	if _, err := StaticAuth(""); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("StaticAuth: expected ErrInvalidAuth, got %v", err)
+	}
+	if _, err := BearerAuth(""); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("BearerAuth: expected ErrInvalidAuth, got %v", err)
+	}
+	if _, err := BasicAuth("", "pass"); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("BasicAuth: expected ErrInvalidAuth, got %v", err)
+	}
+	if _, err := New("", "https://example.com/function/typst"); !errors.Is(err, ErrInvalidAuth) {
+		t.Errorf("New: expected ErrInvalidAuth, got %v", err)
+	}
+}
+
+func TestFileAuthRefreshesOnUnauthorized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+
+	var calls atomic.Int32
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "new" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writePDFResponse(w, fakePDF)
+	})
+
+	provider, err := FileAuth(path, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	client, err := New("", server.URL, WithAuthProvider(provider))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Prime the cached token, then rotate the secret on disk.
+	var buf bytes.Buffer
+	_, err = client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected HTTP 401 with old token, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("new\n"), 0600); err != nil {
+		t.Fatalf("Failed to rotate token: %v", err)
+	}
+
+	calls.Store(0)
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert after rotation failed: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected 2 gateway calls (401 then retry), got %d", got)
+	}
+}
@@ -0,0 +1,256 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnsupportedError reports a request feature the gateway does not support,
+// detected before the request is sent.
+type UnsupportedError struct {
+	Feature string
+	Detail  string
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("unsupported by gateway: %s: %s", e.Feature, e.Detail)
+	}
+	return fmt.Sprintf("unsupported by gateway: %s", e.Feature)
+}
+
+func (e *UnsupportedError) Unwrap() error {
+	return ErrUnsupported
+}
+
+// Capabilities describes what a gateway supports, as reported by
+// <gateway>/capabilities.
+type Capabilities struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	TypstVersion    string `json:"typst_version"`
+	// Fonts are the font families installed on the gateway.
+	Fonts []string `json:"fonts,omitempty"`
+	// Packages are the packages available without vendoring, as
+	// "@namespace/name:version".
+	Packages []string `json:"packages,omitempty"`
+	// MaxPayload is the largest request body in bytes the gateway accepts.
+	// Zero means unlimited.
+	MaxPayload    int64    `json:"max_payload,omitempty"`
+	OutputFormats []string `json:"output_formats,omitempty"`
+	// Features lists optional protocol extensions, e.g. "media-store".
+	Features []string `json:"features,omitempty"`
+}
+
+// HasFeature reports whether the gateway advertises the named protocol extension.
+func (c *Capabilities) HasFeature(name string) bool {
+	return slices.Contains(c.Features, name)
+}
+
+// HasPackage reports whether the gateway lists the package, given as
+// "@namespace/name:version" or without the leading "@".
+func (c *Capabilities) HasPackage(spec PackageSpec) bool {
+	return slices.ContainsFunc(c.Packages, func(p string) bool {
+		return strings.TrimPrefix(p, "@") == strings.TrimPrefix(spec.String(), "@")
+	})
+}
+
+// SupportsFormat reports whether the gateway can produce the given output
+// format. An empty list is treated as PDF only.
+func (c *Capabilities) SupportsFormat(format string) bool {
+	format = strings.ToLower(format)
+	if len(c.OutputFormats) == 0 {
+		return format == "pdf"
+	}
+	return slices.ContainsFunc(c.OutputFormats, func(f string) bool {
+		return strings.EqualFold(f, format)
+	})
+}
+
+// Ping checks that the gateway is reachable and healthy.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodGet, c.endpoint("healthz"), contextCorrelationID(ctx), nil, nil)
+}
+
+// Capabilities fetches the gateway's capabilities.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	var caps Capabilities
+	if err := c.doJSON(ctx, http.MethodGet, c.endpoint("capabilities"), contextCorrelationID(ctx), nil, &caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// WithCapabilityCheck makes Convert validate requests against the gateway's
+// capabilities before sending them, failing early with an *UnsupportedError.
+//
+// Requests are checked for their size, --format option, and the fonts and
+// packages the template uses that they do not ship. Capabilities are fetched
+// lazily and cached for ttl; failed fetches are retried on the next request.
+// Gateways that do not expose capabilities are not checked.
+func WithCapabilityCheck(ttl time.Duration) Option {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("capability cache TTL must be positive")
+		}
+		c.caps = &capabilityCache{ttl: ttl}
+		return nil
+	}
+}
+
+type capabilityCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	caps     *Capabilities
+	fetched  time.Time
+	inFlight *capabilityFetch
+}
+
+// capabilityFetch is a fetch shared by the callers that need capabilities
+// while it runs.
+type capabilityFetch struct {
+	done chan struct{}
+	caps *Capabilities
+	ok   bool
+}
+
+// get returns cached capabilities, refreshing them when stale. Concurrent
+// callers share one fetch, which runs without holding the lock. It returns
+// nil when the gateway does not expose capabilities, and the previous
+// capabilities, if any, when they cannot be fetched; failures are not cached.
+func (cc *capabilityCache) get(ctx context.Context, c *Client) *Capabilities {
+	cc.mu.Lock()
+	if !cc.fetched.IsZero() && time.Since(cc.fetched) < cc.ttl {
+		defer cc.mu.Unlock()
+		return cc.caps
+	}
+	stale := cc.caps
+	if f := cc.inFlight; f != nil {
+		cc.mu.Unlock()
+		select {
+		case <-f.done:
+			if f.ok {
+				return f.caps
+			}
+		case <-ctx.Done():
+		}
+		return stale
+	}
+	f := &capabilityFetch{done: make(chan struct{})}
+	cc.inFlight = f
+	cc.mu.Unlock()
+
+	caps, err := c.Capabilities(ctx)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			// The gateway does not expose capabilities; do not ask again
+			// before the TTL expires.
+			caps, err = nil, nil
+		}
+	}
+
+	cc.mu.Lock()
+	cc.inFlight = nil
+	if err == nil {
+		cc.caps, cc.fetched = caps, time.Now()
+		f.caps, f.ok = caps, true
+	}
+	cc.mu.Unlock()
+	close(f.done)
+
+	if err != nil {
+		return stale
+	}
+	return caps
+}
+
+// checkCapabilities validates an encoded request against the gateway's
+// capabilities: its size, output format, and the fonts and packages the
+// template uses that the request does not ship itself.
+func (c *Client) checkCapabilities(ctx context.Context, templateData []byte, options []string, media []MediaFile, payloadSize int) error {
+	caps := c.caps.get(ctx, c)
+	if caps == nil {
+		return nil
+	}
+
+	if c.mediaStore != nil && caps.HasFeature("media-store") {
+		c.mediaStore.state.Store(mediaStoreSupported)
+	}
+
+	// With the media store the uploaded body is usually much smaller than the
+	// full request, so leave the limit to the gateway.
+	mediaStoreActive := c.mediaStore != nil && c.mediaStore.supported()
+	if caps.MaxPayload > 0 && !mediaStoreActive && int64(payloadSize) > caps.MaxPayload {
+		return &UnsupportedError{
+			Feature: "payload size",
+			Detail:  fmt.Sprintf("request is %d bytes, gateway accepts at most %d", payloadSize, caps.MaxPayload),
+		}
+	}
+
+	if format, ok := optionValue(options, "--format", "-f"); ok && !caps.SupportsFormat(format) {
+		return &UnsupportedError{Feature: "output format", Detail: format}
+	}
+
+	masked, _ := scanTypst(templateName, templateData)
+
+	// An empty font list means the gateway does not tell. Its fonts are
+	// system fonts to typst, so they only count when those are used.
+	if len(caps.Fonts) > 0 {
+		available, _ := shippedFonts(options, media)
+		if !slices.Contains(options, "--ignore-embedded-fonts") {
+			for _, f := range embeddedFonts {
+				available[f] = true
+			}
+		}
+		if !slices.Contains(options, "--ignore-system-fonts") {
+			for _, f := range caps.Fonts {
+				available[strings.ToLower(f)] = true
+			}
+		}
+		for _, ref := range fontReferences(templateName, masked) {
+			if !available[strings.ToLower(ref.Literal)] {
+				return &UnsupportedError{Feature: "font", Detail: ref.Literal}
+			}
+		}
+	}
+
+	if len(caps.Packages) > 0 {
+		vendored := packageFiles(media)
+		for _, ref := range packageReferences(templateName, masked) {
+			spec, err := ParsePackageSpec(ref.Literal)
+			if err != nil {
+				continue
+			}
+			if _, ok := vendored[spec]; ok || caps.HasPackage(spec) {
+				continue
+			}
+			return &UnsupportedError{Feature: "package", Detail: spec.String()}
+		}
+	}
+
+	return nil
+}
+
+// optionValue returns the value of a typst CLI flag given either as
+// "--flag=value" or as "--flag value".
+func optionValue(options []string, names ...string) (string, bool) {
+	for i, opt := range options {
+		for _, name := range names {
+			if value, ok := strings.CutPrefix(opt, name+"="); ok {
+				return value, true
+			}
+			if opt == name && i+1 < len(options) {
+				return options[i+1], true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,197 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPingAndCapabilities(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "test-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/function/typst/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/function/typst/capabilities":
+			_ = json.NewEncoder(w).Encode(Capabilities{
+				ProtocolVersion: 2,
+				TypstVersion:    "0.13.1",
+				Fonts:           []string{"Lato", "New Computer Modern"},
+				OutputFormats:   []string{"pdf", "png", "svg"},
+				MaxPayload:      1 << 20,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	client, err := New("test-key", server.URL+"/function/typst")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	caps, err := client.Capabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if caps.TypstVersion != "0.13.1" || caps.ProtocolVersion != 2 {
+		t.Errorf("Unexpected capabilities: %+v", caps)
+	}
+	if !caps.SupportsFormat("SVG") || caps.SupportsFormat("html") {
+		t.Errorf("Unexpected format support: %v", caps.OutputFormats)
+	}
+}
+
+func TestPingUnhealthy(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+
+	client, err := New("test-key", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	err = client.Ping(context.Background())
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected HTTP 503 error, got %v", err)
+	}
+}
+
+func TestCapabilityCheck(t *testing.T) {
+	var renders, capsFetches atomic.Int32
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities" {
+			capsFetches.Add(1)
+			_ = json.NewEncoder(w).Encode(Capabilities{
+				OutputFormats: []string{"pdf"},
+				MaxPayload:    512,
+				Fonts:         []string{"Lato"},
+				Packages:      []string{"@preview/cetz:0.2.2"},
+			})
+			return
+		}
+		renders.Add(1)
+		writePDFResponse(w, fakePDF)
+	})
+
+	client, err := New("test-key", server.URL, WithCapabilityCheck(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		options     []string
+		wantFeature string
+	}{
+		{name: "supported", template: "= Hi"},
+		{name: "unsupported format", template: "= Hi", options: []string{"--format", "png"}, wantFeature: "output format"},
+		{name: "payload too large", template: string(bytes.Repeat([]byte("a"), 1024)), wantFeature: "payload size"},
+		{name: "installed font", template: `#set text(font: "lato")`, options: []string{"--diagnostic-format=short"}},
+		{name: "missing font", template: `#set text(font: "Comic Sans")`, options: []string{"--diagnostic-format=short"}, wantFeature: "font"},
+		{name: "installed font ignored", template: `#set text(font: "Lato")`, wantFeature: "font"},
+		{name: "embedded font", template: `#set text(font: "New Computer Modern")`},
+		{name: "installed package", template: `#import "@preview/cetz:0.2.2": canvas`},
+		{name: "missing package", template: `#import "@preview/fletcher:0.5.1": diagram`, wantFeature: "package"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			_, err := client.Convert(context.Background(), &buf, "", []byte(tt.template), tt.options, nil)
+			if tt.wantFeature == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			var unsupported *UnsupportedError
+			if !errors.As(err, &unsupported) || unsupported.Feature != tt.wantFeature {
+				t.Fatalf("Expected unsupported %q, got %v", tt.wantFeature, err)
+			}
+			if !errors.Is(err, ErrUnsupported) {
+				t.Error("Expected error to wrap ErrUnsupported")
+			}
+		})
+	}
+
+	if got := renders.Load(); got != 4 {
+		t.Errorf("Expected 4 renders, got %d", got)
+	}
+	if got := capsFetches.Load(); got != 1 {
+		t.Errorf("Expected capabilities to be fetched once, got %d", got)
+	}
+}
+
+func TestCapabilityCacheFetch(t *testing.T) {
+	var capsFetches atomic.Int32
+	failing := atomic.Bool{}
+	failing.Store(true)
+	release := make(chan struct{})
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities" {
+			capsFetches.Add(1)
+			<-release
+			if failing.Load() {
+				http.Error(w, "unavailable", http.StatusInternalServerError)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(Capabilities{OutputFormats: []string{"pdf"}})
+			return
+		}
+		writePDFResponse(w, fakePDF)
+	})
+
+	client, err := New("test-key", server.URL, WithCapabilityCheck(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Concurrent conversions share one fetch.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	for capsFetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := capsFetches.Load(); got != 1 {
+		t.Fatalf("Expected one shared fetch, got %d", got)
+	}
+
+	// The failure was not cached.
+	failing.Store(false)
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), []string{"--format", "png"}, nil); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported after a successful fetch, got %v", err)
+	}
+	if got := capsFetches.Load(); got != 2 {
+		t.Errorf("Expected capabilities to be fetched again, got %d", got)
+	}
+}
@@ -0,0 +1,59 @@
+// Command typstgateway serves the typst-pdf-generator gateway protocol,
+// including asynchronous jobs, by compiling with a local typst executable.
+//
+// Usage:
+//
+//	typstgateway [-addr :8080] [-typst typst] [-workers N] [-max-payload BYTES]
+//
+// Clients must send PDF_GENERATOR_AUTH_KEY in the Authorization header when it
+// is set. Job callbacks are signed with PDF_GENERATOR_WEBHOOK_SECRET when it
+// is set.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/4sigma/typstpdfgenerator/gateway"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	typst := flag.String("typst", "typst", "typst executable")
+	workers := flag.Int("workers", 0, "jobs compiled concurrently (default: number of CPUs)")
+	maxPayload := flag.Int64("max-payload", 0, "largest request body in bytes (default: unlimited)")
+	flag.Parse()
+
+	srv := &gateway.Server{
+		Compiler:   &gateway.TypstCompiler{Path: *typst},
+		AuthKey:    os.Getenv("PDF_GENERATOR_AUTH_KEY"),
+		MaxPayload: *maxPayload,
+		Workers:    *workers,
+	}
+	if secret := os.Getenv("PDF_GENERATOR_WEBHOOK_SECRET"); secret != "" {
+		srv.WebhookSecret = []byte(secret)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	httpServer := &http.Server{Addr: *addr, Handler: srv}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "typstgateway: listening on %s\n", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "typstgateway: %v\n", err)
+		os.Exit(1)
+	}
+	srv.Close()
+}
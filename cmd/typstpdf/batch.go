@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// readManifest reads one job per non-empty line. Relative paths are resolved
+// against the manifest's directory.
+func readManifest(path string) ([]job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	var jobs []job
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var j job
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&j); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if j.Template == "" {
+			return nil, fmt.Errorf("%s:%d: missing template", path, lineNo)
+		}
+		jobs = append(jobs, j.resolve(dir))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return jobs, nil
+}
+
+func runBatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		g        globalFlags
+		parallel int
+	)
+	fs := newFlagSet("batch", stderr, "manifest.jsonl")
+	g.register(fs)
+	fs.IntVar(&parallel, "parallel", 4, "number of concurrent renders")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 || parallel < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	jobs, err := readManifest(fs.Arg(0))
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	client, err := g.client()
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, parallel)
+	)
+	for _, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, n, err := render(ctx, client, j)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				newFailure(j.Template, info, err).print(stderr, g.json)
+				return
+			}
+			result{Template: j.Template, Output: j.outputPath(), Bytes: n, CorrelationID: info.CorrelationID}.print(stdout, g.json)
+		}(j)
+	}
+	wg.Wait()
+
+	if !g.json {
+		fmt.Fprintf(stdout, "%d of %d rendered\n", len(jobs)-failed, len(jobs))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
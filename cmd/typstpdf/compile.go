@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// job is a single render, given on the command line or as a batch manifest line.
+type job struct {
+	Template string            `json:"template"`
+	Output   string            `json:"output,omitempty"`
+	Media    []string          `json:"media,omitempty"`
+	Inputs   map[string]string `json:"inputs,omitempty"`
+	Data     string            `json:"data,omitempty"`
+	Content  string            `json:"content,omitempty"`
+	Options  []string          `json:"options,omitempty"`
+	// ResolveMedia attaches the files the template loads, found relative to
+	// its directory.
+	ResolveMedia bool `json:"resolve_media,omitempty"`
+	// Packages is a directory of vendored packages, as written by typstpdf
+	// vendor, to attach the imported ones from.
+	Packages string `json:"packages,omitempty"`
+}
+
+// resolve makes the job's relative paths relative to dir.
+func (j job) resolve(dir string) job {
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	j.Template = abs(j.Template)
+	j.Output = abs(j.Output)
+	j.Data = abs(j.Data)
+	j.Packages = abs(j.Packages)
+	media := make([]string, len(j.Media))
+	for i, m := range j.Media {
+		media[i] = abs(m)
+	}
+	j.Media = media
+	return j
+}
+
+func (j job) outputPath() string {
+	if j.Output != "" {
+		return j.Output
+	}
+	return strings.TrimSuffix(j.Template, filepath.Ext(j.Template)) + ".pdf"
+}
+
+// options returns the typst CLI options of the job, including its inputs.
+func (j job) options() []string {
+	options := j.Options
+	// Explicit options replace the defaults, so keep them when only adding inputs.
+	if len(options) == 0 && (len(j.Inputs) > 0 || j.Data != "" || j.Packages != "") {
+		options = typstpdfgenerator.DefaultOptions()
+	}
+
+	if j.Packages != "" {
+		options = append(options, "--package-path="+typstpdfgenerator.PackageDir)
+	}
+
+	if j.Data != "" {
+		options = append(options, "--input", "data="+filepath.Base(j.Data))
+	}
+
+	keys := make([]string, 0, len(j.Inputs))
+	for k := range j.Inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		options = append(options, "--input", k+"="+j.Inputs[k])
+	}
+	return options
+}
+
+// mediaPaths returns the media files and directories of the job, including
+// its data file.
+func (j job) mediaPaths() []string {
+	if j.Data == "" {
+		return j.Media
+	}
+	return append(slices.Clone(j.Media), j.Data)
+}
+
+// request reads the template, media and data files of the job.
+func (j job) request() (*typstpdfgenerator.Request, error) {
+	if j.Template == "" {
+		return nil, fmt.Errorf("no template given")
+	}
+	template, err := os.ReadFile(j.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	if j.Data != "" {
+		data, err := os.ReadFile(j.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data file: %w", err)
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("data file is not valid JSON: %s", j.Data)
+		}
+	}
+
+	req := &typstpdfgenerator.Request{Content: j.Content, Template: template, Options: j.options()}
+	for _, path := range j.mediaPaths() {
+		media, err := typstpdfgenerator.LoadMedia(path)
+		if err != nil {
+			return nil, err
+		}
+		req.Media = append(req.Media, media...)
+	}
+
+	if j.ResolveMedia {
+		resolved, err := typstpdfgenerator.ResolveMediaDir(filepath.Dir(j.Template), template, req.Media)
+		if err != nil {
+			return nil, err
+		}
+		req.Media = append(req.Media, resolved...)
+	}
+
+	if j.Packages != "" {
+		vendored, err := typstpdfgenerator.VendorPackagesDir(j.Packages, template, req.Media)
+		if err != nil {
+			return nil, err
+		}
+		req.Media = append(req.Media, vendored.Media...)
+	}
+	return req, nil
+}
+
+// render converts the job and writes the PDF to its output path. The output
+// is only created once the conversion succeeded.
+func render(ctx context.Context, client *typstpdfgenerator.Client, j job) (typstpdfgenerator.ResponseInfo, int, error) {
+	req, err := j.request()
+	if err != nil {
+		return typstpdfgenerator.ResponseInfo{}, 0, err
+	}
+
+	var buf bytes.Buffer
+	info, err := client.Convert(ctx, &buf, req.Content, req.Template, req.Options, req.Media)
+	if err != nil {
+		return info, 0, err
+	}
+
+	if err := os.WriteFile(j.outputPath(), buf.Bytes(), 0644); err != nil {
+		return info, 0, fmt.Errorf("failed to write output: %w", err)
+	}
+	return info, buf.Len(), nil
+}
+
+type result struct {
+	Template      string `json:"template"`
+	Output        string `json:"output"`
+	Bytes         int    `json:"bytes"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+func (r result) print(w io.Writer, asJSON bool) {
+	if asJSON {
+		_ = json.NewEncoder(w).Encode(r)
+		return
+	}
+	fmt.Fprintf(w, "%s -> %s (%d bytes, correlation_id=%s)\n", r.Template, r.Output, r.Bytes, r.CorrelationID)
+}
+
+// parseInputs parses -input key=value flags.
+func parseInputs(inputs []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(inputs))
+	for _, kv := range inputs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid -input %q, expected key=value", kv)
+		}
+		parsed[k] = v
+	}
+	return parsed, nil
+}
+
+// jobFlags are the flags describing a single render.
+type jobFlags struct {
+	job    job
+	media  multiFlag
+	inputs multiFlag
+	opts   multiFlag
+}
+
+func (f *jobFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.media, "media", "media file or directory to attach (repeatable)")
+	fs.Var(&f.inputs, "input", "typst input as key=value, available as sys.inputs (repeatable)")
+	fs.StringVar(&f.job.Data, "data", "", "JSON file to attach, its name is passed as sys.inputs.data")
+	fs.StringVar(&f.job.Content, "content", "", "content passed to the gateway")
+	fs.Var(&f.opts, "option", "raw typst CLI option, replaces the defaults (repeatable)")
+}
+
+// registerAttach registers the flags that attach files found from the
+// template. Only commands building the request with job.request honor them.
+func (f *jobFlags) registerAttach(fs *flag.FlagSet) {
+	fs.BoolVar(&f.job.ResolveMedia, "resolve-media", false, "attach the files the template loads, found relative to its directory")
+	fs.StringVar(&f.job.Packages, "packages", "", "directory of vendored packages to attach the imported ones from")
+}
+
+// parse returns the job rendering template.
+func (f *jobFlags) parse(template string) (job, error) {
+	j := f.job
+	j.Template = template
+	j.Media = f.media
+	j.Options = f.opts
+	var err error
+	j.Inputs, err = parseInputs(f.inputs)
+	return j, err
+}
+
+func runCompile(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		g  globalFlags
+		jf jobFlags
+	)
+	fs := newFlagSet("compile", stderr, "template.typ")
+	g.register(fs)
+	fs.StringVar(&jf.job.Output, "o", "", "output PDF path (default: template name with .pdf)")
+	jf.register(fs)
+	jf.registerAttach(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	j, err := jf.parse(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "typstpdf: %v\n", err)
+		return 2
+	}
+
+	client, err := g.client()
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	info, n, err := render(ctx, client, j)
+	if err != nil {
+		newFailure(j.Template, info, err).print(stderr, g.json)
+		return 1
+	}
+
+	result{Template: j.Template, Output: j.outputPath(), Bytes: n, CorrelationID: info.CorrelationID}.print(stdout, g.json)
+	return 0
+}
@@ -0,0 +1,244 @@
+// Command typstpdf renders Typst templates through a typst-pdf-generator
+// gateway.
+//
+// Usage:
+//
+//	typstpdf compile [flags] template.typ
+//	typstpdf batch [flags] manifest.jsonl
+//	typstpdf watch [flags] template.typ
+//	typstpdf preview [flags] template.typ
+//	typstpdf ping [flags]
+//	typstpdf fonts [flags]
+//	typstpdf vendor [flags] template.typ
+//
+// The gateway and credentials are read from PDF_GENERATOR_ENDPOINT and
+// PDF_GENERATOR_AUTH_KEY unless given with -endpoint and -auth-key.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+const usage = `Usage: typstpdf <command> [flags] [args]
+
+Commands:
+  compile   render a template to PDF
+  batch     render every job of a JSONL manifest
+  watch     re-render a template whenever it or its media change
+  preview   serve a live preview of a template in the browser
+  ping      check that the gateway is healthy
+  fonts     list the fonts available on the gateway
+  vendor    copy the packages a template imports and write a lockfile
+
+Run "typstpdf <command> -h" for the flags of a command.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	commands := map[string]func(context.Context, []string, io.Writer, io.Writer) int{
+		"compile": runCompile,
+		"batch":   runBatch,
+		"ping":    runPing,
+		"fonts":   runFonts,
+		"watch":   runWatch,
+		"preview": runPreview,
+		"vendor":  runVendor,
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			fmt.Fprint(stdout, usage)
+			return 0
+		}
+		fmt.Fprintf(stderr, "typstpdf: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	return cmd(ctx, args[1:], stdout, stderr)
+}
+
+// globalFlags are shared by all commands.
+type globalFlags struct {
+	endpoint string
+	authKey  string
+	timeout  time.Duration
+	insecure bool
+	json     bool
+}
+
+func (g *globalFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.endpoint, "endpoint", os.Getenv("PDF_GENERATOR_ENDPOINT"), "gateway URL (default $PDF_GENERATOR_ENDPOINT)")
+	fs.StringVar(&g.authKey, "auth-key", "", "auth key (default $PDF_GENERATOR_AUTH_KEY)")
+	fs.DurationVar(&g.timeout, "timeout", 120*time.Second, "request timeout")
+	fs.BoolVar(&g.insecure, "insecure", false, "skip TLS certificate verification")
+	fs.BoolVar(&g.json, "json", false, "print results and diagnostics as JSON")
+}
+
+func (g *globalFlags) client() (*typstpdfgenerator.Client, error) {
+	authKey := g.authKey
+	if authKey == "" {
+		authKey = os.Getenv("PDF_GENERATOR_AUTH_KEY")
+	}
+
+	opts := []typstpdfgenerator.Option{typstpdfgenerator.WithTimeout(g.timeout)}
+	if g.insecure {
+		opts = append(opts, typstpdfgenerator.WithInsecureSkipVerify())
+	}
+	return typstpdfgenerator.New(authKey, g.endpoint, opts...)
+}
+
+func newFlagSet(name string, stderr io.Writer, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: typstpdf %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args, returning the exit code to use when parsing failed
+// or help was requested.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
+
+// multiFlag collects the values of a repeatable flag.
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// failure is the structured report printed when a command fails.
+type failure struct {
+	Error         string                         `json:"error"`
+	Template      string                         `json:"template,omitempty"`
+	CorrelationID string                         `json:"correlation_id,omitempty"`
+	StatusCode    int                            `json:"status,omitempty"`
+	Diagnostics   []typstpdfgenerator.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+func newFailure(template string, info typstpdfgenerator.ResponseInfo, err error) failure {
+	return failure{
+		Error:         err.Error(),
+		Template:      template,
+		CorrelationID: info.CorrelationID,
+		StatusCode:    info.StatusCode,
+		Diagnostics:   typstpdfgenerator.DiagnosticsFromError(info, err),
+	}
+}
+
+func (f failure) print(w io.Writer, asJSON bool) {
+	if asJSON {
+		_ = json.NewEncoder(w).Encode(f)
+		return
+	}
+
+	prefix := "typstpdf: "
+	if f.Template != "" {
+		prefix += f.Template + ": "
+	}
+	fmt.Fprintf(w, "%s%s\n", prefix, f.Error)
+	for _, d := range f.Diagnostics {
+		fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(d.String(), "\n", "\n  "))
+	}
+	if f.CorrelationID != "" {
+		fmt.Fprintf(w, "  correlation_id=%s\n", f.CorrelationID)
+	}
+}
+
+func runPing(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var g globalFlags
+	fs := newFlagSet("ping", stderr, "")
+	g.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	client, err := g.client()
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	start := time.Now()
+	if err := client.Ping(ctx); err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+	latency := time.Since(start)
+
+	if g.json {
+		_ = json.NewEncoder(stdout).Encode(map[string]any{"ok": true, "latency_ms": latency.Milliseconds()})
+	} else {
+		fmt.Fprintf(stdout, "ok (%s)\n", latency.Round(time.Millisecond))
+	}
+	return 0
+}
+
+func runFonts(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var g globalFlags
+	fs := newFlagSet("fonts", stderr, "")
+	g.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	client, err := g.client()
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	caps, err := client.Capabilities(ctx)
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	fonts := slices.Clone(caps.Fonts)
+	slices.Sort(fonts)
+	if g.json {
+		_ = json.NewEncoder(stdout).Encode(fonts)
+		return 0
+	}
+	for _, font := range fonts {
+		fmt.Fprintln(stdout, font)
+	}
+	return 0
+}
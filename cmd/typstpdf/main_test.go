@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const fakePDF = "%PDF-1.7 fake"
+
+type gatewayRequest struct {
+	Content  string            `json:"content"`
+	Template string            `json:"template"`
+	Options  []string          `json:"options"`
+	Media    map[string]string `json:"media"`
+}
+
+// newFakeGateway renders every template except those containing "{{{",
+// which fail with a typst diagnostic.
+func newFakeGateway(t *testing.T) (*httptest.Server, *[]gatewayRequest) {
+	t.Helper()
+
+	var (
+		mu       sync.Mutex
+		requests []gatewayRequest
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+			return
+		case "/capabilities":
+			_ = json.NewEncoder(w).Encode(map[string]any{"fonts": []string{"Lato", "DejaVu Sans"}})
+			return
+		}
+
+		var req gatewayRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		template, _ := base64.StdEncoding.DecodeString(req.Template)
+		if strings.Contains(string(template), "{{{") {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error":   true,
+				"message": "compilation failed",
+				"stderr":  "main.typ:1:2: error: unknown variable: this",
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"pdf": base64.StdEncoding.EncodeToString([]byte(fakePDF))})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	server, requests := newFakeGateway(t)
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "report.typ"), "= Report")
+	writeFile(t, filepath.Join(dir, "assets", "img", "logo.png"), "png")
+	writeFile(t, filepath.Join(dir, "data.json"), `{"title":"Q3"}`)
+
+	out := filepath.Join(dir, "out.pdf")
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{
+		"compile",
+		"-endpoint", server.URL, "-auth-key", "test-key",
+		"-media", filepath.Join(dir, "assets"),
+		"-input", "lang=it",
+		"-data", filepath.Join(dir, "data.json"),
+		"-o", out,
+		filepath.Join(dir, "report.typ"),
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("compile exited with %d: %s", code, stderr.String())
+	}
+
+	if data, err := os.ReadFile(out); err != nil || string(data) != fakePDF {
+		t.Fatalf("Unexpected output %q: %v", data, err)
+	}
+
+	req := (*requests)[0]
+	if _, ok := req.Media["img/logo.png"]; !ok {
+		t.Errorf("Media directory not attached with relative names: %v", req.Media)
+	}
+	if _, ok := req.Media["data.json"]; !ok {
+		t.Errorf("Data file not attached: %v", req.Media)
+	}
+	for _, want := range []string{"--font-path=fonts", "data=data.json", "lang=it"} {
+		if !slices.Contains(req.Options, want) {
+			t.Errorf("Options %v missing %q", req.Options, want)
+		}
+	}
+}
+
+func TestCompileResolveMedia(t *testing.T) {
+	server, requests := newFakeGateway(t)
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "report.typ"), `#image("img/logo.png")`+"\n"+`#let rows = csv("rows.csv")`)
+	writeFile(t, filepath.Join(dir, "img", "logo.png"), "png")
+	writeFile(t, filepath.Join(dir, "rows.csv"), "a,b")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{
+		"compile", "-endpoint", server.URL, "-auth-key", "k", "-resolve-media",
+		"-o", filepath.Join(dir, "out.pdf"), filepath.Join(dir, "report.typ"),
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("compile exited with %d: %s", code, stderr.String())
+	}
+	for _, name := range []string{"img/logo.png", "rows.csv"} {
+		if _, ok := (*requests)[0].Media[name]; !ok {
+			t.Errorf("Referenced file %s not attached: %v", name, (*requests)[0].Media)
+		}
+	}
+
+	writeFile(t, filepath.Join(dir, "broken.typ"), `#image("missing.png")`)
+	stderr.Reset()
+	code = run(context.Background(), []string{"compile", "-endpoint", server.URL, "-auth-key", "k", "-resolve-media", filepath.Join(dir, "broken.typ")}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "main.typ:1:8: error: file not found: missing.png") {
+		t.Errorf("Expected a diagnostic for the missing file, got %d: %s", code, stderr.String())
+	}
+	if len(*requests) != 1 {
+		t.Errorf("Request sent despite unresolved media")
+	}
+}
+
+func TestVendorAndCompilePackages(t *testing.T) {
+	server, requests := newFakeGateway(t)
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+
+	writeFile(t, filepath.Join(cache, "preview", "report", "0.2.0", "lib.typ"), `#import "@preview/cetz:0.3.0": canvas`)
+	writeFile(t, filepath.Join(cache, "preview", "cetz", "0.3.0", "lib.typ"), `#let canvas(body) = body`)
+	writeFile(t, filepath.Join(dir, "doc", "report.typ"), `#import "lib/util.typ": *`)
+	writeFile(t, filepath.Join(dir, "doc", "lib", "util.typ"), `#import "@preview/report:0.2.0"`)
+
+	template := filepath.Join(dir, "doc", "report.typ")
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), []string{"vendor", "-cache", cache, template}, &stdout, &stderr); code != 0 {
+		t.Fatalf("vendor exited with %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "@preview/cetz:0.3.0 h1:") {
+		t.Errorf("Unexpected vendor output %q", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc", "packages", "preview", "cetz", "0.3.0", "lib.typ")); err != nil {
+		t.Errorf("Package not copied: %v", err)
+	}
+
+	stdout.Reset()
+	if code := run(context.Background(), []string{"vendor", "-check", template}, &stdout, &stderr); code != 0 {
+		t.Fatalf("vendor -check exited with %d: %s", code, stderr.String())
+	}
+	writeFile(t, filepath.Join(dir, "doc", "packages", "preview", "cetz", "0.3.0", "lib.typ"), "changed")
+	stderr.Reset()
+	if code := run(context.Background(), []string{"vendor", "-check", template}, &stdout, &stderr); code != 1 || !strings.Contains(stderr.String(), "does not match lockfile") {
+		t.Errorf("Expected a lockfile mismatch, got %d: %s", code, stderr.String())
+	}
+
+	code := run(context.Background(), []string{
+		"compile", "-endpoint", server.URL, "-auth-key", "k", "-resolve-media",
+		"-packages", filepath.Join(dir, "doc", "packages"), template,
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("compile exited with %d: %s", code, stderr.String())
+	}
+	req := (*requests)[0]
+	if _, ok := req.Media["packages/preview/report/0.2.0/lib.typ"]; !ok {
+		t.Errorf("Package not attached: %v", req.Media)
+	}
+	if !slices.Contains(req.Options, "--package-path=packages") {
+		t.Errorf("Options %v missing --package-path", req.Options)
+	}
+}
+
+func TestCompileFailurePrintsDiagnostics(t *testing.T) {
+	server, _ := newFakeGateway(t)
+	dir := t.TempDir()
+	template := filepath.Join(dir, "invalid.typ")
+	writeFile(t, template, "#this is not valid typst syntax {{{")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"compile", "-json", "-endpoint", server.URL, "-auth-key", "k", template}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("Expected exit code 1, got %d", code)
+	}
+
+	var f failure
+	if err := json.Unmarshal(stderr.Bytes(), &f); err != nil {
+		t.Fatalf("Failed to parse failure %q: %v", stderr.String(), err)
+	}
+	if len(f.Diagnostics) != 1 || f.Diagnostics[0].Line != 1 || f.CorrelationID == "" {
+		t.Errorf("Unexpected failure report: %+v", f)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "invalid.pdf")); !os.IsNotExist(err) {
+		t.Error("Output file should not be created on failure")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	server, _ := newFakeGateway(t)
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "a.typ"), "= A")
+	writeFile(t, filepath.Join(dir, "b.typ"), "= B {{{")
+	writeFile(t, filepath.Join(dir, "jobs.jsonl"), `{"template": "a.typ", "output": "out/a.pdf", "inputs": {"n": "1"}}
+# comment lines and blank lines are skipped
+
+{"template": "b.typ"}
+`)
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"batch", "-endpoint", server.URL, "-auth-key", "k", filepath.Join(dir, "jobs.jsonl")}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("Expected exit code 1 with one failing job, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "a.pdf")); err != nil {
+		t.Errorf("Expected a.pdf to be rendered: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "1 of 2 rendered") || !strings.Contains(stderr.String(), "b.typ") {
+		t.Errorf("Unexpected output:\nstdout: %s\nstderr: %s", stdout.String(), stderr.String())
+	}
+}
+
+func TestPingAndFonts(t *testing.T) {
+	server, _ := newFakeGateway(t)
+	t.Setenv("PDF_GENERATOR_AUTH_KEY", "env-key")
+
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), []string{"ping", "-endpoint", server.URL}, &stdout, &stderr); code != 0 {
+		t.Fatalf("ping exited with %d: %s", code, stderr.String())
+	}
+
+	stdout.Reset()
+	if code := run(context.Background(), []string{"fonts", "-endpoint", server.URL}, &stdout, &stderr); code != 0 {
+		t.Fatalf("fonts exited with %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "DejaVu Sans\nLato\n" {
+		t.Errorf("Unexpected fonts output %q", stdout.String())
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), nil, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2 without command, got %d", code)
+	}
+	if code := run(context.Background(), []string{"frobnicate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2 for unknown command, got %d", code)
+	}
+}
+
+func TestAttachFlagsOnlyOnCompile(t *testing.T) {
+	// watch and preview re-read their files on every render and would ignore
+	// these flags.
+	for _, cmd := range []string{"watch", "preview"} {
+		for _, flag := range []string{"-resolve-media", "-packages=packages"} {
+			var stdout, stderr bytes.Buffer
+			if code := run(context.Background(), []string{cmd, flag, "report.typ"}, &stdout, &stderr); code != 2 {
+				t.Errorf("%s %s: expected exit code 2, got %d", cmd, flag, code)
+			}
+		}
+	}
+}
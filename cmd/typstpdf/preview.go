@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/4sigma/typstpdfgenerator/preview"
+)
+
+func runPreview(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		g      globalFlags
+		jf     jobFlags
+		addr   string
+		format string
+		typst  string
+	)
+	fs := newFlagSet("preview", stderr, "template.typ")
+	g.register(fs)
+	jf.register(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "address to serve the preview on")
+	fs.StringVar(&format, "format", "svg", "page image format, svg or png")
+	fs.StringVar(&typst, "typst", "", "render with this local typst executable instead of the gateway, which must render images")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	j, err := jf.parse(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "typstpdf: %v\n", err)
+		return 2
+	}
+
+	srv := &preview.Server{
+		Template: j.Template,
+		Media:    j.mediaPaths(),
+		Content:  j.Content,
+		Options:  j.options(),
+		Format:   preview.Format(format),
+	}
+	if typst != "" {
+		srv.Backend = &preview.CommandBackend{Path: typst}
+	} else {
+		client, err := g.client()
+		if err != nil {
+			failure{Error: err.Error()}.print(stderr, g.json)
+			return 1
+		}
+		srv.Backend = &preview.ClientBackend{Client: client}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+	httpServer := &http.Server{Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = httpServer.Serve(ln) }()
+	fmt.Fprintf(stdout, "previewing %s on http://%s, press Ctrl+C to stop\n", j.Template, ln.Addr())
+
+	runErr := srv.Run(ctx)
+	// Open event streams only end when the browser disconnects.
+	_ = httpServer.Close()
+	if runErr != nil && !errors.Is(runErr, context.Canceled) {
+		failure{Error: runErr.Error()}.print(stderr, g.json)
+		return 1
+	}
+	return 0
+}
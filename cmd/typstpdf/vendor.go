@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// runVendor copies the packages a template imports from the package cache to
+// a directory that compile -packages attaches them from, and locks them.
+func runVendor(_ context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		asJSON bool
+		cache  string
+		out    string
+		lock   string
+		check  bool
+	)
+	fs := newFlagSet("vendor", stderr, "template.typ")
+	fs.BoolVar(&asJSON, "json", false, "print the lockfile as JSON")
+	fs.StringVar(&cache, "cache", "", "typst package cache or package path (default: the typst cache directory)")
+	fs.StringVar(&out, "o", "", "directory to copy the packages to (default: packages next to the template)")
+	fs.StringVar(&lock, "lock", "", "lockfile path (default: packages.lock next to the template)")
+	fs.BoolVar(&check, "check", false, "verify the vendored packages against the lockfile instead of copying them")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	template := fs.Arg(0)
+	dir := filepath.Dir(template)
+	if out == "" {
+		out = filepath.Join(dir, typstpdfgenerator.PackageDir)
+	}
+	if lock == "" {
+		lock = filepath.Join(dir, "packages.lock")
+	}
+	if cache == "" && !check {
+		var err error
+		if cache, err = typstpdfgenerator.DefaultPackageCacheDir(); err != nil {
+			failure{Error: err.Error(), Template: template}.print(stderr, asJSON)
+			return 1
+		}
+	}
+
+	var lockfile *typstpdfgenerator.Lockfile
+	var err error
+	if check {
+		lockfile, err = checkVendored(template, out, lock)
+	} else {
+		lockfile, err = vendor(template, cache, out, lock)
+	}
+	if err != nil {
+		failure{Error: err.Error(), Template: template}.print(stderr, asJSON)
+		return 1
+	}
+
+	if asJSON {
+		_ = json.NewEncoder(stdout).Encode(lockfile)
+		return 0
+	}
+	for _, p := range lockfile.Packages {
+		fmt.Fprintf(stdout, "%s %s\n", p.Package, p.Hash)
+	}
+	return 0
+}
+
+// vendoredPackages finds the packages template and its local modules import
+// in cache.
+func vendoredPackages(template, cache string) (*typstpdfgenerator.VendoredPackages, error) {
+	data, err := os.ReadFile(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	// Only the local modules matter here, missing data files are left to compile.
+	modules, _ := typstpdfgenerator.ResolveMediaDir(filepath.Dir(template), data, nil)
+	return typstpdfgenerator.VendorPackagesDir(cache, data, modules)
+}
+
+func vendor(template, cache, out, lock string) (*typstpdfgenerator.Lockfile, error) {
+	vendored, err := vendoredPackages(template, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range vendored.Media {
+		name := strings.TrimPrefix(m.Name, typstpdfgenerator.PackageDir+"/")
+		path := filepath.Join(out, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create package directory: %w", err)
+		}
+		if err := os.WriteFile(path, m.Data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write package file: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(vendored.Lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(lock, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	return &vendored.Lock, nil
+}
+
+// checkVendored verifies that out holds the packages the template imports, as
+// locked.
+func checkVendored(template, out, lock string) (*typstpdfgenerator.Lockfile, error) {
+	lockfile, err := typstpdfgenerator.ReadLockfile(lock)
+	if err != nil {
+		return nil, err
+	}
+	vendored, err := vendoredPackages(template, out)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockfile.Verify(vendored.Media); err != nil {
+		return nil, err
+	}
+	return &vendored.Lock, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+func runWatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		g        globalFlags
+		jf       jobFlags
+		interval time.Duration
+		debounce time.Duration
+	)
+	fs := newFlagSet("watch", stderr, "template.typ")
+	g.register(fs)
+	fs.StringVar(&jf.job.Output, "o", "", "output PDF path (default: template name with .pdf)")
+	jf.register(fs)
+	fs.DurationVar(&interval, "interval", 500*time.Millisecond, "polling interval")
+	fs.DurationVar(&debounce, "debounce", 200*time.Millisecond, "quiet period before re-rendering")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	j, err := jf.parse(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "typstpdf: %v\n", err)
+		return 2
+	}
+
+	client, err := g.client()
+	if err != nil {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+
+	w := &typstpdfgenerator.Watcher{
+		Client:   client,
+		Template: j.Template,
+		Media:    j.mediaPaths(),
+		Content:  j.Content,
+		Options:  j.options(),
+		Output:   j.outputPath(),
+		Interval: interval,
+		Debounce: debounce,
+		OnRender: func(ev typstpdfgenerator.WatchEvent) {
+			if ev.Err != nil {
+				newFailure(j.Template, ev.Info, ev.Err).print(stderr, g.json)
+				return
+			}
+			trigger := "initial render"
+			if len(ev.Changed) > 0 {
+				trigger = strings.Join(ev.Changed, ", ")
+			}
+			if g.json {
+				result{Template: j.Template, Output: j.outputPath(), Bytes: ev.Bytes, CorrelationID: ev.Info.CorrelationID}.print(stdout, true)
+				return
+			}
+			fmt.Fprintf(stdout, "%s: rendered %s (%d bytes) in %s\n", trigger, j.outputPath(), ev.Bytes, ev.Duration.Round(time.Millisecond))
+		},
+	}
+
+	if !g.json {
+		fmt.Fprintf(stdout, "watching %s, press Ctrl+C to stop\n", j.Template)
+	}
+	if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		failure{Error: err.Error()}.print(stderr, g.json)
+		return 1
+	}
+	return 0
+}
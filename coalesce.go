@@ -0,0 +1,111 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// WithRequestCoalescing enables in-flight deduplication of identical conversions.
+//
+// Concurrent Convert calls whose encoded requests are byte-for-byte identical
+// share a single gateway round trip. Every caller still receives the PDF on its
+// own io.Writer and keeps its own correlation ID; followers get the leader's ID
+// in ResponseInfo.LeaderCorrelationID and zero request and response byte
+// counts, so that observers count the shared transfer once.
+func WithRequestCoalescing() Option {
+	return func(c *Client) error {
+		c.flights = &flightGroup{}
+		return nil
+	}
+}
+
+// requestKey returns the canonical hash of an encoded typstRequest.
+//
+// encoding/json sorts map keys, so identical requests always encode to the
+// same bytes.
+func requestKey(jsonData []byte) string {
+	sum := sha256.Sum256(jsonData)
+	return hex.EncodeToString(sum[:])
+}
+
+type flightCall struct {
+	done chan struct{}
+	dups int
+
+	pdf  []byte
+	info ResponseInfo
+	err  error
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// join returns the in-flight call for key, registering a new one when none
+// exists. leader reports whether the caller is responsible for running it.
+func (g *flightGroup) join(key string) (call *flightCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		return call, false
+	}
+	call = &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+func (g *flightGroup) finish(key string, call *flightCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// do runs fn once per key among concurrent callers. Followers whose leader
+// gave up because its own context ended try again instead of inheriting the
+// cancellation, as long as their context is still alive.
+func (g *flightGroup) do(ctx context.Context, key, correlationID string, fn func() ([]byte, ResponseInfo, error)) ([]byte, ResponseInfo, error) {
+	for {
+		call, leader := g.join(key)
+		if leader {
+			func() {
+				defer g.finish(key, call)
+				call.err = errFlightAborted
+				call.pdf, call.info, call.err = fn()
+			}()
+			return call.pdf, call.info, call.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ResponseInfo{CorrelationID: correlationID}, &ConnectionError{Err: ctx.Err()}
+		case <-call.done:
+		}
+
+		if isContextError(call.err) && ctx.Err() == nil {
+			continue
+		}
+
+		info := call.info
+		info.CorrelationID = correlationID
+		info.LeaderCorrelationID = call.info.CorrelationID
+		// The leader's transfer is reported once, by the leader.
+		info.RequestBytes, info.ResponseBytes = 0, 0
+		return call.pdf, info, call.err
+	}
+}
+
+var errFlightAborted = &ConnectionError{Message: "coalesced conversion aborted"}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
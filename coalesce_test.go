@@ -0,0 +1,154 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const fakePDF = "%PDF-1.7 fake"
+
+func newFakeGateway(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writePDFResponse(w http.ResponseWriter, pdf string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(typstResponse{PDF: base64.StdEncoding.EncodeToString([]byte(pdf))})
+}
+
+func waitForDups(t *testing.T, g *flightGroup, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		dups := 0
+		for _, call := range g.calls {
+			dups += call.dups
+		}
+		g.mu.Unlock()
+		if dups >= want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d coalesced callers", want)
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	var hits atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			close(entered)
+		}
+		<-release
+		w.Header().Set("X-Correlation-ID", r.Header.Get("X-Correlation-ID"))
+		writePDFResponse(w, fakePDF)
+	})
+
+	client, err := New("test-key", server.URL, WithRequestCoalescing())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	const followers = 4
+	var (
+		wg      sync.WaitGroup
+		leader  ResponseInfo
+		results [followers]ResponseInfo
+		bufs    [followers]bytes.Buffer
+		errs    [followers]error
+	)
+
+	var leaderBuf bytes.Buffer
+	var leaderErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx := WithCorrelationID(context.Background(), "leader")
+		leader, leaderErr = client.Convert(ctx, &leaderBuf, "", []byte("= Hello"), nil, nil)
+	}()
+	<-entered
+
+	for i := 0; i < followers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := WithCorrelationID(context.Background(), "follower-"+string(rune('a'+i)))
+			results[i], errs[i] = client.Convert(ctx, &bufs[i], "", []byte("= Hello"), nil, nil)
+		}(i)
+	}
+	waitForDups(t, client.flights, followers)
+	close(release)
+	wg.Wait()
+
+	if leaderErr != nil {
+		t.Fatalf("Leader failed: %v", leaderErr)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected 1 gateway call, got %d", got)
+	}
+	if leader.CorrelationID != "leader" || leader.LeaderCorrelationID != "" || leader.RequestBytes == 0 || leader.ResponseBytes == 0 {
+		t.Errorf("Unexpected leader info: %+v", leader)
+	}
+	if leaderBuf.String() != fakePDF {
+		t.Errorf("Leader got %q", leaderBuf.String())
+	}
+
+	for i := 0; i < followers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Follower %d failed: %v", i, errs[i])
+		}
+		if want := "follower-" + string(rune('a'+i)); results[i].CorrelationID != want {
+			t.Errorf("Follower %d correlation ID = %q, want %q", i, results[i].CorrelationID, want)
+		}
+		if results[i].LeaderCorrelationID != "leader" {
+			t.Errorf("Follower %d leader correlation ID = %q", i, results[i].LeaderCorrelationID)
+		}
+		if results[i].RequestBytes != 0 || results[i].ResponseBytes != 0 {
+			t.Errorf("Follower %d counts the leader's transfer: %+v", i, results[i])
+		}
+		if bufs[i].String() != fakePDF {
+			t.Errorf("Follower %d got %q", i, bufs[i].String())
+		}
+	}
+}
+
+func TestRequestCoalescingDistinctRequests(t *testing.T) {
+	var hits atomic.Int32
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		writePDFResponse(w, fakePDF)
+	})
+
+	client, err := New("test-key", server.URL, WithRequestCoalescing())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	for _, tpl := range []string{"= One", "= Two"} {
+		var buf bytes.Buffer
+		if _, err := client.Convert(context.Background(), &buf, "", []byte(tpl), nil, nil); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+	}
+
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected 2 gateway calls, got %d", got)
+	}
+}
@@ -0,0 +1,509 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables read by
+// NewFromEnv when given an empty prefix.
+const DefaultEnvPrefix = "PDF_GENERATOR"
+
+// Config describes a client. It is usually loaded from a JSON file with
+// LoadConfig or from the environment by NewFromEnv:
+//
+//	{
+//		"gateway": "https://gateway.example.com/function/typst",
+//		"auth": {"type": "file", "file": "/run/secrets/typst", "scheme": "Bearer"},
+//		"timeout": "60s",
+//		"tls": {"ca_file": "ca.pem"},
+//		"retry": {"attempts": 3, "backoff": "200ms"},
+//		"options": ["--ignore-system-fonts", "--font-path=fonts"],
+//		"limits": {"max_request_bytes": 52428800},
+//		"cache": {"capabilities": "5m", "media_store": true, "coalesce": true}
+//	}
+type Config struct {
+	Gateway string      `json:"gateway"`
+	Auth    AuthConfig  `json:"auth"`
+	Timeout Duration    `json:"timeout,omitempty"`
+	TLS     TLSOptions  `json:"tls"`
+	Retry   RetryConfig `json:"retry"`
+	// Options replace DefaultOptions for conversions given none.
+	Options []string     `json:"options,omitempty"`
+	Limits  LimitsConfig `json:"limits"`
+	Cache   CacheConfig  `json:"cache"`
+}
+
+type AuthConfig struct {
+	// Type is "static" (the default), "bearer", "basic", "hmac" or "file".
+	Type string `json:"type,omitempty"`
+	// Key is the static key, bearer token or HMAC secret.
+	Key      string `json:"key,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// File, Scheme and TTL configure FileAuth.
+	File   string   `json:"file,omitempty"`
+	Scheme string   `json:"scheme,omitempty"`
+	TTL    Duration `json:"ttl,omitempty"`
+}
+
+type TLSOptions struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// RetryConfig configures WithRetry; zero attempts disables retries.
+type RetryConfig struct {
+	Attempts int      `json:"attempts,omitempty"`
+	Backoff  Duration `json:"backoff,omitempty"`
+}
+
+type LimitsConfig struct {
+	MaxRequestBytes int `json:"max_request_bytes,omitempty"`
+}
+
+type CacheConfig struct {
+	// Capabilities enables WithCapabilityCheck with this TTL.
+	Capabilities Duration `json:"capabilities,omitempty"`
+	MediaStore   bool     `json:"media_store,omitempty"`
+	Coalesce     bool     `json:"coalesce,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// FieldError is a single invalid configuration field.
+type FieldError struct {
+	// Field is the JSON path of the field, e.g. "tls.cert_file", or the name
+	// of the environment variable it was read from.
+	Field   string
+	Message string
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ConfigError lists every invalid field of a configuration.
+type ConfigError struct {
+	// Source is the configuration file or "environment".
+	Source string
+	Errors []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.String()
+	}
+	if e.Source != "" {
+		return fmt.Sprintf("invalid configuration in %s: %s", e.Source, strings.Join(msgs, "; "))
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// LoadConfig reads and validates a JSON configuration file. Relative file
+// paths in it are resolved against the file's directory.
+func LoadConfig(path string) (*Config, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		var cfgErr *ConfigError
+		if errors.As(err, &cfgErr) {
+			cfgErr.Source = path
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg Config
+	var errs []FieldError
+	decodeObject("", data, reflect.ValueOf(&cfg).Elem(), &errs)
+	if len(errs) > 0 {
+		return nil, &ConfigError{Source: path, Errors: errs}
+	}
+
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&cfg.Auth.File, &cfg.TLS.CAFile, &cfg.TLS.CertFile, &cfg.TLS.KeyFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return &cfg, nil
+}
+
+// decodeObject decodes the JSON object data into the struct v field by field,
+// so that every unknown or malformed field is reported rather than the first.
+func decodeObject(path string, data []byte, v reflect.Value, errs *[]FieldError) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		*errs = append(*errs, FieldError{Field: path, Message: decodeMessage(err)})
+		return
+	}
+
+	known := make(map[string]reflect.Value)
+	t := v.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		known[name] = v.Field(i)
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		field, ok := known[name]
+		if !ok {
+			*errs = append(*errs, FieldError{Field: fieldPath, Message: "unknown field"})
+			continue
+		}
+
+		raw := fields[name]
+		if field.Kind() == reflect.Struct && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			decodeObject(fieldPath, raw, field, errs)
+			continue
+		}
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			*errs = append(*errs, FieldError{Field: fieldPath, Message: decodeMessage(err)})
+		}
+	}
+}
+
+// decodeMessage describes a JSON decoding error in JSON rather than Go terms.
+func decodeMessage(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err.Error()
+	}
+	want := "a " + typeErr.Type.Kind().String()
+	switch typeErr.Type.Kind() {
+	case reflect.String:
+		want = "a string"
+	case reflect.Bool:
+		want = "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		want = "a number"
+	case reflect.Slice:
+		want = "an array"
+	case reflect.Map, reflect.Struct:
+		want = "an object"
+	}
+	return fmt.Sprintf("expected %s, got %s", want, typeErr.Value)
+}
+
+// Validate checks the configuration and reports every invalid field in a
+// *ConfigError.
+func (cfg *Config) Validate() error {
+	var errs []FieldError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.Gateway == "" {
+		add("gateway", "must be set")
+	} else if u, err := url.Parse(cfg.Gateway); err != nil {
+		add("gateway", "invalid URL: %v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		add("gateway", "scheme must be http or https")
+	}
+
+	switch cfg.Auth.Type {
+	case "", "static", "bearer", "hmac":
+		if cfg.Auth.Key == "" {
+			add("auth.key", "must be set for auth type %q", cfg.Auth.typeName())
+		}
+	case "basic":
+		if cfg.Auth.Username == "" {
+			add("auth.username", "must be set for auth type \"basic\"")
+		}
+	case "file":
+		if cfg.Auth.File == "" {
+			add("auth.file", "must be set for auth type \"file\"")
+		}
+	default:
+		add("auth.type", "unknown auth type %q, expected static, bearer, basic, hmac or file", cfg.Auth.Type)
+	}
+	if cfg.Auth.TTL < 0 {
+		add("auth.ttl", "cannot be negative")
+	}
+
+	if cfg.Timeout < 0 {
+		add("timeout", "cannot be negative")
+	}
+
+	files := []struct{ field, path string }{
+		{"auth.file", cfg.Auth.File},
+		{"tls.ca_file", cfg.TLS.CAFile},
+		{"tls.cert_file", cfg.TLS.CertFile},
+		{"tls.key_file", cfg.TLS.KeyFile},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			add(f.field, "%v", err)
+		}
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		add("tls", "cert_file and key_file must be set together")
+	}
+
+	if cfg.Retry.Attempts < 0 {
+		add("retry.attempts", "cannot be negative")
+	}
+	if cfg.Retry.Backoff < 0 {
+		add("retry.backoff", "cannot be negative")
+	}
+
+	for i, opt := range cfg.Options {
+		if strings.TrimSpace(opt) == "" {
+			add(fmt.Sprintf("options[%d]", i), "cannot be empty")
+		}
+	}
+
+	if cfg.Limits.MaxRequestBytes < 0 {
+		add("limits.max_request_bytes", "cannot be negative")
+	}
+	if cfg.Cache.Capabilities < 0 {
+		add("cache.capabilities", "cannot be negative")
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+	return nil
+}
+
+func (a AuthConfig) typeName() string {
+	if a.Type == "" {
+		return "static"
+	}
+	return a.Type
+}
+
+// NewFromConfig validates cfg and creates a client from it. opts are applied
+// after the configuration and take precedence over it.
+func NewFromConfig(cfg *Config, opts ...Option) (*Client, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	provider, err := cfg.Auth.provider()
+	if err != nil {
+		return nil, err
+	}
+	options := []Option{WithAuthProvider(provider)}
+
+	if cfg.Timeout > 0 {
+		options = append(options, WithTimeout(time.Duration(cfg.Timeout)))
+	}
+	if cfg.TLS.InsecureSkipVerify {
+		options = append(options, WithInsecureSkipVerify())
+	}
+	if cfg.TLS.CAFile != "" {
+		options = append(options, WithCAFile(cfg.TLS.CAFile))
+	}
+	if cfg.TLS.CertFile != "" {
+		options = append(options, WithClientCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+	if cfg.Retry.Attempts > 0 {
+		options = append(options, WithRetry(cfg.Retry.Attempts, time.Duration(cfg.Retry.Backoff)))
+	}
+	if len(cfg.Options) > 0 {
+		options = append(options, WithDefaultOptions(cfg.Options...))
+	}
+	if cfg.Limits.MaxRequestBytes > 0 {
+		options = append(options, WithMaxRequestSize(cfg.Limits.MaxRequestBytes))
+	}
+	if cfg.Cache.Capabilities > 0 {
+		options = append(options, WithCapabilityCheck(time.Duration(cfg.Cache.Capabilities)))
+	}
+	if cfg.Cache.MediaStore {
+		options = append(options, WithMediaStore())
+	}
+	if cfg.Cache.Coalesce {
+		options = append(options, WithRequestCoalescing())
+	}
+
+	return New("", cfg.Gateway, append(options, opts...)...)
+}
+
+func (a AuthConfig) provider() (AuthProvider, error) {
+	switch a.Type {
+	case "bearer":
+		return BearerAuth(a.Key)
+	case "basic":
+		return BasicAuth(a.Username, a.Password)
+	case "hmac":
+		return NewHMACSigner([]byte(a.Key))
+	case "file":
+		return FileAuth(a.File, a.Scheme, time.Duration(a.TTL))
+	default:
+		return StaticAuth(a.Key)
+	}
+}
+
+// NewFromEnv creates a client from environment variables named after prefix,
+// DefaultEnvPrefix if empty:
+//
+//	<prefix>_CONFIG                 JSON file loaded first, see LoadConfig
+//	<prefix>_ENDPOINT               gateway URL
+//	<prefix>_AUTH_KEY               static key, bearer token or HMAC secret
+//	<prefix>_AUTH_TYPE              static, bearer, basic, hmac or file
+//	<prefix>_AUTH_USERNAME          basic auth username
+//	<prefix>_AUTH_PASSWORD          basic auth password
+//	<prefix>_AUTH_FILE              file holding the credential
+//	<prefix>_TIMEOUT                request timeout, e.g. "60s"
+//	<prefix>_CA_FILE                PEM CA bundle
+//	<prefix>_CERT_FILE              PEM client certificate
+//	<prefix>_KEY_FILE               PEM client key
+//	<prefix>_INSECURE_SKIP_VERIFY   true to skip TLS verification
+//	<prefix>_RETRY_ATTEMPTS         total attempts for transient failures
+//	<prefix>_RETRY_BACKOFF          initial wait between attempts
+//	<prefix>_OPTIONS                space-separated default typst options
+//	<prefix>_MAX_REQUEST_BYTES      largest request to send
+//
+// Variables override the values of the configuration file. opts are applied
+// last and take precedence over both.
+func NewFromEnv(prefix string, opts ...Option) (*Client, error) {
+	cfg, errs, err := configFromEnv(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report malformed variables together with the invalid fields.
+	var cfgErr *ConfigError
+	if err := cfg.Validate(); errors.As(err, &cfgErr) {
+		errs = append(errs, cfgErr.Errors...)
+	}
+	if len(errs) > 0 {
+		return nil, &ConfigError{Source: "environment", Errors: errs}
+	}
+	return NewFromConfig(cfg, opts...)
+}
+
+// configFromEnv reads the configuration of NewFromEnv, returning the
+// variables that could not be parsed.
+func configFromEnv(prefix string) (*Config, []FieldError, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+
+	cfg := &Config{}
+	if path := os.Getenv(prefix + "CONFIG"); path != "" {
+		var err error
+		if cfg, err = readConfig(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	str := func(dst *string) func(string) error {
+		return func(v string) error { *dst = v; return nil }
+	}
+	duration := func(dst *Duration) func(string) error {
+		return func(v string) error {
+			d, err := time.ParseDuration(v)
+			*dst = Duration(d)
+			return err
+		}
+	}
+	integer := func(dst *int) func(string) error {
+		return func(v string) error {
+			n, err := strconv.Atoi(v)
+			*dst = n
+			return err
+		}
+	}
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"ENDPOINT", str(&cfg.Gateway)},
+		{"AUTH_KEY", str(&cfg.Auth.Key)},
+		{"AUTH_TYPE", str(&cfg.Auth.Type)},
+		{"AUTH_USERNAME", str(&cfg.Auth.Username)},
+		{"AUTH_PASSWORD", str(&cfg.Auth.Password)},
+		{"AUTH_FILE", str(&cfg.Auth.File)},
+		{"TIMEOUT", duration(&cfg.Timeout)},
+		{"CA_FILE", str(&cfg.TLS.CAFile)},
+		{"CERT_FILE", str(&cfg.TLS.CertFile)},
+		{"KEY_FILE", str(&cfg.TLS.KeyFile)},
+		{"INSECURE_SKIP_VERIFY", func(v string) error {
+			b, err := strconv.ParseBool(v)
+			cfg.TLS.InsecureSkipVerify = b
+			return err
+		}},
+		{"RETRY_ATTEMPTS", integer(&cfg.Retry.Attempts)},
+		{"RETRY_BACKOFF", duration(&cfg.Retry.Backoff)},
+		{"OPTIONS", func(v string) error { cfg.Options = strings.Fields(v); return nil }},
+		{"MAX_REQUEST_BYTES", integer(&cfg.Limits.MaxRequestBytes)},
+	}
+
+	var errs []FieldError
+	for _, v := range vars {
+		value, ok := os.LookupEnv(prefix + v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			errs = append(errs, FieldError{Field: prefix + v.name, Message: err.Error()})
+		}
+	}
+	return cfg, errs, nil
+}
@@ -0,0 +1,194 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "typst.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeClientCert(t, dir, "client", 1)
+	path := writeConfig(t, dir, `{
+		"gateway": "https://gateway.example.com/typst",
+		"auth": {"type": "bearer", "key": "secret"},
+		"timeout": "45s",
+		"tls": {"ca_file": "client.crt", "cert_file": "client.crt", "key_file": "client.key"},
+		"retry": {"attempts": 3, "backoff": "100ms"},
+		"options": ["--ignore-system-fonts"],
+		"limits": {"max_request_bytes": 1024},
+		"cache": {"capabilities": "5m", "media_store": true, "coalesce": true}
+	}`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if time.Duration(cfg.Timeout) != 45*time.Second || time.Duration(cfg.Retry.Backoff) != 100*time.Millisecond {
+		t.Errorf("Unexpected durations: timeout=%v backoff=%v", cfg.Timeout, cfg.Retry.Backoff)
+	}
+	if cfg.TLS.CAFile != filepath.Join(dir, "client.crt") {
+		t.Errorf("Expected CA file relative to config, got %q", cfg.TLS.CAFile)
+	}
+
+	client, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if client.httpClient.Timeout != 45*time.Second || client.retryAttempts != 3 || client.maxRequestBytes != 1024 {
+		t.Errorf("Config not applied: timeout=%v retries=%d limit=%d", client.httpClient.Timeout, client.retryAttempts, client.maxRequestBytes)
+	}
+	if client.caps == nil || client.mediaStore == nil || client.flights == nil {
+		t.Error("Cache settings not applied")
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `{
+		"gateway": "ftp://gateway.example.com",
+		"auth": {"type": "bearer"},
+		"timeout": "-1s",
+		"tls": {"cert_file": "missing.crt"},
+		"retry": {"attempts": -1}
+	}`)
+
+	_, err := LoadConfig(path)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected ErrInvalidConfig, got %v", err)
+	}
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected *ConfigError, got %T", err)
+	}
+	if cfgErr.Source != path {
+		t.Errorf("Expected source %q, got %q", path, cfgErr.Source)
+	}
+
+	var fields []string
+	for _, fe := range cfgErr.Errors {
+		fields = append(fields, fe.Field)
+	}
+	want := []string{"gateway", "auth.key", "timeout", "tls.cert_file", "tls", "retry.attempts"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("Expected errors for %v, got %v", want, fields)
+	}
+}
+
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `{"gateway": "https://gateway.example.com", "auth": {"key": "k"}, "gatway": "typo"}`)
+
+	_, err := LoadConfig(path)
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "gatway") {
+		t.Errorf("Expected error naming the unknown field, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsAllDecodeErrors(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `{
+		"gateway": 42,
+		"gatway": "typo",
+		"timeout": 30,
+		"tls": {"insecure_skip_verify": "yes", "ca": "ca.pem"},
+		"options": ["--ok", 1],
+		"cache": []
+	}`)
+
+	_, err := LoadConfig(path)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected *ConfigError, got %v", err)
+	}
+	got := make([]string, len(cfgErr.Errors))
+	for i, fe := range cfgErr.Errors {
+		got[i] = fe.String()
+	}
+	want := []string{
+		"cache: expected an object, got array",
+		"gateway: expected a string, got number",
+		"gatway: unknown field",
+		`options: expected a string, got number`,
+		`timeout: duration must be a string such as "30s"`,
+		"tls.ca: unknown field",
+		"tls.insecure_skip_verify: expected a boolean, got string",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Errors = %q\nwant %q", got, want)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	var authHeader string
+	var options []string
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		var req typstRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		options = req.Options
+		writePDFResponse(w, fakePDF)
+	})
+
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `{"gateway": "https://unused.example.com", "options": ["--from-file"]}`)
+	t.Setenv("TYPST_CONFIG", path)
+	t.Setenv("TYPST_ENDPOINT", server.URL)
+	t.Setenv("TYPST_AUTH_KEY", "env-key")
+	t.Setenv("TYPST_AUTH_TYPE", "bearer")
+	t.Setenv("TYPST_OPTIONS", "--ignore-system-fonts --font-path=fonts")
+
+	client, err := NewFromEnv("TYPST")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if authHeader != "Bearer env-key" {
+		t.Errorf("Expected bearer auth from environment, got %q", authHeader)
+	}
+	if !slices.Equal(options, []string{"--ignore-system-fonts", "--font-path=fonts"}) {
+		t.Errorf("Expected default options from environment, got %v", options)
+	}
+}
+
+func TestNewFromEnvReportsAllErrors(t *testing.T) {
+	t.Setenv("PDF_GENERATOR_ENDPOINT", "")
+	t.Setenv("PDF_GENERATOR_AUTH_KEY", "key")
+	t.Setenv("PDF_GENERATOR_TIMEOUT", "soon")
+	t.Setenv("PDF_GENERATOR_RETRY_ATTEMPTS", "many")
+
+	_, err := NewFromEnv("")
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Expected *ConfigError, got %v", err)
+	}
+
+	var fields []string
+	for _, fe := range cfgErr.Errors {
+		fields = append(fields, fe.Field)
+	}
+	want := []string{"PDF_GENERATOR_TIMEOUT", "PDF_GENERATOR_RETRY_ATTEMPTS", "gateway"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("Expected errors for %v, got %v", want, fields)
+	}
+}
@@ -0,0 +1,100 @@
+package typstpdfgenerator
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a compiler message parsed from typst's
+// --diagnostic-format=short output.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Severity string   `json:"severity"`
+	Message  string   `json:"message"`
+	Hints    []string `json:"hints,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			b.WriteString(":" + strconv.Itoa(d.Line))
+			if d.Column > 0 {
+				b.WriteString(":" + strconv.Itoa(d.Column))
+			}
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(d.Severity + ": " + d.Message)
+	for _, hint := range d.Hints {
+		b.WriteString("\n  hint: " + hint)
+	}
+	return b.String()
+}
+
+var diagnosticLine = regexp.MustCompile(`^(.+?):(\d+):(\d+): (error|warning): (.*)$`)
+
+// ParseDiagnostics extracts diagnostics from typst output in the short
+// diagnostic format, e.g. "main.typ:3:7: error: unknown variable: foo".
+// Hint lines are attached to the preceding diagnostic; other lines are ignored.
+func ParseDiagnostics(output string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if m := diagnosticLine.FindStringSubmatch(line); m != nil {
+			lineNo, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			diags = append(diags, Diagnostic{File: m[1], Line: lineNo, Column: col, Severity: m[4], Message: m[5]})
+			continue
+		}
+
+		if severity, msg, ok := strings.Cut(line, ": "); ok && (severity == "error" || severity == "warning") {
+			diags = append(diags, Diagnostic{Severity: severity, Message: msg})
+			continue
+		}
+
+		if hint, ok := strings.CutPrefix(line, "hint: "); ok && len(diags) > 0 {
+			last := &diags[len(diags)-1]
+			last.Hints = append(last.Hints, hint)
+		}
+	}
+	return diags
+}
+
+// DiagnosticsFromError collects diagnostics from a failed conversion: the
+// compiler's stderr, the message of a *NotGeneratedError or *HTTPError, or a
+// *PreprocessError or *ResolveError.
+func DiagnosticsFromError(info ResponseInfo, err error) []Diagnostic {
+	var preprocessErr *PreprocessError
+	if errors.As(err, &preprocessErr) {
+		return []Diagnostic{preprocessErr.Diagnostic()}
+	}
+	var resolveErr *ResolveError
+	if errors.As(err, &resolveErr) {
+		return resolveErr.Diagnostics()
+	}
+
+	diags := ParseDiagnostics(info.Stderr)
+	if len(diags) > 0 {
+		return diags
+	}
+
+	var notGenerated *NotGeneratedError
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &notGenerated):
+		diags = ParseDiagnostics(notGenerated.Message)
+	case errors.As(err, &httpErr):
+		diags = ParseDiagnostics(httpErr.Body)
+	}
+	return diags
+}
@@ -0,0 +1,40 @@
+package typstpdfgenerator
+
+import (
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := `main.typ:1:2: error: unknown variable: this
+  hint: if you meant to display multiple letters as is, try adding spaces
+C:\templates\main.typ:4:10: warning: unused label
+error: file not found (searched at fonts/Lato.ttf)
+compiled with errors`
+
+	diags := ParseDiagnostics(output)
+	if len(diags) != 3 {
+		t.Fatalf("Expected 3 diagnostics, got %d: %+v", len(diags), diags)
+	}
+
+	first := diags[0]
+	if first.File != "main.typ" || first.Line != 1 || first.Column != 2 || first.Severity != "error" || first.Message != "unknown variable: this" {
+		t.Errorf("Unexpected first diagnostic: %+v", first)
+	}
+	if len(first.Hints) != 1 {
+		t.Errorf("Expected hint to be attached: %+v", first)
+	}
+	if diags[1].File != `C:\templates\main.typ` || diags[1].Severity != "warning" {
+		t.Errorf("Unexpected second diagnostic: %+v", diags[1])
+	}
+	if diags[2].File != "" || diags[2].Message != "file not found (searched at fonts/Lato.ttf)" {
+		t.Errorf("Unexpected third diagnostic: %+v", diags[2])
+	}
+}
+
+func TestDiagnosticsFromError(t *testing.T) {
+	err := &NotGeneratedError{Message: "main.typ:1:1: error: expected expression"}
+	diags := DiagnosticsFromError(ResponseInfo{}, err)
+	if len(diags) != 1 || diags[0].Line != 1 {
+		t.Errorf("Unexpected diagnostics: %+v", diags)
+	}
+}
@@ -0,0 +1,164 @@
+package typstpdfgenerator
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// fontName holds the names of a font face read from its name table.
+type fontName struct {
+	Family string
+	Style  string
+}
+
+var errInvalidFont = errors.New("not a TrueType, OpenType or collection font")
+
+// readFontNames returns the names of the faces in a TrueType, OpenType or
+// font collection file. The typographic family and subfamily are preferred,
+// as typst does, so "Lato Black" is family "Lato" with style "Black".
+func readFontNames(data []byte) ([]fontName, error) {
+	if len(data) < 12 {
+		return nil, errInvalidFont
+	}
+	if string(data[:4]) != "ttcf" {
+		name, err := readFaceNames(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []fontName{name}, nil
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	if count == 0 || 12+4*count > len(data) {
+		return nil, errInvalidFont
+	}
+	names := make([]fontName, 0, count)
+	for i := range count {
+		name, err := readFaceNames(data, int(binary.BigEndian.Uint32(data[12+4*i:])))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// readFaceNames reads the names of the face whose table directory starts at
+// offset.
+func readFaceNames(data []byte, offset int) (fontName, error) {
+	if offset < 0 || offset+12 > len(data) {
+		return fontName{}, errInvalidFont
+	}
+	switch string(data[offset : offset+4]) {
+	case "\x00\x01\x00\x00", "OTTO", "true":
+	default:
+		return fontName{}, errInvalidFont
+	}
+
+	numTables := int(binary.BigEndian.Uint16(data[offset+4:]))
+	for i := range numTables {
+		rec := offset + 12 + 16*i
+		if rec+16 > len(data) {
+			return fontName{}, errInvalidFont
+		}
+		if string(data[rec:rec+4]) != "name" {
+			continue
+		}
+		start := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if start+length > len(data) || start+length < start {
+			return fontName{}, errInvalidFont
+		}
+		return parseNameTable(data[start : start+length])
+	}
+	return fontName{}, errors.New("font has no name table")
+}
+
+// Name IDs of the name table.
+const (
+	nameFamily            = 1
+	nameSubfamily         = 2
+	nameTypographicFamily = 16
+	nameTypographicStyle  = 17
+)
+
+func parseNameTable(table []byte) (fontName, error) {
+	if len(table) < 6 {
+		return fontName{}, errInvalidFont
+	}
+	count := int(binary.BigEndian.Uint16(table[2:]))
+	storage := int(binary.BigEndian.Uint16(table[4:]))
+
+	// Keep the best-ranked string of each name ID: Windows English first, then
+	// any Windows or Unicode string, then Macintosh Roman.
+	names := make(map[uint16]string)
+	ranks := make(map[uint16]int)
+	for i := range count {
+		rec := 6 + 12*i
+		if rec+12 > len(table) {
+			return fontName{}, errInvalidFont
+		}
+		platform := binary.BigEndian.Uint16(table[rec:])
+		encoding := binary.BigEndian.Uint16(table[rec+2:])
+		language := binary.BigEndian.Uint16(table[rec+4:])
+		id := binary.BigEndian.Uint16(table[rec+6:])
+		length := int(binary.BigEndian.Uint16(table[rec+8:]))
+		start := storage + int(binary.BigEndian.Uint16(table[rec+10:]))
+		if id != nameFamily && id != nameSubfamily && id != nameTypographicFamily && id != nameTypographicStyle {
+			continue
+		}
+		if start+length > len(table) {
+			continue
+		}
+		raw := table[start : start+length]
+
+		var rank int
+		var s string
+		switch {
+		case platform == 3 && (encoding == 1 || encoding == 10):
+			rank, s = 2, decodeUTF16(raw)
+			if language == 0x409 {
+				rank = 3
+			}
+		case platform == 0:
+			rank, s = 2, decodeUTF16(raw)
+		case platform == 1 && encoding == 0:
+			rank, s = 1, decodeLatin1(raw)
+		default:
+			continue
+		}
+		if s != "" && rank > ranks[id] {
+			names[id], ranks[id] = s, rank
+		}
+	}
+
+	name := fontName{Family: names[nameTypographicFamily], Style: names[nameTypographicStyle]}
+	if name.Family == "" {
+		name.Family = names[nameFamily]
+	}
+	if name.Style == "" {
+		name.Style = names[nameSubfamily]
+	}
+	if name.Family == "" {
+		return fontName{}, errors.New("font has no family name")
+	}
+	return name, nil
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return strings.TrimSpace(string(utf16.Decode(units)))
+}
+
+func decodeLatin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return strings.TrimSpace(string(r))
+}
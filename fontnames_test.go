@@ -0,0 +1,41 @@
+package typstpdfgenerator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFontNames(t *testing.T) {
+	tests := []struct {
+		file   string
+		family string
+		style  string
+	}{
+		{"Lato-Regular.ttf", "Lato", "Regular"},
+		{"Lato-Black.ttf", "Lato", "Black"},
+		{"Lato-LightItalic.ttf", "Lato", "Light Italic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(testTypstDir, "elspub", "fonts", tt.file))
+			if err != nil {
+				t.Fatalf("Failed to read font: %v", err)
+			}
+			names, err := readFontNames(data)
+			if err != nil {
+				t.Fatalf("Failed to read font names: %v", err)
+			}
+			if len(names) != 1 || names[0].Family != tt.family || names[0].Style != tt.style {
+				t.Errorf("readFontNames() = %+v, want %s %s", names, tt.family, tt.style)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{nil, []byte("not a font at all"), []byte("ttcf\x00\x01\x00\x00\x00\x00\x00\x05")} {
+		if _, err := readFontNames(data); err == nil {
+			t.Errorf("readFontNames(%q) succeeded, want error", data)
+		}
+	}
+}
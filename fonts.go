@@ -0,0 +1,210 @@
+package typstpdfgenerator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// FontDir is the directory fonts are attached under, the --font-path of
+// DefaultOptions.
+const FontDir = "fonts"
+
+// ErrFontNotFound is returned when a template uses a font that is not
+// available to the compiler.
+var ErrFontNotFound = errors.New("font not found")
+
+// FontError lists the font families used by a template that are missing.
+type FontError struct {
+	Families []string
+}
+
+func (e *FontError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrFontNotFound, strings.Join(e.Families, ", "))
+}
+
+func (e *FontError) Unwrap() error {
+	return ErrFontNotFound
+}
+
+// FontFace is a face of a font file, named as typst matches it.
+type FontFace struct {
+	Family string
+	Style  string
+}
+
+// Font is a font file and the faces it contains.
+type Font struct {
+	// Name is the file name, relative to FontDir.
+	Name  string
+	Data  []byte
+	Faces []FontFace
+}
+
+// FontSet is a set of TrueType, OpenType and collection fonts shipped with
+// requests, so that templates do not depend on the gateway's system fonts.
+type FontSet struct {
+	fonts []Font
+}
+
+// NewFontSet returns an empty font set.
+func NewFontSet() *FontSet {
+	return &FontSet{}
+}
+
+// Add adds the font file data under name, replacing a font of the same name.
+func (s *FontSet) Add(name string, data []byte) error {
+	names, err := readFontNames(data)
+	if err != nil {
+		return fmt.Errorf("failed to read font %s: %w", name, err)
+	}
+	font := Font{Name: name, Data: data, Faces: make([]FontFace, len(names))}
+	for i, n := range names {
+		font.Faces[i] = FontFace{Family: n.Family, Style: n.Style}
+	}
+
+	if i := slices.IndexFunc(s.fonts, func(f Font) bool { return f.Name == name }); i >= 0 {
+		s.fonts[i] = font
+		return nil
+	}
+	s.fonts = append(s.fonts, font)
+	return nil
+}
+
+// LoadFile adds a font file, named by its base name.
+func (s *FontSet) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read font: %w", err)
+	}
+	return s.Add(filepath.Base(path), data)
+}
+
+// LoadDir adds the font files below dir; other files are ignored.
+func (s *FontSet) LoadDir(dir string) error {
+	return s.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS adds the font files below dir in fsys, named by their paths relative
+// to dir.
+func (s *FontSet) LoadFS(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isFontFile(p) {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("failed to read font: %w", err)
+		}
+		name := p
+		if dir != "." {
+			name = strings.TrimPrefix(p, dir+"/")
+		}
+		return s.Add(name, data)
+	})
+}
+
+// Fonts returns the fonts of the set in the order they were added.
+func (s *FontSet) Fonts() []Font {
+	return slices.Clone(s.fonts)
+}
+
+// Families returns the sorted family names of the set.
+func (s *FontSet) Families() []string {
+	var families []string
+	for _, f := range s.fonts {
+		for _, face := range f.Faces {
+			if !slices.Contains(families, face.Family) {
+				families = append(families, face.Family)
+			}
+		}
+	}
+	slices.Sort(families)
+	return families
+}
+
+// HasFamily reports whether the set has a face of family. Families are
+// matched case-insensitively, as typst does.
+func (s *FontSet) HasFamily(family string) bool {
+	for _, f := range s.fonts {
+		for _, face := range f.Faces {
+			if strings.EqualFold(face.Family, family) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Media returns the fonts as media files under FontDir.
+func (s *FontSet) Media() []MediaFile {
+	media := make([]MediaFile, len(s.fonts))
+	for i, f := range s.fonts {
+		media[i] = MediaFile{Name: path.Join(FontDir, f.Name), Data: f.Data}
+	}
+	return media
+}
+
+// Verify checks that every font family named in a font argument of template
+// is in the set or embedded in typst, and returns a *FontError listing the
+// missing ones.
+func (s *FontSet) Verify(template []byte) error {
+	return s.verify(template, true)
+}
+
+func (s *FontSet) verify(template []byte, embedded bool) error {
+	masked, _ := scanTypst(templateName, template)
+	var missing []string
+	for _, ref := range fontReferences(templateName, masked) {
+		family := ref.Literal
+		if s.HasFamily(family) || embedded && slices.Contains(embeddedFonts, strings.ToLower(family)) {
+			continue
+		}
+		if !slices.ContainsFunc(missing, func(m string) bool { return strings.EqualFold(m, family) }) {
+			missing = append(missing, family)
+		}
+	}
+	if len(missing) > 0 {
+		return &FontError{Families: missing}
+	}
+	return nil
+}
+
+// WithFontSet makes Convert attach the fonts of set under FontDir, unless
+// media of the same name are passed. When --ignore-system-fonts is in effect,
+// conversions using fonts that are missing from the set fail with a
+// *FontError before anything is sent.
+func WithFontSet(set *FontSet) Option {
+	return func(c *Client) error {
+		c.fonts = set
+		return nil
+	}
+}
+
+func (c *Client) attachFonts(templateData []byte, options []string, media []MediaFile) ([]MediaFile, error) {
+	if c.fonts == nil {
+		return media, nil
+	}
+	if slices.Contains(options, "--ignore-system-fonts") {
+		if err := c.fonts.verify(templateData, !slices.Contains(options, "--ignore-embedded-fonts")); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool, len(media))
+	for _, m := range media {
+		names[cleanMediaName(m.Name)] = true
+	}
+	media = slices.Clip(media)
+	for _, f := range c.fonts.Media() {
+		if !names[f.Name] {
+			media = append(media, f)
+		}
+	}
+	return media, nil
+}
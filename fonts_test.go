@@ -0,0 +1,88 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFontSet(t *testing.T) {
+	set := NewFontSet()
+	if err := set.LoadDir(filepath.Join(testTypstDir, "elspub", "fonts")); err != nil {
+		t.Fatalf("Failed to load fonts: %v", err)
+	}
+	if got := set.Families(); !slices.Equal(got, []string{"Lato"}) {
+		t.Errorf("Families() = %v, want [Lato]", got)
+	}
+	if !set.HasFamily("lato") {
+		t.Error("HasFamily is case-sensitive")
+	}
+	for _, m := range set.Media() {
+		if filepath.Dir(m.Name) != FontDir || filepath.Ext(m.Name) != ".ttf" {
+			t.Errorf("Unexpected font media %s", m.Name)
+		}
+	}
+	if err := set.Add("broken.ttf", []byte("not a font")); err == nil {
+		t.Error("Expected error adding an invalid font")
+	}
+
+	if err := set.Verify([]byte(`#set text(font: ("Lato", "Libertinus Serif"))`)); err != nil {
+		t.Errorf("Failed to verify fonts: %v", err)
+	}
+	err := set.Verify([]byte(`#set text(font: "Liberation Sans") #text(font: "liberation sans")[x] #text(font: "Noto")[y]`))
+	var fontErr *FontError
+	if !errors.As(err, &fontErr) || !errors.Is(err, ErrFontNotFound) {
+		t.Fatalf("Expected FontError, got %v", err)
+	}
+	if !slices.Equal(fontErr.Families, []string{"Liberation Sans", "Noto"}) {
+		t.Errorf("Missing families = %v", fontErr.Families)
+	}
+}
+
+func TestWithFontSet(t *testing.T) {
+	var got map[string]string
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var req typstRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		got = req.Media
+		writePDFResponse(w, fakePDF)
+	})
+
+	set := NewFontSet()
+	if err := set.LoadFile(filepath.Join(testTypstDir, "elspub", "fonts", "Lato-Regular.ttf")); err != nil {
+		t.Fatalf("Failed to load font: %v", err)
+	}
+	client, err := New("test-key", server.URL, WithFontSet(set))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte(`#set text(font: "Lato")`), nil, nil); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if _, ok := got["fonts/Lato-Regular.ttf"]; !ok {
+		t.Errorf("Font not attached: %v", slices.Sorted(maps.Keys(got)))
+	}
+
+	got = nil
+	_, err = client.Convert(context.Background(), &buf, "", []byte(`#set text(font: "Inter")`), nil, nil)
+	if !errors.Is(err, ErrFontNotFound) {
+		t.Fatalf("Expected ErrFontNotFound, got %v", err)
+	}
+	if got != nil {
+		t.Error("Request sent despite missing fonts")
+	}
+
+	if _, err := client.Convert(context.Background(), &buf, "", []byte(`#set text(font: "Inter")`), []string{"--font-path=fonts"}, nil); err != nil {
+		t.Errorf("Failed to convert with system fonts: %v", err)
+	}
+}
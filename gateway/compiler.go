@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Compiler compiles main.typ in dir, which also holds the request media.
+type Compiler interface {
+	Compile(ctx context.Context, dir string, options []string) (Output, error)
+}
+
+// Output is the result of a compilation.
+type Output struct {
+	PDF    []byte
+	Stdout string
+	Stderr string
+}
+
+// CompileError is returned by a Compiler when typst rejects the document. It
+// is reported to clients as a generation failure rather than a server error.
+type CompileError struct {
+	Message string
+}
+
+func (e *CompileError) Error() string {
+	return e.Message
+}
+
+// TypstCompiler runs the typst command line.
+type TypstCompiler struct {
+	// Path is the typst executable, "typst" from PATH if empty.
+	Path string
+}
+
+func (c *TypstCompiler) path() string {
+	if c.Path == "" {
+		return "typst"
+	}
+	return c.Path
+}
+
+func (c *TypstCompiler) Compile(ctx context.Context, dir string, options []string) (Output, error) {
+	args := append([]string{"compile"}, options...)
+	args = append(args, mainFile, "output.pdf")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path(), args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	out := Output{Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			msg := strings.TrimSpace(out.Stderr)
+			if msg == "" {
+				msg = err.Error()
+			}
+			return out, &CompileError{Message: msg}
+		}
+		return out, fmt.Errorf("failed to run typst: %w", err)
+	}
+
+	if out.PDF, err = os.ReadFile(filepath.Join(dir, "output.pdf")); err != nil {
+		return out, fmt.Errorf("failed to read output: %w", err)
+	}
+	return out, nil
+}
+
+// Version returns the version of the typst executable.
+func (c *TypstCompiler) Version(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, c.path(), "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run typst: %w", err)
+	}
+	// typst 0.13.1 (8ace67d9)
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unexpected typst version %q", out)
+	}
+	return fields[1], nil
+}
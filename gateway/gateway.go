@@ -0,0 +1,328 @@
+// Package gateway is a reference implementation of the typst-pdf-generator
+// gateway protocol: synchronous renders, health and capabilities, and the
+// asynchronous jobs extension with signed completion callbacks. It compiles
+// with a local typst executable and suits development, tests and small
+// self-hosted deployments.
+//
+//	srv := &gateway.Server{AuthKey: os.Getenv("PDF_GENERATOR_AUTH_KEY")}
+//	defer srv.Close()
+//	http.ListenAndServe(":8080", srv)
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// ProtocolVersion is the gateway protocol version reported in capabilities.
+const ProtocolVersion = 1
+
+// mainFile is the name the template is compiled as.
+const mainFile = "main.typ"
+
+// Server serves the gateway protocol. The zero value compiles with typst from
+// PATH and accepts every request.
+type Server struct {
+	// Compiler compiles requests, a TypstCompiler by default.
+	Compiler Compiler
+	// AuthKey, if set, must be sent verbatim in the Authorization header.
+	AuthKey string
+	// Verifier, if set, checks HMAC-signed requests instead.
+	Verifier *typstpdfgenerator.HMACVerifier
+	// MaxPayload is the largest request body in bytes, unlimited if zero.
+	MaxPayload int64
+
+	// Workers bounds the jobs compiled concurrently, the number of CPUs by
+	// default.
+	Workers int
+	// JobTTL is how long finished jobs are kept, an hour by default.
+	JobTTL time.Duration
+	// WebhookSecret signs job callbacks like typstpdfgenerator.HMACSigner, as
+	// expected by typstpdfgenerator.NewWebhookHandler. Callbacks are unsigned
+	// if it is empty.
+	WebhookSecret []byte
+	// HTTPClient sends job callbacks, http.DefaultClient by default.
+	HTTPClient *http.Client
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+	ctx      context.Context
+	cancel   context.CancelFunc
+	workers  chan struct{}
+	running  sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[typstpdfgenerator.JobID]*job
+}
+
+type renderRequest struct {
+	Content  string            `json:"content"`
+	Template string            `json:"template"`
+	Options  []string          `json:"options"`
+	Media    map[string]string `json:"media"`
+	// CallbackURL is only used by job submissions.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type renderResponse struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message,omitempty"`
+	PDF     string `json:"pdf,omitempty"`
+	Stdout  string `json:"stdout,omitempty"`
+	Stderr  string `json:"stderr,omitempty"`
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.Compiler == nil {
+			s.Compiler = &TypstCompiler{}
+		}
+		workers := s.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		s.workers = make(chan struct{}, workers)
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.jobs = make(map[typstpdfgenerator.JobID]*job)
+
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("POST /{$}", s.handleRender)
+		s.mux.HandleFunc("GET /healthz", s.handleHealth)
+		s.mux.HandleFunc("GET /capabilities", s.handleCapabilities)
+		s.mux.HandleFunc("POST /jobs", s.handleSubmit)
+		s.mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+		s.mux.HandleFunc("GET /jobs/{id}/result", s.handleResult)
+	})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	if id := r.Header.Get("X-Correlation-ID"); id != "" {
+		w.Header().Set("X-Correlation-ID", id)
+	}
+	// Limit the body first, verifying a signature reads all of it.
+	if s.MaxPayload > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxPayload)
+	}
+	if err := s.authorize(r); err != nil {
+		status := http.StatusUnauthorized
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, status, renderResponse{Error: true, Message: err.Error()})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels the running jobs and waits for them to stop.
+func (s *Server) Close() error {
+	s.init()
+	s.cancel()
+	s.running.Wait()
+	return nil
+}
+
+func (s *Server) authorize(r *http.Request) error {
+	if s.Verifier != nil {
+		return s.Verifier.Verify(r)
+	}
+	if s.AuthKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.AuthKey)) != 1 {
+		return typstpdfgenerator.ErrInvalidAuth
+	}
+	return nil
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := typstpdfgenerator.Capabilities{
+		ProtocolVersion: ProtocolVersion,
+		MaxPayload:      s.MaxPayload,
+		OutputFormats:   []string{"pdf"},
+		Features:        []string{"jobs"},
+	}
+	if v, ok := s.Compiler.(interface {
+		Version(ctx context.Context) (string, error)
+	}); ok {
+		caps.TypstVersion, _ = v.Version(r.Context())
+	}
+	writeJSON(w, http.StatusOK, caps)
+}
+
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	var req renderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, renderResponse{Error: true, Message: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	work, err := newWorkspace(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, renderResponse{Error: true, Message: err.Error()})
+		return
+	}
+	defer work.remove()
+
+	status, resp := s.compile(r.Context(), work)
+	writeJSON(w, status, resp)
+}
+
+// compile compiles a workspace and returns the response to send.
+func (s *Server) compile(ctx context.Context, work *workspace) (int, renderResponse) {
+	out, err := s.Compiler.Compile(ctx, work.dir, work.options)
+	resp := renderResponse{Stdout: out.Stdout, Stderr: out.Stderr}
+	var compileErr *CompileError
+	switch {
+	case errors.As(err, &compileErr):
+		resp.Error = true
+		resp.Message = compileErr.Message
+		return http.StatusOK, resp
+	case err != nil:
+		resp.Error = true
+		resp.Message = err.Error()
+		return http.StatusInternalServerError, resp
+	}
+	resp.PDF = base64.StdEncoding.EncodeToString(out.PDF)
+	return http.StatusOK, resp
+}
+
+// workspace is a temporary directory holding the template and media of a
+// request.
+type workspace struct {
+	dir     string
+	options []string
+}
+
+// newWorkspace validates a request and writes it to a temporary directory.
+func newWorkspace(req renderRequest) (*workspace, error) {
+	template, err := base64.StdEncoding.DecodeString(req.Template)
+	if err != nil || len(template) == 0 {
+		return nil, fmt.Errorf("invalid template: expected non-empty base64 data")
+	}
+	options := req.Options
+	if len(options) == 0 {
+		options = typstpdfgenerator.DefaultOptions()
+	}
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	if req.Content != "" {
+		options = append(options[:len(options):len(options)], "--input", "content="+req.Content)
+	}
+
+	media := make([]typstpdfgenerator.MediaFile, 0, len(req.Media))
+	for name, encoded := range req.Media {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid media %q: expected base64 data", name)
+		}
+		media = append(media, typstpdfgenerator.MediaFile{Name: name, Data: data})
+	}
+	media, err = typstpdfgenerator.NormalizeMedia(media, false)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "typst-gateway-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	work := &workspace{dir: dir, options: options}
+	files := append(media, typstpdfgenerator.MediaFile{Name: mainFile, Data: template})
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			work.remove()
+			return nil, fmt.Errorf("failed to write media: %w", err)
+		}
+		if err := os.WriteFile(path, f.Data, 0644); err != nil {
+			work.remove()
+			return nil, fmt.Errorf("failed to write media: %w", err)
+		}
+	}
+	return work, nil
+}
+
+func (w *workspace) remove() {
+	_ = os.RemoveAll(w.dir)
+}
+
+// allowedOptions are the typst compile options requests may use, mapped to
+// whether they take a value. Anything else, such as --root, --open or
+// --make-deps, could reach outside the workspace.
+var allowedOptions = map[string]bool{
+	"--input":                 true,
+	"--format":                true,
+	"-f":                      true,
+	"--pages":                 true,
+	"--ppi":                   true,
+	"--pdf-standard":          true,
+	"--creation-timestamp":    true,
+	"--diagnostic-format":     true,
+	"--font-path":             true,
+	"--package-path":          true,
+	"--package-cache-path":    true,
+	"--ignore-system-fonts":   false,
+	"--ignore-embedded-fonts": false,
+}
+
+// checkOptions rejects options that are not allowed, paths that would reach
+// outside the workspace and output other than a PDF.
+func checkOptions(options []string) error {
+	for i := 0; i < len(options); i++ {
+		name, value, hasValue := strings.Cut(options[i], "=")
+		takesValue, ok := allowedOptions[name]
+		switch {
+		case !ok && !strings.HasPrefix(name, "-"):
+			return fmt.Errorf("unexpected argument %q", options[i])
+		case !ok:
+			return fmt.Errorf("option %s is not allowed", name)
+		case !takesValue && hasValue:
+			return fmt.Errorf("option %s takes no value", name)
+		case !takesValue:
+			continue
+		case !hasValue && i+1 == len(options):
+			return fmt.Errorf("option %s requires a value", name)
+		case !hasValue:
+			i++
+			value = options[i]
+		}
+
+		switch name {
+		case "--font-path", "--package-path", "--package-cache-path":
+			// typst splits font paths like PATH.
+			for _, path := range filepath.SplitList(value) {
+				if !filepath.IsLocal(path) {
+					return fmt.Errorf("option %s must be a relative path inside the project", name)
+				}
+			}
+		case "--format", "-f":
+			if !strings.EqualFold(value, "pdf") {
+				return fmt.Errorf("unsupported output format %q", value)
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// fakeCompiler returns main.typ as the PDF, fails when it contains "broken"
+// and blocks while it contains "slow" until release is closed.
+type fakeCompiler struct {
+	release chan struct{}
+	options []string
+}
+
+func (c *fakeCompiler) Compile(ctx context.Context, dir string, options []string) (Output, error) {
+	c.options = options
+	src, err := os.ReadFile(filepath.Join(dir, mainFile))
+	if err != nil {
+		return Output{}, err
+	}
+	if bytes.Contains(src, []byte("slow")) {
+		<-c.release
+	}
+	if bytes.Contains(src, []byte("broken")) {
+		return Output{Stderr: "error: unknown variable"}, &CompileError{Message: "unknown variable"}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "img", "logo.png")); err == nil {
+		src = append(src, " +logo"...)
+	}
+	return Output{PDF: src, Stdout: "compiled"}, nil
+}
+
+func newTestGateway(t *testing.T, s *Server) *typstpdfgenerator.Client {
+	t.Helper()
+	server := httptest.NewServer(s)
+	t.Cleanup(func() {
+		server.Close()
+		s.Close()
+	})
+	client, err := typstpdfgenerator.New("test-key", server.URL, typstpdfgenerator.WithJobPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestServerRender(t *testing.T) {
+	compiler := &fakeCompiler{}
+	client := newTestGateway(t, &Server{Compiler: compiler, AuthKey: "test-key"})
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	media := []typstpdfgenerator.MediaFile{{Name: "img/logo.png", Data: []byte("png")}}
+	if _, err := client.Convert(ctx, &buf, "hello", []byte("= Title"), nil, media); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if buf.String() != "= Title +logo" {
+		t.Errorf("Unexpected PDF %q", buf.String())
+	}
+	if got := strings.Join(compiler.options, " "); !strings.HasSuffix(got, "--input content=hello") {
+		t.Errorf("Content not passed as input: %s", got)
+	}
+
+	_, err := client.Convert(ctx, &buf, "", []byte("broken"), nil, nil)
+	var notGenerated *typstpdfgenerator.NotGeneratedError
+	if !errors.As(err, &notGenerated) || notGenerated.Message != "unknown variable" {
+		t.Errorf("Expected NotGeneratedError, got %v", err)
+	}
+
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+	caps, err := client.Capabilities(ctx)
+	if err != nil || !caps.HasFeature("jobs") || !caps.SupportsFormat("pdf") {
+		t.Errorf("Unexpected capabilities %+v: %v", caps, err)
+	}
+}
+
+func TestServerRejectsRequests(t *testing.T) {
+	server := httptest.NewServer(&Server{Compiler: &fakeCompiler{}, AuthKey: "test-key"})
+	defer server.Close()
+	ctx := context.Background()
+
+	wrongKey, _ := typstpdfgenerator.New("other-key", server.URL)
+	_, err := wrongKey.Convert(ctx, &bytes.Buffer{}, "", []byte("= Title"), nil, nil)
+	var httpErr *typstpdfgenerator.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", err)
+	}
+
+	client, _ := typstpdfgenerator.New("test-key", server.URL)
+	tests := []struct {
+		name    string
+		options []string
+	}{
+		{"root", []string{"--root=/"}},
+		{"font path", []string{"--font-path", "/usr/share/fonts"}},
+		{"package path", []string{"--package-path=../packages"}},
+		{"format", []string{"--format=png"}},
+		{"open", []string{"--open", "sh"}},
+		{"deps", []string{"--make-deps=/tmp/deps"}},
+		{"font path list", []string{"--font-path=fonts" + string(filepath.ListSeparator) + "/etc"}},
+		{"positional", []string{"/etc/passwd"}},
+		{"flag value", []string{"--ignore-system-fonts=/etc"}},
+		{"missing value", []string{"--ppi"}},
+	}
+	for _, tt := range tests {
+		_, err := client.Convert(ctx, &bytes.Buffer{}, "", []byte("= Title"), tt.options, nil)
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestServerLimitsSignedPayload(t *testing.T) {
+	secret := []byte("shared-secret")
+	verifier, err := typstpdfgenerator.NewHMACVerifier(secret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	server := httptest.NewServer(&Server{Compiler: &fakeCompiler{}, Verifier: verifier, MaxPayload: 256})
+	defer server.Close()
+
+	signer, _ := typstpdfgenerator.NewHMACSigner(secret)
+	client, _ := typstpdfgenerator.New("", server.URL, typstpdfgenerator.WithAuthProvider(signer))
+	if _, err := client.Convert(context.Background(), &bytes.Buffer{}, "", []byte("= Title"), nil, nil); err != nil {
+		t.Fatalf("Small request failed: %v", err)
+	}
+	_, err = client.Convert(context.Background(), &bytes.Buffer{}, "", bytes.Repeat([]byte("a"), 1024), nil, nil)
+	var httpErr *typstpdfgenerator.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %v", err)
+	}
+}
+
+func TestServerJobs(t *testing.T) {
+	compiler := &fakeCompiler{release: make(chan struct{})}
+	received := make(chan typstpdfgenerator.JobStatus, 1)
+	secret := []byte("webhook-secret")
+	webhook, err := typstpdfgenerator.NewWebhookHandler(secret, time.Minute, func(_ context.Context, s typstpdfgenerator.JobStatus) {
+		received <- s
+	})
+	if err != nil {
+		t.Fatalf("Failed to create webhook handler: %v", err)
+	}
+	callbacks := httptest.NewServer(webhook)
+	defer callbacks.Close()
+
+	s := &Server{Compiler: compiler, WebhookSecret: secret}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	defer s.Close()
+	client, err := typstpdfgenerator.New("test-key", server.URL,
+		typstpdfgenerator.WithJobWebhook(callbacks.URL),
+		typstpdfgenerator.WithJobPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	ctx := context.Background()
+
+	id, err := client.Submit(ctx, &typstpdfgenerator.Request{Template: []byte("slow")})
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	if status, err := client.Status(ctx, id); err != nil || status.Done() {
+		t.Errorf("Unexpected status %+v: %v", status, err)
+	}
+	var httpErr *typstpdfgenerator.HTTPError
+	if _, err := client.Fetch(ctx, id, &bytes.Buffer{}); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for unfinished job, got %v", err)
+	}
+	close(compiler.release)
+
+	if status, err := client.Wait(ctx, id); err != nil || status.State != typstpdfgenerator.JobSucceeded {
+		t.Fatalf("Unexpected status %+v: %v", status, err)
+	}
+	var buf bytes.Buffer
+	if _, err := client.Fetch(ctx, id, &buf); err != nil || buf.String() != "slow" {
+		t.Errorf("Unexpected result %q: %v", buf.String(), err)
+	}
+	select {
+	case status := <-received:
+		if status.ID != id || status.State != typstpdfgenerator.JobSucceeded {
+			t.Errorf("Unexpected callback %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No callback received")
+	}
+
+	id, err = client.Submit(ctx, &typstpdfgenerator.Request{Template: []byte("broken")})
+	if err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	if status, err := client.Wait(ctx, id); !errors.Is(err, typstpdfgenerator.ErrNotGenerated) || status.Message != "unknown variable" {
+		t.Errorf("Expected failed job, got %+v: %v", status, err)
+	}
+	if _, err := client.Fetch(ctx, id, &buf); !errors.Is(err, typstpdfgenerator.ErrNotGenerated) {
+		t.Errorf("Expected ErrNotGenerated from Fetch, got %v", err)
+	}
+	<-received
+
+	if _, err := client.Status(ctx, "unknown"); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %v", err)
+	}
+}
+
+func TestTypstCompiler(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake typst executable is a shell script")
+	}
+
+	dir := t.TempDir()
+	typst := filepath.Join(dir, "typst")
+	script := `#!/bin/sh
+if [ "$1" = --version ]; then
+	echo "typst 0.13.1 (8ace67d9)"
+	exit 0
+fi
+if grep -q broken main.typ; then
+	echo "error: unknown variable" >&2
+	exit 1
+fi
+echo "%PDF-1.7" > output.pdf
+`
+	if err := os.WriteFile(typst, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake typst: %v", err)
+	}
+	compiler := &TypstCompiler{Path: typst}
+	ctx := context.Background()
+
+	if v, err := compiler.Version(ctx); err != nil || v != "0.13.1" {
+		t.Errorf("Version = %q, %v", v, err)
+	}
+
+	work := t.TempDir()
+	os.WriteFile(filepath.Join(work, mainFile), []byte("= Title"), 0644)
+	out, err := compiler.Compile(ctx, work, nil)
+	if err != nil || string(out.PDF) != "%PDF-1.7\n" {
+		t.Errorf("Unexpected output %q: %v", out.PDF, err)
+	}
+
+	os.WriteFile(filepath.Join(work, mainFile), []byte("broken"), 0644)
+	_, err = compiler.Compile(ctx, work, nil)
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) || compileErr.Message != "error: unknown variable" {
+		t.Errorf("Expected CompileError, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+	"github.com/google/uuid"
+)
+
+type job struct {
+	status   typstpdfgenerator.JobStatus
+	result   renderResponse
+	finished time.Time
+}
+
+func (s *Server) jobTTL() time.Duration {
+	if s.JobTTL <= 0 {
+		return time.Hour
+	}
+	return s.JobTTL
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req renderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, renderResponse{Error: true, Message: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			writeJSON(w, http.StatusBadRequest, renderResponse{Error: true, Message: "invalid callback URL"})
+			return
+		}
+	}
+	work, err := newWorkspace(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, renderResponse{Error: true, Message: err.Error()})
+		return
+	}
+
+	id := typstpdfgenerator.JobID(uuid.NewString())
+	j := &job{status: typstpdfgenerator.JobStatus{ID: id, State: typstpdfgenerator.JobQueued}}
+	s.mu.Lock()
+	s.expireJobs()
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go s.run(j, work, req.CallbackURL, r.Header.Get("X-Correlation-ID"))
+	writeJSON(w, http.StatusAccepted, map[string]typstpdfgenerator.JobID{"id": id})
+}
+
+// run compiles a submitted job and delivers its callback.
+func (s *Server) run(j *job, work *workspace, callbackURL, correlationID string) {
+	defer s.running.Done()
+	defer work.remove()
+
+	select {
+	case s.workers <- struct{}{}:
+	case <-s.ctx.Done():
+		s.finish(j, http.StatusServiceUnavailable, renderResponse{Error: true, Message: "server shutting down"})
+		return
+	}
+	s.mu.Lock()
+	j.status.State = typstpdfgenerator.JobRunning
+	s.mu.Unlock()
+	status, resp := s.compile(s.ctx, work)
+	<-s.workers
+
+	final := s.finish(j, status, resp)
+	if callbackURL != "" {
+		_ = s.callback(callbackURL, correlationID, final)
+	}
+}
+
+func (s *Server) finish(j *job, status int, resp renderResponse) typstpdfgenerator.JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.status.State = typstpdfgenerator.JobSucceeded
+	if resp.Error || status != http.StatusOK {
+		j.status.State = typstpdfgenerator.JobFailed
+		resp.Error = true
+	}
+	j.status.Message = resp.Message
+	j.status.Stdout = resp.Stdout
+	j.status.Stderr = resp.Stderr
+	j.result = resp
+	j.finished = time.Now()
+	return j.status
+}
+
+// callback POSTs the final status of a job, signed when WebhookSecret is set.
+func (s *Server) callback(callbackURL, correlationID string, status typstpdfgenerator.JobStatus) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if req.URL.Path == "" {
+		// Sign the path the request is sent with.
+		req.URL.Path = "/"
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if correlationID != "" {
+		req.Header.Set("X-Correlation-ID", correlationID)
+	}
+	if len(s.WebhookSecret) > 0 {
+		signer, err := typstpdfgenerator.NewHMACSigner(s.WebhookSecret)
+		if err != nil {
+			return err
+		}
+		if err := signer.Apply(ctx, req); err != nil {
+			return err
+		}
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
+
+// lookup returns a copy of the job with the id in the request path.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireJobs()
+	j, ok := s.jobs[typstpdfgenerator.JobID(r.PathValue("id"))]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, renderResponse{Error: true, Message: "job not found"})
+		return job{}, false
+	}
+	return *j, true
+}
+
+// expireJobs drops jobs that finished more than JobTTL ago. s.mu must be held.
+func (s *Server) expireJobs() {
+	cutoff := time.Now().Add(-s.jobTTL())
+	for id, j := range s.jobs {
+		if !j.finished.IsZero() && j.finished.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, j.status)
+	}
+}
+
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if !j.status.Done() {
+		writeJSON(w, http.StatusConflict, renderResponse{Error: true, Message: fmt.Sprintf("job is %s", j.status.State)})
+		return
+	}
+	writeJSON(w, http.StatusOK, j.result)
+}
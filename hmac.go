@@ -0,0 +1,186 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers carrying an HMAC request signature.
+const (
+	HeaderTimestamp     = "X-Typst-Timestamp"
+	HeaderNonce         = "X-Typst-Nonce"
+	HeaderContentSHA256 = "X-Typst-Content-SHA256"
+	HeaderSignature     = "X-Typst-Signature"
+)
+
+// HMACSigner is an AuthProvider that signs requests with a shared secret
+// instead of sending a credential.
+//
+// The signature is HMAC-SHA256 over the method, path, timestamp, nonce,
+// correlation ID and body digest, so a captured request cannot be altered and
+// is only accepted once, within the verifier's time window. Every Apply uses
+// a new nonce, so retries of a request are accepted.
+type HMACSigner struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func NewHMACSigner(secret []byte) (*HMACSigner, error) {
+	if len(secret) == 0 {
+		return nil, ErrInvalidAuth
+	}
+	return &HMACSigner{secret: secret, now: time.Now}, nil
+}
+
+func (s *HMACSigner) Apply(_ context.Context, req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	contentSHA := hex.EncodeToString(digest[:])
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	nonce := uuid.NewString()
+
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderContentSHA256, contentSHA)
+	req.Header.Set(HeaderSignature, signRequest(s.secret, req.Method, signedPath(req.URL), timestamp, nonce, req.Header.Get("X-Correlation-ID"), contentSHA))
+	return nil
+}
+
+// requestBody returns the request body without consuming it.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// signedPath returns the path of u as signed. An empty path is sent as "/".
+func signedPath(u *url.URL) string {
+	if p := u.EscapedPath(); p != "" {
+		return p
+	}
+	return "/"
+}
+
+func signRequest(secret []byte, method, path, timestamp, nonce, correlationID, contentSHA string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{method, path, timestamp, nonce, correlationID, contentSHA}, "\n")))
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACVerifier checks signatures produced by HMACSigner on the server side and
+// rejects requests outside the time window or whose nonce was seen before
+// within it.
+type HMACVerifier struct {
+	secret []byte
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewHMACVerifier(secret []byte, window time.Duration) (*HMACVerifier, error) {
+	if len(secret) == 0 {
+		return nil, ErrInvalidAuth
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("signature window must be positive")
+	}
+	return &HMACVerifier{secret: secret, window: window, now: time.Now, seen: make(map[string]time.Time)}, nil
+}
+
+// Verify checks the signature of r. The body is read and restored.
+func (v *HMACVerifier) Verify(r *http.Request) error {
+	signature := r.Header.Get(HeaderSignature)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return fmt.Errorf("%w: missing signature headers", ErrInvalidSignature)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	now := v.now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-v.window)) || signedAt.After(now.Add(v.window)) {
+		return fmt.Errorf("%w: timestamp outside allowed window", ErrInvalidSignature)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	digest := sha256.Sum256(body)
+	contentSHA := hex.EncodeToString(digest[:])
+	if !hmac.Equal([]byte(contentSHA), []byte(r.Header.Get(HeaderContentSHA256))) {
+		return fmt.Errorf("%w: body digest mismatch", ErrInvalidSignature)
+	}
+
+	expected := signRequest(v.secret, r.Method, signedPath(r.URL), timestamp, nonce, r.Header.Get("X-Correlation-ID"), contentSHA)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, n)
+		}
+	}
+	if _, replayed := v.seen[nonce]; replayed {
+		return fmt.Errorf("%w: replayed request", ErrInvalidSignature)
+	}
+	v.seen[nonce] = signedAt.Add(v.window)
+
+	return nil
+}
+
+// Middleware rejects requests with an invalid signature with 401 Unauthorized.
+func (v *HMACVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
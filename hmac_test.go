@@ -0,0 +1,194 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACSignedConvert(t *testing.T) {
+	secret := []byte("shared-secret")
+	verifier, err := NewHMACVerifier(secret, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+
+	var authHeader string
+	server := newFakeGateway(t, verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		writePDFResponse(w, fakePDF)
+	})).ServeHTTP)
+
+	signer, err := NewHMACSigner(secret)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	client, err := New("", server.URL+"/function/typst", WithAuthProvider(signer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Signed"), nil, nil); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if authHeader != "" {
+		t.Errorf("Expected no Authorization header, got %q", authHeader)
+	}
+
+	wrongSigner, _ := NewHMACSigner([]byte("wrong-secret"))
+	wrongClient, _ := New("", server.URL+"/function/typst", WithAuthProvider(wrongSigner))
+	_, err = wrongClient.Convert(context.Background(), &buf, "", []byte("= Signed"), nil, nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected HTTP 401 with wrong secret, got %v", err)
+	}
+}
+
+func TestHMACVerifier(t *testing.T) {
+	secret := []byte("shared-secret")
+	now := time.Unix(1_700_000_000, 0)
+
+	newSignedRequest := func(t *testing.T, body string, signedAt time.Time) *http.Request {
+		t.Helper()
+		signer, err := NewHMACSigner(secret)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		signer.now = func() time.Time { return signedAt }
+
+		req, err := http.NewRequest(http.MethodPost, "https://gateway.example/function/typst", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("X-Correlation-ID", "corr-1")
+		if err := signer.Apply(context.Background(), req); err != nil {
+			t.Fatalf("Failed to sign request: %v", err)
+		}
+		return req
+	}
+
+	toServerRequest := func(req *http.Request) *http.Request {
+		body, _ := requestBody(req)
+		r := httptest.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+		r.Header = req.Header.Clone()
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			request: func(t *testing.T) *http.Request { return toServerRequest(newSignedRequest(t, `{"a":1}`, now)) },
+		},
+		{
+			name: "tampered body",
+			request: func(t *testing.T) *http.Request {
+				r := toServerRequest(newSignedRequest(t, `{"a":1}`, now))
+				r.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a":2}`)).Body
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "tampered correlation ID",
+			request: func(t *testing.T) *http.Request {
+				r := toServerRequest(newSignedRequest(t, `{"a":1}`, now))
+				r.Header.Set("X-Correlation-ID", "corr-2")
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "root path",
+			request: func(t *testing.T) *http.Request {
+				signer, _ := NewHMACSigner(secret)
+				signer.now = func() time.Time { return now }
+				req, _ := http.NewRequest(http.MethodPost, "https://gateway.example", strings.NewReader(`{"a":1}`))
+				if err := signer.Apply(context.Background(), req); err != nil {
+					t.Fatalf("Failed to sign request: %v", err)
+				}
+				return toServerRequest(req)
+			},
+		},
+		{
+			name: "tampered nonce",
+			request: func(t *testing.T) *http.Request {
+				r := toServerRequest(newSignedRequest(t, `{"a":1}`, now))
+				r.Header.Set(HeaderNonce, "other")
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			request: func(t *testing.T) *http.Request {
+				return toServerRequest(newSignedRequest(t, `{"a":1}`, now.Add(-2*time.Minute)))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			request: func(t *testing.T) *http.Request { return httptest.NewRequest(http.MethodPost, "/function/typst", nil) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewHMACVerifier(secret, time.Minute)
+			if err != nil {
+				t.Fatalf("Failed to create verifier: %v", err)
+			}
+			verifier.now = func() time.Time { return now }
+
+			err = verifier.Verify(tt.request(t))
+			if tt.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		verifier, _ := NewHMACVerifier(secret, time.Minute)
+		verifier.now = func() time.Time { return now }
+
+		signed := newSignedRequest(t, `{"a":1}`, now)
+		if err := verifier.Verify(toServerRequest(signed)); err != nil {
+			t.Fatalf("First request rejected: %v", err)
+		}
+		if err := verifier.Verify(toServerRequest(signed)); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected replay to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("resent", func(t *testing.T) {
+		verifier, _ := NewHMACVerifier(secret, time.Minute)
+		verifier.now = func() time.Time { return now }
+		signer, _ := NewHMACSigner(secret)
+		signer.now = func() time.Time { return now }
+
+		// A retry signs the same request again within the same second.
+		req, _ := http.NewRequest(http.MethodPost, "https://gateway.example/function/typst", strings.NewReader(`{"a":1}`))
+		req.Header.Set("X-Correlation-ID", "corr-1")
+		for i := range 2 {
+			if err := signer.Apply(context.Background(), req); err != nil {
+				t.Fatalf("Failed to sign request: %v", err)
+			}
+			if err := verifier.Verify(toServerRequest(req)); err != nil {
+				t.Errorf("Attempt %d rejected: %v", i+1, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,532 @@
+// Package htmltotypst converts a safe subset of HTML, such as rich text
+// stored by a CMS, to Typst markup suitable for the content argument of
+// Client.Convert.
+//
+// The supported elements are p, h1 to h6, strong and b, em and i, code, pre,
+// br, a, img, ul, ol and li, and table with its rows and cells. Other
+// elements are dropped but their text is kept, except for script and style,
+// whose content is dropped as well. Dropped elements are reported in
+// Result.Unsupported, or as an *UnsupportedError in strict mode.
+//
+// It has no dependencies outside the standard library.
+package htmltotypst
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+	"github.com/4sigma/typstpdfgenerator/internal/typstmarkup"
+)
+
+// Converter converts HTML to Typst markup.
+type Converter struct {
+	// Image resolves the src of an <img> to the media file it is loaded from;
+	// the image is referenced by the file's name. If nil, sources are
+	// referenced as they are. Data URLs are always decoded into media files
+	// under images/.
+	Image func(src string) (typstpdfgenerator.MediaFile, error)
+	// Strict makes unsupported elements an error instead of dropping them.
+	Strict bool
+}
+
+// Result is the outcome of a conversion.
+type Result struct {
+	// Markup is the Typst markup.
+	Markup string
+	// Media holds the images of the document, once each.
+	Media []typstpdfgenerator.MediaFile
+	// Unsupported lists the dropped elements in order of appearance.
+	Unsupported []string
+}
+
+// UnsupportedError is returned in strict mode for documents with unsupported
+// elements.
+type UnsupportedError struct {
+	Tags []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return "unsupported HTML elements: " + strings.Join(e.Tags, ", ")
+}
+
+// Convert converts src with the default Converter.
+func Convert(src string) (string, error) {
+	res, err := (&Converter{}).Convert(src)
+	if err != nil {
+		return "", err
+	}
+	return res.Markup, nil
+}
+
+// Convert converts the HTML fragment src to Typst markup.
+func (c *Converter) Convert(src string) (*Result, error) {
+	r := &renderer{c: c, seenMedia: make(map[string]bool), seenTags: make(map[string]bool)}
+	markup := r.blocks(parse(src).children)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if c.Strict && len(r.unsupported) > 0 {
+		return nil, &UnsupportedError{Tags: r.unsupported}
+	}
+	if markup != "" {
+		markup += "\n"
+	}
+	return &Result{Markup: markup, Media: r.media, Unsupported: r.unsupported}, nil
+}
+
+// ImagesFromDir returns a Converter.Image function that loads images from
+// dir and names them by their paths relative to it. Remote images and paths
+// outside dir are rejected.
+func ImagesFromDir(dir string) func(src string) (typstpdfgenerator.MediaFile, error) {
+	return ImagesFromFS(os.DirFS(dir))
+}
+
+// ImagesFromFS is like ImagesFromDir for an fs.FS.
+func ImagesFromFS(fsys fs.FS) func(src string) (typstpdfgenerator.MediaFile, error) {
+	return typstmarkup.ImagesFromFS(fsys, true)
+}
+
+// Elements rendered as blocks. Unsupported ones are dropped but their content
+// is still rendered as blocks.
+var blockElements = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "table": true, "pre": true,
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "details": true,
+	"div": true, "dl": true, "dd": true, "dt": true, "figure": true, "figcaption": true, "footer": true,
+	"header": true, "hr": true, "html": true, "main": true, "nav": true, "section": true, "summary": true,
+}
+
+// Elements that are dropped with their content.
+var droppedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "title": true, "iframe": true,
+	"object": true, "svg": true, "math": true, "noscript": true, "textarea": true, "select": true,
+}
+
+// Elements that are silently rendered through their content.
+var transparentElements = map[string]bool{"html": true, "body": true, "thead": true, "tbody": true, "tfoot": true}
+
+type renderer struct {
+	c           *Converter
+	media       []typstpdfgenerator.MediaFile
+	seenMedia   map[string]bool
+	unsupported []string
+	seenTags    map[string]bool
+	err         error
+}
+
+func (r *renderer) report(tag string) {
+	if !r.seenTags[tag] {
+		r.seenTags[tag] = true
+		r.unsupported = append(r.unsupported, tag)
+	}
+}
+
+func isBlock(n *node) bool {
+	return blockElements[n.tag] || droppedElements[n.tag]
+}
+
+// blocks renders nodes as blocks separated by blank lines. Runs of inline
+// nodes between blocks form paragraphs.
+func (r *renderer) blocks(nodes []*node) string {
+	return r.blocksSep(nodes, "\n\n")
+}
+
+func (r *renderer) blocksSep(nodes []*node, sep string) string {
+	var parts []string
+	var run []*node
+	flush := func() {
+		if p := strings.TrimSpace(r.inlines(run)); p != "" {
+			parts = append(parts, typstmarkup.GuardLineStart(p))
+		}
+		run = nil
+	}
+
+	for _, n := range nodes {
+		if n.tag == "" || !isBlock(n) {
+			run = append(run, n)
+			continue
+		}
+		flush()
+		if s := r.block(n); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	flush()
+	return strings.Join(parts, sep)
+}
+
+func (r *renderer) block(n *node) string {
+	switch n.tag {
+	case "p":
+		return typstmarkup.GuardLineStart(strings.TrimSpace(r.inlines(n.children)))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.TrimSpace(r.inlines(n.children))
+		if text == "" {
+			return ""
+		}
+		return strings.Repeat("=", int(n.tag[1]-'0')) + " " + text
+	case "ul", "ol":
+		return r.list(n)
+	case "table":
+		return r.table(n)
+	case "pre":
+		return r.pre(n)
+	}
+
+	if droppedElements[n.tag] {
+		if n.tag != "head" && n.tag != "title" {
+			r.report(n.tag)
+		}
+		return ""
+	}
+	if !transparentElements[n.tag] {
+		r.report(n.tag)
+	}
+	return r.blocks(n.children)
+}
+
+var whitespace = regexp.MustCompile(`[ \t\n\r\f]+`)
+
+// inlines renders nodes as inline content with collapsed whitespace. An
+// embedded expression such as "#emph[...]" would continue into a following
+// "(", "[" or ".", so it is ended with a semicolon there.
+func (r *renderer) inlines(nodes []*node) string {
+	var b strings.Builder
+	prev := ""
+	for _, n := range nodes {
+		s := r.inline(n)
+		if s == "" {
+			continue
+		}
+		if typstmarkup.NeedsSemicolon(prev, s) {
+			b.WriteByte(';')
+		}
+		if strings.HasSuffix(b.String(), " ") && strings.HasPrefix(s, " ") {
+			s = s[1:]
+		}
+		b.WriteString(s)
+		prev = s
+	}
+	return b.String()
+}
+
+func (r *renderer) inline(n *node) string {
+	switch n.tag {
+	case "":
+		return typstpdfgenerator.EscapeMarkup(whitespace.ReplaceAllString(n.text, " "))
+	case "strong", "b":
+		return wrap("#strong[", r.inlines(n.children), "]")
+	case "em", "i":
+		return wrap("#emph[", r.inlines(n.children), "]")
+	case "code":
+		return typstmarkup.InlineCode(whitespace.ReplaceAllString(textContent(n), " "))
+	case "br":
+		return "#linebreak()"
+	case "a":
+		return r.link(n)
+	case "img":
+		return r.image(n)
+	}
+
+	if isBlock(n) {
+		// A block inside inline content, such as a <p> in a <span>.
+		return " " + r.blocks([]*node{n}) + " "
+	}
+	r.report(n.tag)
+	return r.inlines(n.children)
+}
+
+// wrap puts the content into a function call, keeping surrounding spaces
+// outside of it.
+func wrap(open, content, close string) string {
+	trimmed := strings.TrimSpace(content)
+	if trimmed == "" {
+		return content
+	}
+	var lead, trail string
+	if strings.HasPrefix(content, " ") {
+		lead = " "
+	}
+	if strings.HasSuffix(content, " ") {
+		trail = " "
+	}
+	return lead + open + trimmed + close + trail
+}
+
+func (r *renderer) link(n *node) string {
+	content := r.inlines(n.children)
+	href, ok := n.attrs["href"]
+	if !ok || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return content
+	}
+	target := "#link(" + typstpdfgenerator.QuoteString(href) + ")"
+	if strings.TrimSpace(content) == "" {
+		return target
+	}
+	return wrap(target+"[", content, "]")
+}
+
+func (r *renderer) image(n *node) string {
+	src := strings.TrimSpace(n.attrs["src"])
+	if src == "" {
+		return ""
+	}
+
+	name := src
+	var file typstpdfgenerator.MediaFile
+	var err error
+	switch {
+	case strings.HasPrefix(src, "data:"):
+		file, err = decodeDataURL(src)
+	case r.c.Image != nil:
+		file, err = r.c.Image(src)
+	}
+	if err != nil {
+		if r.err == nil {
+			r.err = fmt.Errorf("failed to resolve image %q: %w", truncate(src), err)
+		}
+		return ""
+	}
+	if file.Name != "" {
+		name = file.Name
+		if !r.seenMedia[name] {
+			r.seenMedia[name] = true
+			r.media = append(r.media, file)
+		}
+	}
+
+	out := "#image(" + typstpdfgenerator.QuoteString(name)
+	if width, ok := pixels(n.attrs["width"]); ok {
+		out += ", width: " + width
+	}
+	if alt := strings.TrimSpace(n.attrs["alt"]); alt != "" {
+		out += ", alt: " + typstpdfgenerator.QuoteString(alt)
+	}
+	return out + ")"
+}
+
+// pixels converts an HTML length in CSS pixels to points.
+func pixels(s string) (string, bool) {
+	px, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
+	if err != nil || px <= 0 {
+		return "", false
+	}
+	return strconv.FormatFloat(px*0.75, 'f', -1, 64) + "pt", true
+}
+
+func truncate(s string) string {
+	if len(s) > 64 {
+		return s[:64] + "..."
+	}
+	return s
+}
+
+// decodeDataURL decodes a base64 data URL into a media file named by the
+// digest of its content.
+func decodeDataURL(src string) (typstpdfgenerator.MediaFile, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return typstpdfgenerator.MediaFile{}, fmt.Errorf("only base64 data URLs are supported")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return typstpdfgenerator.MediaFile{}, fmt.Errorf("invalid data URL: %w", err)
+	}
+
+	ext := ".bin"
+	mediaType := strings.TrimSuffix(header, ";base64")
+	switch mediaType {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/svg+xml":
+		ext = ".svg"
+	default:
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	sum := sha256.Sum256(data)
+	return typstpdfgenerator.MediaFile{Name: "images/" + hex.EncodeToString(sum[:8]) + ext, Data: data}, nil
+}
+
+func (r *renderer) list(n *node) string {
+	start := 1
+	if s, err := strconv.Atoi(n.attrs["start"]); err == nil {
+		start = s
+	}
+
+	var items []string
+	for _, child := range n.children {
+		if child.tag == "" && strings.TrimSpace(child.text) == "" {
+			continue
+		}
+		nodes := []*node{child}
+		if child.tag == "li" {
+			nodes = child.children
+		}
+
+		marker := "- "
+		if n.tag == "ol" {
+			marker = strconv.Itoa(start+len(items)) + ". "
+		}
+		// Items without paragraphs stay tight, e.g. text followed by a
+		// nested list.
+		sep := "\n"
+		for _, c := range nodes {
+			if c.tag == "p" {
+				sep = "\n\n"
+			}
+		}
+		body := r.blocksSep(nodes, sep)
+		items = append(items, strings.TrimRight(marker+typstmarkup.Indent(body, strings.Repeat(" ", len(marker))), " "))
+	}
+	return strings.Join(items, "\n")
+}
+
+type tableRow struct {
+	cells  []*node
+	header bool
+}
+
+func (r *renderer) rows(n *node, header bool, rows []tableRow) []tableRow {
+	for _, child := range n.children {
+		switch child.tag {
+		case "thead":
+			rows = r.rows(child, true, rows)
+		case "tbody", "tfoot":
+			rows = r.rows(child, false, rows)
+		case "tr":
+			row := tableRow{header: header}
+			for _, cell := range child.children {
+				if cell.tag == "td" || cell.tag == "th" {
+					row.cells = append(row.cells, cell)
+				}
+			}
+			rows = append(rows, row)
+		case "caption", "colgroup", "":
+		default:
+			r.report(child.tag)
+		}
+	}
+	return rows
+}
+
+func span(cell *node, attr string) int {
+	n, err := strconv.Atoi(cell.attrs[attr])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (r *renderer) table(n *node) string {
+	rows := r.rows(n, false, nil)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	// Without a <thead>, a first row of <th> cells is the header.
+	hasHeader := rows[0].header
+	if !hasHeader && len(rows[0].cells) > 0 {
+		hasHeader = true
+		for _, cell := range rows[0].cells {
+			hasHeader = hasHeader && cell.tag == "th"
+		}
+		rows[0].header = hasHeader
+	}
+
+	columns := 1
+	for _, row := range rows {
+		width := 0
+		for _, cell := range row.cells {
+			width += span(cell, "colspan")
+		}
+		columns = max(columns, width)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#table(\n  columns: %d,\n", columns)
+	var header []string
+	for _, row := range rows {
+		cells := make([]string, len(row.cells))
+		for i, cell := range row.cells {
+			cells[i] = r.cell(cell)
+		}
+		if row.header {
+			header = append(header, cells...)
+			continue
+		}
+		if header != nil {
+			b.WriteString("  table.header(" + strings.Join(header, ", ") + "),\n")
+			header = nil
+		}
+		if len(cells) > 0 {
+			b.WriteString("  " + strings.Join(cells, ", ") + ",\n")
+		}
+	}
+	if header != nil {
+		b.WriteString("  table.header(" + strings.Join(header, ", ") + "),\n")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (r *renderer) cell(cell *node) string {
+	content := r.blocks(cell.children)
+
+	var args []string
+	if n := span(cell, "colspan"); n > 1 {
+		args = append(args, "colspan: "+strconv.Itoa(n))
+	}
+	if n := span(cell, "rowspan"); n > 1 {
+		args = append(args, "rowspan: "+strconv.Itoa(n))
+	}
+	if len(args) == 0 {
+		return "[" + content + "]"
+	}
+	return "table.cell(" + strings.Join(args, ", ") + ")[" + content + "]"
+}
+
+var languageClass = regexp.MustCompile(`(?:^|\s)(?:language|lang)-([A-Za-z0-9_+#.-]+)`)
+
+func (r *renderer) pre(n *node) string {
+	code := textContent(n)
+	code = strings.TrimPrefix(code, "\n")
+	code = strings.TrimRight(code, " \t\n")
+	if code == "" {
+		return ""
+	}
+
+	var lang string
+	for _, c := range append([]*node{n}, n.children...) {
+		if m := languageClass.FindStringSubmatch(c.attrs["class"]); m != nil {
+			lang = m[1]
+			break
+		}
+	}
+
+	return typstmarkup.CodeBlock(code, lang)
+}
+
+func textContent(n *node) string {
+	if n.tag == "" {
+		return n.text
+	}
+	if n.tag == "br" {
+		return "\n"
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package htmltotypst
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"paragraphs", "<p>one\n  two</p><p>three", "one two\n\nthree\n"},
+		{"bare text", "just text", "just text\n"},
+		{"headings", "<h1>Title</h1><h3>Sub <em>x</em></h3>", "= Title\n\n=== Sub #emph[x]\n"},
+		{"escaping", "<p>#tag $5 a_b &lt;x&gt; @me // = -</p>", "\\#tag \\$5 a\\_b \\<x\\> \\@me \\/\\/ \\= \\-\n"},
+		{"numbered paragraph", "<p>2024. A year</p>", "2024\\. A year\n"},
+		{"emphasis", "<p><strong>s</strong> <b>b</b> <em>e</em> <i>i</i></p>", "#strong[s] #strong[b] #emph[e] #emph[i]\n"},
+		{"spaces outside calls", "<p>a<strong> b </strong>c</p>", "a #strong[b] c\n"},
+		{"expression guard", "<p><em>a</em>(b) <strong>c</strong>.</p>", "#emph[a];(b) #strong[c];.\n"},
+		{"code", "<p>run <code>go test</code> or <code>a`b</code></p>", "run `go test` or #raw(\"a`b\")\n"},
+		{"line break", "<p>a<br>b<br/>c</p>", "a#linebreak()b#linebreak()c\n"},
+		{"link", `<p><a href="https://typst.app">Typst</a> <a href="https://x.org"></a> <a name="x">anchor</a></p>`, "#link(\"https://typst.app\")[Typst] #link(\"https://x.org\") anchor\n"},
+		{"unsafe link", `<a href="javascript:alert(1)">x</a>`, "x\n"},
+		{"image", `<p><img src="img/a.png" alt="A" width="200"></p>`, "#image(\"img/a.png\", width: 150pt, alt: \"A\")\n"},
+		{"bullet list", "<ul><li>a<li>b<ul><li>c</li></ul></li></ul>", "- a\n- b\n  - c\n"},
+		{"ordered list", `<ol start="3"><li>a</li><li><p>b</p><p>c</p></li></ol>`, "3. a\n4. b\n\n   c\n"},
+		{
+			"table",
+			"<table><thead><tr><th>Item</th><th>Qty</th></tr></thead><tbody><tr><td>Tea</td><td>2</td></tr><tr><td colspan=\"2\">Total</td></tr></tbody></table>",
+			"#table(\n  columns: 2,\n  table.header([Item], [Qty]),\n  [Tea], [2],\n  table.cell(colspan: 2)[Total],\n)\n",
+		},
+		{
+			"table with header row",
+			"<table><tr><th>A</th></tr><tr><td rowspan=2>1</td></tr></table>",
+			"#table(\n  columns: 1,\n  table.header([A]),\n  table.cell(rowspan: 2)[1],\n)\n",
+		},
+		{"pre", "<pre><code class=\"language-go\">\nx := ```a```\n</code></pre>", "````go\nx := ```a```\n````\n"},
+		{"unsupported kept", "<div><p>a <span>b</span></p></div>", "a b\n"},
+		{"dropped content", "<p>a</p><script>alert('<p>x</p>')</script><style>p{}</style>", "a\n"},
+		{"document", "<!DOCTYPE html><html><head><title>T</title></head><body><p>x</p></body></html>", "x\n"},
+		{"comments", "<p>a<!-- <b>hidden</b> -->b</p>", "ab\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(tt.in)
+			if err != nil {
+				t.Fatalf("Failed to convert: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertUnsupported(t *testing.T) {
+	src := "<div><p>a<span>b</span><u>c</u><span>d</span></p><script>x</script></div>"
+
+	res, err := (&Converter{}).Convert(src)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if want := []string{"div", "span", "u", "script"}; !reflect.DeepEqual(res.Unsupported, want) {
+		t.Errorf("Unsupported = %v, want %v", res.Unsupported, want)
+	}
+
+	_, err = (&Converter{Strict: true}).Convert(src)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || len(unsupported.Tags) != 4 {
+		t.Fatalf("Expected UnsupportedError in strict mode, got %v", err)
+	}
+
+	if _, err := (&Converter{Strict: true}).Convert("<p><strong>ok</strong></p>"); err != nil {
+		t.Errorf("Strict conversion of supported HTML failed: %v", err)
+	}
+}
+
+func TestConvertImages(t *testing.T) {
+	fsys := fstest.MapFS{"media/logo.png": {Data: []byte("logo")}}
+	c := &Converter{Image: ImagesFromFS(fsys)}
+
+	res, err := c.Convert(`<p><img src="./media/logo.png"><img src="media/logo.png" alt="again"><img src="data:image/png;base64,aGVsbG8="></p>`)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if len(res.Media) != 2 {
+		t.Fatalf("Expected 2 media files, got %+v", res.Media)
+	}
+	if res.Media[0].Name != "media/logo.png" || string(res.Media[0].Data) != "logo" {
+		t.Errorf("Media[0] = %q %q, want media/logo.png", res.Media[0].Name, res.Media[0].Data)
+	}
+	if name := res.Media[1].Name; !strings.HasPrefix(name, "images/") || !strings.HasSuffix(name, ".png") || string(res.Media[1].Data) != "hello" {
+		t.Errorf("Media[1] = %q %q, want a decoded data URL", name, res.Media[1].Data)
+	}
+	if !strings.Contains(res.Markup, `#image("`+res.Media[1].Name+`")`) {
+		t.Errorf("Markup does not reference the data URL image:\n%s", res.Markup)
+	}
+
+	for _, src := range []string{"media/missing.png", "../etc/passwd", "https://example.com/a.png", "data:image/png,raw"} {
+		if _, err := c.Convert(`<img src="` + src + `">`); err == nil {
+			t.Errorf("Convert with image %q succeeded, want error", src)
+		}
+	}
+}
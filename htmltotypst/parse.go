@@ -0,0 +1,259 @@
+package htmltotypst
+
+import (
+	"html"
+	"strings"
+)
+
+// node is an element, or a text node when tag is empty.
+type node struct {
+	tag      string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements have content that is not markup.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// closesParagraph holds the tags whose start implicitly ends an open <p>.
+var closesParagraph = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "details": true, "div": true,
+	"dl": true, "fieldset": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "main": true, "nav": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true, "ul": true,
+}
+
+// implicitEnds maps tags to the open elements they end, up to a boundary
+// element, e.g. a new <li> ends the previous one within the same list.
+var implicitEnds = map[string]struct{ ends, boundary []string }{
+	"li":    {[]string{"li"}, []string{"ul", "ol"}},
+	"tr":    {[]string{"tr", "td", "th"}, []string{"table", "thead", "tbody", "tfoot"}},
+	"td":    {[]string{"td", "th"}, []string{"tr", "table"}},
+	"th":    {[]string{"td", "th"}, []string{"tr", "table"}},
+	"thead": {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tbody": {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+	"tfoot": {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table"}},
+}
+
+type treeBuilder struct {
+	root  *node
+	stack []*node
+}
+
+func (b *treeBuilder) top() *node {
+	return b.stack[len(b.stack)-1]
+}
+
+func (b *treeBuilder) appendChild(n *node) {
+	t := b.top()
+	t.children = append(t.children, n)
+}
+
+// find returns the stack index of the innermost open element named tag that
+// is not above one of boundary, or -1.
+func (b *treeBuilder) find(tags, boundary []string) int {
+	for i := len(b.stack) - 1; i > 0; i-- {
+		tag := b.stack[i].tag
+		for _, t := range tags {
+			if tag == t {
+				return i
+			}
+		}
+		for _, t := range boundary {
+			if tag == t {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+func (b *treeBuilder) start(tag string, attrs map[string]string, selfClosing bool) {
+	if closesParagraph[tag] {
+		if i := b.find([]string{"p"}, []string{"li", "td", "th", "div", "blockquote", "table"}); i > 0 {
+			b.stack = b.stack[:i]
+		}
+	}
+	if rule, ok := implicitEnds[tag]; ok {
+		if i := b.find(rule.ends, rule.boundary); i > 0 {
+			b.stack = b.stack[:i]
+		}
+	}
+
+	n := &node{tag: tag, attrs: attrs}
+	b.appendChild(n)
+	if !voidElements[tag] && !selfClosing {
+		b.stack = append(b.stack, n)
+	}
+}
+
+func (b *treeBuilder) end(tag string) {
+	if i := b.find([]string{tag}, nil); i > 0 {
+		b.stack = b.stack[:i]
+	}
+}
+
+func (b *treeBuilder) text(s string) {
+	if s == "" {
+		return
+	}
+	t := b.top()
+	if k := len(t.children); k > 0 && t.children[k-1].tag == "" {
+		t.children[k-1].text += s
+		return
+	}
+	t.children = append(t.children, &node{text: s})
+}
+
+// parse parses an HTML fragment into a tree, closing elements the way
+// browsers do for common markup.
+func parse(src string) *node {
+	b := &treeBuilder{root: &node{tag: "#root"}}
+	b.stack = []*node{b.root}
+
+	for i := 0; i < len(src); {
+		if src[i] != '<' {
+			j := strings.IndexByte(src[i:], '<')
+			if j < 0 {
+				j = len(src) - i
+			}
+			b.text(html.UnescapeString(src[i : i+j]))
+			i += j
+			continue
+		}
+
+		rest := src[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				return b.root
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return b.root
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "</") && len(rest) > 2 && isLetter(rest[2]):
+			name, _ := tagName(rest[2:])
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return b.root
+			}
+			b.end(name)
+			i += end + 1
+		case len(rest) > 1 && isLetter(rest[1]):
+			name, attrs, selfClosing, n := startTag(rest)
+			if n == 0 {
+				b.text("<")
+				i++
+				continue
+			}
+			i += n
+			if rawTextElements[name] {
+				end := strings.Index(strings.ToLower(src[i:]), "</"+name)
+				if end < 0 {
+					end = len(src) - i
+				}
+				n := &node{tag: name, attrs: attrs, children: []*node{{text: src[i : i+end]}}}
+				b.appendChild(n)
+				i += end
+				if gt := strings.IndexByte(src[i:], '>'); gt >= 0 {
+					i += gt + 1
+				}
+				continue
+			}
+			b.start(name, attrs, selfClosing)
+		default:
+			b.text("<")
+			i++
+		}
+	}
+	return b.root
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func tagName(s string) (string, int) {
+	n := 0
+	for n < len(s) && !isSpace(s[n]) && s[n] != '>' && s[n] != '/' {
+		n++
+	}
+	return strings.ToLower(s[:n]), n
+}
+
+// startTag parses the start tag at the beginning of s and returns its length,
+// or 0 if it is not terminated.
+func startTag(s string) (name string, attrs map[string]string, selfClosing bool, length int) {
+	name, n := tagName(s[1:])
+	i := 1 + n
+	attrs = make(map[string]string)
+	for i < len(s) {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		switch {
+		case i >= len(s):
+			return "", nil, false, 0
+		case s[i] == '>':
+			return name, attrs, selfClosing, i + 1
+		case s[i] == '/':
+			selfClosing = true
+			i++
+			continue
+		}
+		selfClosing = false
+
+		start := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && !(s[i] == '/' && i+1 < len(s) && s[i+1] == '>') {
+			i++
+		}
+		key := strings.ToLower(s[start:i])
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) || s[i] != '=' {
+			attrs[key] = ""
+			continue
+		}
+		i++
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			return "", nil, false, 0
+		}
+
+		var value string
+		if q := s[i]; q == '"' || q == '\'' {
+			end := strings.IndexByte(s[i+1:], q)
+			if end < 0 {
+				return "", nil, false, 0
+			}
+			value = s[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+				i++
+			}
+			value = s[start:i]
+		}
+		attrs[key] = html.UnescapeString(value)
+	}
+	return "", nil, false, 0
+}
@@ -0,0 +1,94 @@
+// Package typstmarkup holds the Typst-emitting helpers shared by the markdown
+// and htmltotypst converters.
+package typstmarkup
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+var enumStart = regexp.MustCompile(`^(\d+)\.`)
+
+// GuardLineStart escapes a paragraph that would otherwise start a numbered
+// list, e.g. one from the Markdown "2024\. was a good year".
+func GuardLineStart(s string) string {
+	if m := enumStart.FindStringSubmatch(s); m != nil {
+		return m[1] + `\.` + s[len(m[0]):]
+	}
+	return s
+}
+
+// NeedsSemicolon reports whether next, written right after prev, would
+// continue an embedded expression such as "#emph[...]" ending prev, which a
+// following "(", "[" or "." does. A semicolon ends the expression.
+func NeedsSemicolon(prev, next string) bool {
+	return strings.HasPrefix(strings.TrimLeft(prev, " "), "#") &&
+		strings.ContainsAny(prev[len(prev)-1:], ")]") &&
+		next != "" && strings.ContainsAny(next[:1], "([.")
+}
+
+// Indent indents all lines but the first.
+func Indent(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var backtickRun = regexp.MustCompile("`+")
+
+// InlineCode renders code as raw text, falling back to #raw when backticks
+// cannot delimit it.
+func InlineCode(code string) string {
+	if code == "" || strings.Contains(code, "`") || strings.HasPrefix(code, " ") {
+		return "#raw(" + typstpdfgenerator.QuoteString(code) + ")"
+	}
+	return "`" + code + "`"
+}
+
+// CodeBlock renders code as a raw block in lang, fenced with more backticks
+// than any run in code.
+func CodeBlock(code, lang string) string {
+	fence := 3
+	for _, run := range backtickRun.FindAllString(code, -1) {
+		fence = max(fence, len(run)+1)
+	}
+	delim := strings.Repeat("`", fence)
+	return delim + lang + "\n" + code + "\n" + delim
+}
+
+// ImagesFromFS returns an image resolver that loads images from fsys and
+// names them by their paths in it. Remote images and paths outside fsys are
+// rejected. With unescape, sources are percent-decoded first, as URLs are.
+func ImagesFromFS(fsys fs.FS, unescape bool) func(src string) (typstpdfgenerator.MediaFile, error) {
+	return func(src string) (typstpdfgenerator.MediaFile, error) {
+		if strings.Contains(src, "://") || strings.HasPrefix(src, "//") {
+			return typstpdfgenerator.MediaFile{}, fmt.Errorf("remote image %q is not supported", src)
+		}
+		name := src
+		if unescape {
+			if u, err := url.PathUnescape(src); err == nil {
+				name = u
+			}
+		}
+		name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "./"))
+		if !fs.ValidPath(name) {
+			return typstpdfgenerator.MediaFile{}, fmt.Errorf("image %q is outside the media directory", src)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return typstpdfgenerator.MediaFile{}, fmt.Errorf("failed to read image: %w", err)
+		}
+		return typstpdfgenerator.MediaFile{Name: name, Data: data}, nil
+	}
+}
@@ -0,0 +1,62 @@
+package typstmarkup
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMarkupHelpers(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"guard enum", GuardLineStart("2024. was a good year"), `2024\. was a good year`},
+		{"guard plain", GuardLineStart("In 2024. it rained"), "In 2024. it rained"},
+		{"indent", Indent("a\n\nb\nc", "  "), "a\n\n  b\n  c"},
+		{"inline code", InlineCode("x := 1"), "`x := 1`"},
+		{"inline code backtick", InlineCode("a`b"), `#raw("a` + "`" + `b")`},
+		{"inline code leading space", InlineCode(" x"), `#raw(" x")`},
+		{"code block", CodeBlock("fmt.Println()", "go"), "```go\nfmt.Println()\n```"},
+		{"code block fence", CodeBlock("````", ""), "`````\n````\n`````"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestNeedsSemicolon(t *testing.T) {
+	tests := []struct {
+		prev, next string
+		want       bool
+	}{
+		{"#emph[a]", "(b)", true},
+		{" #link(\"x\")", ".", true},
+		{"#emph[a]", " b", false},
+		{"text]", "(b)", false},
+		{"#linebreak()", "", false},
+	}
+	for _, tt := range tests {
+		if got := NeedsSemicolon(tt.prev, tt.next); got != tt.want {
+			t.Errorf("NeedsSemicolon(%q, %q) = %v", tt.prev, tt.next, got)
+		}
+	}
+}
+
+func TestImagesFromFS(t *testing.T) {
+	fsys := fstest.MapFS{"img/a b.png": {Data: []byte("png")}}
+
+	if m, err := ImagesFromFS(fsys, true)("./img/a%20b.png"); err != nil || m.Name != "img/a b.png" {
+		t.Errorf("Unescaped image = %+v, %v", m, err)
+	}
+	if _, err := ImagesFromFS(fsys, false)("img/a%20b.png"); err == nil {
+		t.Error("Expected error without unescaping")
+	}
+	for _, src := range []string{"https://example.com/a.png", "//example.com/a.png", "../a.png", "/etc/passwd"} {
+		if _, err := ImagesFromFS(fsys, true)(src); err == nil {
+			t.Errorf("Expected error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// JobID identifies an asynchronous conversion on the gateway.
+type JobID string
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobStatus is the state of an asynchronous conversion, as returned by Status
+// and delivered to webhooks.
+type JobStatus struct {
+	ID      JobID    `json:"id"`
+	State   JobState `json:"state"`
+	Message string   `json:"message,omitempty"`
+	Stdout  string   `json:"stdout,omitempty"`
+	Stderr  string   `json:"stderr,omitempty"`
+}
+
+// Done reports whether the job reached a terminal state.
+func (s JobStatus) Done() bool {
+	return s.State == JobSucceeded || s.State == JobFailed
+}
+
+type jobRequest struct {
+	typstRequest
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type jobSubmitResponse struct {
+	ID JobID `json:"id"`
+}
+
+// WithJobWebhook asks the gateway to POST the final JobStatus of every job
+// submitted with Submit to callbackURL. See NewWebhookHandler.
+func WithJobWebhook(callbackURL string) Option {
+	return func(c *Client) error {
+		u, err := url.Parse(callbackURL)
+		if err != nil {
+			return fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid webhook URL scheme: expected http or https")
+		}
+		c.jobWebhook = callbackURL
+		return nil
+	}
+}
+
+// WithJobPollInterval sets the longest interval between status checks in
+// Wait. Polling starts at a short interval and backs off up to this value.
+func WithJobPollInterval(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("poll interval must be positive")
+		}
+		c.jobPollInterval = interval
+		return nil
+	}
+}
+
+func jobEndpoint(c *Client, id JobID, elem ...string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("job ID cannot be empty")
+	}
+	return c.endpoint(append([]string{"jobs", url.PathEscape(string(id))}, elem...)...), nil
+}
+
+// Submit queues a conversion on the gateway and returns immediately. Use it
+// for documents whose render time exceeds proxy or load balancer timeouts.
+func (c *Client) Submit(ctx context.Context, req *Request) (JobID, error) {
+	if req == nil {
+		return "", fmt.Errorf("request cannot be nil")
+	}
+
+	prepared, err := c.prepare(ctx, req.Content, req.Template, c.resolveOptions(req.Options), req.Media)
+	if err != nil {
+		return "", err
+	}
+	body := jobRequest{typstRequest: prepared.body, CallbackURL: c.jobWebhook}
+
+	var resp jobSubmitResponse
+	if err := c.doJSON(ctx, http.MethodPost, c.endpoint("jobs"), contextCorrelationID(ctx), body, &resp); err != nil {
+		return "", err
+	}
+	if resp.ID == "" {
+		return "", &ConnectionError{Message: "gateway returned no job ID"}
+	}
+	return resp.ID, nil
+}
+
+// Status returns the current state of a job.
+func (c *Client) Status(ctx context.Context, id JobID) (JobStatus, error) {
+	endpoint, err := jobEndpoint(c, id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	var status JobStatus
+	if err := c.doJSON(ctx, http.MethodGet, endpoint, contextCorrelationID(ctx), nil, &status); err != nil {
+		return JobStatus{}, err
+	}
+	if status.ID == "" {
+		status.ID = id
+	}
+	return status, nil
+}
+
+// Wait polls until the job finishes or ctx is done. A failed job is reported
+// as a *NotGeneratedError alongside its status.
+func (c *Client) Wait(ctx context.Context, id JobID) (JobStatus, error) {
+	interval := 250 * time.Millisecond
+	for {
+		status, err := c.Status(ctx, id)
+		if err != nil {
+			return status, err
+		}
+		if status.State == JobFailed {
+			msg := status.Message
+			if msg == "" {
+				msg = "Unknown error"
+			}
+			return status, &NotGeneratedError{Message: msg, CorrelationID: CorrelationIDFromContext(ctx)}
+		}
+		if status.Done() {
+			return status, nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status, &ConnectionError{Err: ctx.Err()}
+		case <-timer.C:
+		}
+		interval = min(interval*2, c.jobPollInterval)
+	}
+}
+
+// Fetch writes the PDF of a finished job to w.
+func (c *Client) Fetch(ctx context.Context, id JobID, w io.Writer) (ResponseInfo, error) {
+	endpoint, err := jobEndpoint(c, id, "result")
+	if err != nil {
+		return ResponseInfo{}, err
+	}
+
+	pdfData, info, err := c.result(ctx, http.MethodGet, endpoint, contextCorrelationID(ctx), nil)
+	if err != nil {
+		return info, err
+	}
+
+	if _, err := w.Write(pdfData); err != nil {
+		return info, fmt.Errorf("failed to write PDF data: %w", err)
+	}
+	return info, nil
+}
+
+// NewWebhookHandler returns an http.Handler for job completion callbacks.
+//
+// The gateway must sign callbacks like HMACSigner does; requests with an
+// invalid or replayed signature are rejected with 401 before fn is called.
+func NewWebhookHandler(secret []byte, window time.Duration, fn func(context.Context, JobStatus)) (http.Handler, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("webhook callback cannot be nil")
+	}
+	verifier, err := NewHMACVerifier(secret, window)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var status JobStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil || status.ID == "" {
+			http.Error(w, "invalid job status", http.StatusBadRequest)
+			return
+		}
+
+		fn(r.Context(), status)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return verifier.Middleware(handler), nil
+}
@@ -0,0 +1,206 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJobGateway finishes every job after a fixed number of status polls.
+type fakeJobGateway struct {
+	mu        sync.Mutex
+	polls     map[JobID]int
+	callbacks map[JobID]string
+	failing   bool
+}
+
+func (g *fakeJobGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/jobs":
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Template == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		id := JobID("job-" + string(rune('a'+len(g.polls))))
+		g.polls[id] = 0
+		g.callbacks[id] = req.CallbackURL
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(jobSubmitResponse{ID: id})
+
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/result"):
+		writePDFResponse(w, fakePDF)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/jobs/"):
+		id := JobID(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+		polls, ok := g.polls[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		g.polls[id] = polls + 1
+
+		status := JobStatus{ID: id, State: JobRunning}
+		if polls >= 2 {
+			status.State = JobSucceeded
+			if g.failing {
+				status.State = JobFailed
+				status.Message = "compile error"
+			}
+		}
+		_ = json.NewEncoder(w).Encode(status)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeJobGateway() *fakeJobGateway {
+	return &fakeJobGateway{polls: map[JobID]int{}, callbacks: map[JobID]string{}}
+}
+
+func TestJobSubmitWaitFetch(t *testing.T) {
+	gateway := newFakeJobGateway()
+	server := newFakeGateway(t, gateway.ServeHTTP)
+
+	client, err := New("test-key", server.URL,
+		WithJobPollInterval(10*time.Millisecond),
+		WithJobWebhook("https://app.example/hooks/typst"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	id, err := client.Submit(ctx, &Request{Template: []byte("= Big report")})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if gateway.callbacks[id] != "https://app.example/hooks/typst" {
+		t.Errorf("Unexpected callback URL %q", gateway.callbacks[id])
+	}
+
+	status, err := client.Wait(ctx, id)
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if status.State != JobSucceeded || gateway.polls[id] != 3 {
+		t.Errorf("Unexpected status %+v after %d polls", status, gateway.polls[id])
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Fetch(ctx, id, &buf); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if buf.String() != fakePDF {
+		t.Errorf("Unexpected PDF %q", buf.String())
+	}
+}
+
+func TestJobWaitFailed(t *testing.T) {
+	gateway := newFakeJobGateway()
+	gateway.failing = true
+	server := newFakeGateway(t, gateway.ServeHTTP)
+
+	client, err := New("test-key", server.URL, WithJobPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	id, err := client.Submit(context.Background(), &Request{Template: []byte("= Broken")})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	status, err := client.Wait(context.Background(), id)
+	if !errors.Is(err, ErrNotGenerated) || status.Message != "compile error" {
+		t.Errorf("Expected not generated error, got %v (%+v)", err, status)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	secret := []byte("webhook-secret")
+
+	var received []JobStatus
+	handler, err := NewWebhookHandler(secret, time.Minute, func(_ context.Context, status JobStatus) {
+		received = append(received, status)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	newCallback := func(t *testing.T, signWith []byte) *http.Request {
+		t.Helper()
+		body, _ := json.Marshal(JobStatus{ID: "job-a", State: JobSucceeded})
+		req := httptest.NewRequest(http.MethodPost, "/hooks/typst", bytes.NewReader(body))
+		signer, err := NewHMACSigner(signWith)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		if err := signer.Apply(context.Background(), req); err != nil {
+			t.Fatalf("Failed to sign callback: %v", err)
+		}
+		return req
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newCallback(t, secret))
+	if rec.Code != http.StatusNoContent || len(received) != 1 || received[0].ID != "job-a" {
+		t.Fatalf("Signed callback not accepted: %d %v", rec.Code, received)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newCallback(t, []byte("forged")))
+	if rec.Code != http.StatusUnauthorized || len(received) != 1 {
+		t.Errorf("Forged callback accepted: %d", rec.Code)
+	}
+}
+
+func TestJobSubmitPreparesLikeConvert(t *testing.T) {
+	var got jobRequest
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(jobSubmitResponse{ID: "job-a"})
+	})
+
+	template := []byte(`#import "@preview/cetz:0.3.0": canvas` + "\n" + `#image("logo.png")`)
+	fsys := testPackageCache()
+	fsys["logo.png"] = fsys["preview/cetz/0.3.0/typst.toml"]
+	client, err := New("test-key", server.URL, WithMediaResolver(fsys), WithPackages(fsys, nil))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if _, err := client.Submit(context.Background(), &Request{Template: template}); err != nil {
+		t.Fatalf("Failed to submit: %v", err)
+	}
+	for _, name := range []string{"logo.png", "packages/preview/cetz/0.3.0/src/lib.typ"} {
+		if _, ok := got.Media[name]; !ok {
+			t.Errorf("Submit did not attach %s", name)
+		}
+	}
+	if !slices.Contains(got.Options, "--package-path=packages") {
+		t.Errorf("Unexpected options %v", got.Options)
+	}
+
+	limited, err := New("test-key", server.URL, WithMediaResolver(fsys), WithMaxRequestSize(64))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := limited.Submit(context.Background(), &Request{Template: template}); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("Expected ErrRequestTooLarge, got %v", err)
+	}
+}
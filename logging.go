@@ -0,0 +1,103 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WithLogger logs every conversion to logger: successful ones at debug level,
+// failed ones at warn level. Every request to the gateway, including retries,
+// jobs, capabilities and media store calls, is logged as well: at debug level,
+// or warn level when no response was received.
+//
+// Records carry request metadata (correlation ID, gateway host, sizes, options,
+// status, latency) but never credentials or media contents. The content
+// argument is only logged when WithLogRedactor is set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithLogRedactor logs the content argument of Convert after passing it
+// through redact, e.g. to mask personal data.
+func WithLogRedactor(redact func(content string) string) Option {
+	return func(c *Client) error {
+		c.redact = redact
+		return nil
+	}
+}
+
+func (c *Client) logConversion(ctx context.Context, latency time.Duration, info ResponseInfo, content string, templateData []byte, options []string, media []MediaFile, err error) {
+	level := slog.LevelDebug
+	msg := "typst conversion finished"
+	if err != nil {
+		level = slog.LevelWarn
+		msg = "typst conversion failed"
+	}
+	if !c.logger.Enabled(ctx, level) {
+		return
+	}
+
+	var mediaBytes int
+	for _, m := range media {
+		mediaBytes += len(m.Data)
+	}
+
+	attrs := []slog.Attr{
+		slog.String("correlation_id", info.CorrelationID),
+		slog.String("gateway_host", c.gateway.Host),
+		slog.Int("template_bytes", len(templateData)),
+		slog.Int("content_bytes", len(content)),
+		slog.Int("media_count", len(media)),
+		slog.Int("media_bytes", mediaBytes),
+		slog.Any("options", options),
+		slog.Int("status", info.StatusCode),
+		slog.Duration("latency", latency),
+		slog.Int("stdout_bytes", len(info.Stdout)),
+		slog.Int("stderr_bytes", len(info.Stderr)),
+	}
+	if info.LeaderCorrelationID != "" {
+		attrs = append(attrs, slog.String("leader_correlation_id", info.LeaderCorrelationID))
+	}
+	if c.redact != nil {
+		attrs = append(attrs, slog.String("content", c.redact(content)))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	c.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (c *Client) logRequest(ctx context.Context, req *http.Request, resp *http.Response, latency time.Duration, err error) {
+	level := slog.LevelDebug
+	msg := "typst gateway request finished"
+	if err != nil {
+		level = slog.LevelWarn
+		msg = "typst gateway request failed"
+	}
+	if !c.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("correlation_id", req.Header.Get("X-Correlation-ID")),
+		slog.String("gateway_host", req.URL.Host),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.Int64("request_bytes", req.ContentLength),
+		slog.Duration("latency", latency),
+	}
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	c.logger.LogAttrs(ctx, level, msg, attrs...)
+}
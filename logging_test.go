@@ -0,0 +1,137 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRecordsRequestMetadata(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		writePDFResponse(w, fakePDF)
+	})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client, err := New("super-secret-key", server.URL, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	media := []MediaFile{{Name: "data.json", Data: []byte(`{"ssn":"123-45-6789"}`)}}
+	ctx := WithCorrelationID(context.Background(), "log-test")
+	var buf bytes.Buffer
+	if _, err := client.Convert(ctx, &buf, "Patient: Jane Doe", []byte("= Hi"), nil, media); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	for _, secret := range []string{"super-secret-key", "123-45-6789", "Jane Doe"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("Log output leaked %q: %s", secret, logs.String())
+		}
+	}
+
+	record := logRecord(t, &logs, "typst conversion finished")
+	if record["level"] != "DEBUG" || record["correlation_id"] != "log-test" {
+		t.Errorf("Unexpected record: %v", record)
+	}
+	if record["media_count"] != float64(1) || record["status"] != float64(http.StatusOK) {
+		t.Errorf("Unexpected record: %v", record)
+	}
+}
+
+func TestLoggerRedactorAndFailures(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	client, err := New("test-key", server.URL,
+		WithLogger(logger),
+		WithLogRedactor(func(content string) string { return strings.Repeat("*", len(content)) }),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "secret", []byte("= Hi"), nil, nil); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	record := logRecord(t, &logs, "typst conversion failed")
+	if record["level"] != "WARN" || record["content"] != "******" || record["status"] != float64(http.StatusBadGateway) {
+		t.Errorf("Unexpected record: %v", record)
+	}
+}
+
+func TestLoggerRecordsGatewayRequests(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/jobs":
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]string{"id": "job-1"})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client, err := New("super-secret-key", server.URL, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx := WithCorrelationID(context.Background(), "log-test")
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if _, err := client.Submit(ctx, &Request{Template: []byte("= Hi")}); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	if strings.Contains(logs.String(), "super-secret-key") {
+		t.Errorf("Log output leaked the auth key: %s", logs.String())
+	}
+	var paths []string
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("Failed to parse log record %q: %v", line, err)
+		}
+		if record["msg"] == "typst gateway request finished" && record["correlation_id"] == "log-test" {
+			paths = append(paths, record["method"].(string)+" "+record["path"].(string))
+		}
+	}
+	if want := []string{"GET /healthz", "POST /jobs"}; !slices.Equal(paths, want) {
+		t.Errorf("Logged requests %v, want %v", paths, want)
+	}
+}
+
+// logRecord returns the first record in logs with the message msg.
+func logRecord(t *testing.T, logs *bytes.Buffer, msg string) map[string]any {
+	t.Helper()
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("Failed to parse log record %q: %v", line, err)
+		}
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	t.Fatalf("No %q record in %s", msg, logs.String())
+	return nil
+}
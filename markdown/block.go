@@ -0,0 +1,486 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	atxHeading      = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreak   = regexp.MustCompile(`^(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextUnderline = regexp.MustCompile(`^(=+|-+)[ \t]*$`)
+	fenceOpen       = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*([^`]*)$")
+	listMarker      = regexp.MustCompile(`^([-+*]|(\d{1,9})([.)]))([ \t]+|$)`)
+	htmlBlockStart  = regexp.MustCompile(`^<(?:[A-Za-z][A-Za-z0-9-]*(?:[\s/>]|$)|/[A-Za-z]|!--)`)
+	tableDelimiter  = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	linkDefinition  = regexp.MustCompile(`^\[((?:[^\]\\]|\\.)+)\]:[ \t]*(<[^>\n]*>|\S+)(?:[ \t]+("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^)\\]|\\.)*\)))?[ \t]*$`)
+)
+
+type linkRef struct {
+	dest, title string
+}
+
+type blockParser struct {
+	refs map[string]linkRef
+}
+
+// Parse parses CommonMark, with GitHub-style tables, into a Document node.
+func Parse(src []byte) *Node {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+
+	p := &blockParser{refs: make(map[string]linkRef)}
+	doc := &Node{Kind: Document, Children: p.parse(lines)}
+	p.parseInlines(doc)
+	return doc
+}
+
+// expandTabs replaces tabs in the indentation of line with spaces up to the
+// next multiple of four columns.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i, r := range line {
+		switch r {
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// removeIndent removes up to n leading spaces.
+func removeIndent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
+}
+
+// interruptsParagraph reports whether line starts a block that ends a
+// paragraph.
+func interruptsParagraph(line string) bool {
+	if isBlank(line) || indentOf(line) >= 4 {
+		return isBlank(line)
+	}
+	rest := strings.TrimLeft(line, " ")
+	if atxHeading.MatchString(rest) || thematicBreak.MatchString(rest) || fenceOpen.MatchString(rest) ||
+		strings.HasPrefix(rest, ">") || htmlBlockStart.MatchString(rest) {
+		return true
+	}
+	if m := listMarker.FindStringSubmatch(rest); m != nil && strings.TrimSpace(rest[len(m[0]):]) != "" {
+		return m[2] == "" || m[2] == "1"
+	}
+	return false
+}
+
+func (p *blockParser) parse(lines []string) []*Node {
+	var blocks []*Node
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			if n := p.paragraph(para); n != nil {
+				blocks = append(blocks, n)
+			}
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			flush()
+			i++
+			continue
+		}
+
+		ind := indentOf(line)
+		if ind >= 4 {
+			if len(para) > 0 {
+				para = append(para, line)
+				i++
+				continue
+			}
+			i = p.indentedCode(lines, i, &blocks)
+			continue
+		}
+		rest := line[ind:]
+
+		if len(para) > 0 {
+			if m := setextUnderline.FindStringSubmatch(rest); m != nil {
+				level := 1
+				if m[1][0] == '-' {
+					level = 2
+				}
+				blocks = append(blocks, &Node{Kind: Heading, Level: level, raw: joinParagraph(para)})
+				para = nil
+				i++
+				continue
+			}
+		}
+
+		switch {
+		case fenceOpen.MatchString(rest) && !(rest[0] == '`' && strings.Contains(fenceOpen.FindStringSubmatch(rest)[2], "`")):
+			flush()
+			i = p.fencedCode(lines, i, ind, &blocks)
+		case atxHeading.MatchString(rest):
+			flush()
+			m := atxHeading.FindStringSubmatch(rest)
+			blocks = append(blocks, &Node{Kind: Heading, Level: len(m[1]), raw: m[2]})
+			i++
+		case thematicBreak.MatchString(rest):
+			flush()
+			blocks = append(blocks, &Node{Kind: ThematicBreak})
+			i++
+		case strings.HasPrefix(rest, ">"):
+			flush()
+			i = p.blockQuote(lines, i, &blocks)
+		case listMarker.MatchString(rest) && (len(para) == 0 || interruptsParagraph(line)):
+			flush()
+			i = p.list(lines, i, &blocks)
+		case htmlBlockStart.MatchString(rest):
+			flush()
+			j := i
+			for j < len(lines) && !isBlank(lines[j]) {
+				j++
+			}
+			blocks = append(blocks, &Node{Kind: HTMLBlock, Literal: strings.Join(lines[i:j], "\n")})
+			i = j
+		case len(para) == 0 && strings.Contains(rest, "|") && i+1 < len(lines) && p.isTableStart(rest, lines[i+1]):
+			i = p.table(lines, i, &blocks)
+		default:
+			para = append(para, line)
+			i++
+		}
+	}
+	flush()
+	return blocks
+}
+
+func (p *blockParser) indentedCode(lines []string, i int, blocks *[]*Node) int {
+	var code []string
+	j := i
+	for j < len(lines) && (isBlank(lines[j]) || indentOf(lines[j]) >= 4) {
+		code = append(code, removeIndent(lines[j], 4))
+		j++
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+		j--
+	}
+	*blocks = append(*blocks, &Node{Kind: CodeBlock, Literal: strings.Join(code, "\n") + "\n"})
+	return j
+}
+
+func (p *blockParser) fencedCode(lines []string, i, ind int, blocks *[]*Node) int {
+	m := fenceOpen.FindStringSubmatch(lines[i][ind:])
+	fence := m[1]
+	info := strings.TrimSpace(unescape(m[2]))
+
+	var code []string
+	j := i + 1
+	for ; j < len(lines); j++ {
+		rest := strings.TrimLeft(lines[j], " ")
+		if indentOf(lines[j]) < 4 && strings.HasPrefix(rest, fence) && strings.Trim(rest, string(fence[0])+" \t") == "" {
+			j++
+			break
+		}
+		code = append(code, removeIndent(lines[j], ind))
+	}
+
+	literal := strings.Join(code, "\n")
+	if len(code) > 0 {
+		literal += "\n"
+	}
+	*blocks = append(*blocks, &Node{Kind: CodeBlock, Info: info, Literal: literal})
+	return j
+}
+
+func (p *blockParser) blockQuote(lines []string, i int, blocks *[]*Node) int {
+	var inner []string
+	j := i
+	for j < len(lines) {
+		line := lines[j]
+		rest := strings.TrimLeft(line, " ")
+		switch {
+		case indentOf(line) < 4 && strings.HasPrefix(rest, ">"):
+			rest = rest[1:]
+			if strings.HasPrefix(rest, " ") {
+				rest = rest[1:]
+			}
+			inner = append(inner, rest)
+		case !isBlank(line) && len(inner) > 0 && !isBlank(inner[len(inner)-1]) && !interruptsParagraph(line):
+			// Lazy continuation of a paragraph.
+			inner = append(inner, line)
+		default:
+			*blocks = append(*blocks, &Node{Kind: BlockQuote, Children: p.parse(inner)})
+			return j
+		}
+		j++
+	}
+	*blocks = append(*blocks, &Node{Kind: BlockQuote, Children: p.parse(inner)})
+	return j
+}
+
+type marker struct {
+	ordered bool
+	char    byte // bullet character or ordered delimiter
+	start   int
+	indent  int // column where the item content starts
+	empty   bool
+}
+
+func parseMarker(line string) (marker, bool) {
+	ind := indentOf(line)
+	if ind >= 4 {
+		return marker{}, false
+	}
+	rest := line[ind:]
+	if thematicBreak.MatchString(rest) {
+		return marker{}, false
+	}
+	m := listMarker.FindStringSubmatch(rest)
+	if m == nil {
+		return marker{}, false
+	}
+
+	mk := marker{char: m[1][0]}
+	if m[2] != "" {
+		mk.ordered = true
+		mk.char = m[3][0]
+		mk.start, _ = strconv.Atoi(m[2])
+	}
+	spaces := len(m[4])
+	mk.empty = isBlank(rest[len(m[0]):])
+	if spaces == 0 || spaces > 4 || mk.empty {
+		// Content indented by five or more spaces is an indented code block.
+		spaces = 1
+	}
+	mk.indent = ind + len(m[1]) + spaces
+	return mk, true
+}
+
+func isMarker(line string) bool {
+	_, ok := parseMarker(line)
+	return ok
+}
+
+func (p *blockParser) list(lines []string, i int, blocks *[]*Node) int {
+	first, _ := parseMarker(lines[i])
+	list := &Node{Kind: List, Ordered: first.ordered, Start: first.start, Tight: true}
+
+	j := i
+	for j < len(lines) {
+		mk, ok := parseMarker(lines[j])
+		if !ok || mk.ordered != first.ordered || mk.char != first.char {
+			break
+		}
+
+		var item []string
+		if mk.indent <= len(lines[j]) {
+			item = append(item, lines[j][mk.indent:])
+		} else {
+			item = append(item, "")
+		}
+		j++
+		for j < len(lines) {
+			line := lines[j]
+			switch {
+			case isBlank(line):
+				if mk.empty && len(item) == 1 {
+					// An item can begin with at most one blank line.
+					goto done
+				}
+				item = append(item, "")
+			case indentOf(line) >= mk.indent:
+				item = append(item, line[mk.indent:])
+			case !isBlank(item[len(item)-1]) && !interruptsParagraph(line) && !isMarker(line) && !setextUnderline.MatchString(strings.TrimSpace(line)):
+				item = append(item, strings.TrimLeft(line, " "))
+			default:
+				goto done
+			}
+			j++
+		}
+	done:
+		trailing := 0
+		for len(item) > 1 && isBlank(item[len(item)-1]) {
+			item = item[:len(item)-1]
+			trailing++
+		}
+		if trailing > 0 && j < len(lines) {
+			if next, ok := parseMarker(lines[j]); ok && next.ordered == first.ordered && next.char == first.char {
+				list.Tight = false
+			}
+		}
+		for k := 1; k < len(item)-1; k++ {
+			if isBlank(item[k]) && indentOf(item[k+1]) == 0 {
+				list.Tight = false
+			}
+		}
+
+		list.Children = append(list.Children, &Node{Kind: ListItem, Children: p.parse(item)})
+		if trailing > 0 {
+			// Resume at the blank lines so that they end the list if it does
+			// not continue.
+			j -= trailing
+			for j < len(lines) && isBlank(lines[j]) {
+				j++
+			}
+			if j < len(lines) {
+				if next, ok := parseMarker(lines[j]); !ok || next.ordered != first.ordered || next.char != first.char {
+					break
+				}
+			}
+		}
+	}
+
+	*blocks = append(*blocks, list)
+	return j
+}
+
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func (p *blockParser) isTableStart(header, delimiter string) bool {
+	if indentOf(delimiter) >= 4 || !tableDelimiter.MatchString(strings.TrimSpace(delimiter)) {
+		return false
+	}
+	return len(splitRow(header)) == len(splitRow(delimiter))
+}
+
+func (p *blockParser) table(lines []string, i int, blocks *[]*Node) int {
+	var align []Alignment
+	for _, d := range splitRow(lines[i+1]) {
+		left, right := strings.HasPrefix(d, ":"), strings.HasSuffix(d, ":")
+		switch {
+		case left && right:
+			align = append(align, AlignCenter)
+		case left:
+			align = append(align, AlignLeft)
+		case right:
+			align = append(align, AlignRight)
+		default:
+			align = append(align, AlignNone)
+		}
+	}
+
+	table := &Node{Kind: Table, Align: align}
+	row := func(line string, header bool) {
+		cells := splitRow(line)
+		r := &Node{Kind: TableRow, Header: header}
+		for c := range align {
+			var raw string
+			if c < len(cells) {
+				raw = cells[c]
+			}
+			r.Children = append(r.Children, &Node{Kind: TableCell, raw: raw})
+		}
+		table.Children = append(table.Children, r)
+	}
+
+	row(lines[i], true)
+	j := i + 2
+	for j < len(lines) && !isBlank(lines[j]) && !interruptsParagraph(lines[j]) {
+		row(lines[j], false)
+		j++
+	}
+	*blocks = append(*blocks, table)
+	return j
+}
+
+// paragraph strips link reference definitions from the start of a paragraph
+// and returns the rest, or nil if nothing is left.
+func (p *blockParser) paragraph(lines []string) *Node {
+	for len(lines) > 0 {
+		m := linkDefinition.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			break
+		}
+		label := normalizeLabel(m[1])
+		if _, exists := p.refs[label]; !exists {
+			dest := strings.TrimSuffix(strings.TrimPrefix(m[2], "<"), ">")
+			title := m[3]
+			if len(title) >= 2 {
+				title = title[1 : len(title)-1]
+			}
+			p.refs[label] = linkRef{dest: unescape(dest), title: unescape(title)}
+		}
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return &Node{Kind: Paragraph, raw: joinParagraph(lines)}
+}
+
+func joinParagraph(lines []string) string {
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimLeft(line, " ")
+	}
+	return strings.TrimRight(strings.Join(trimmed, "\n"), " ")
+}
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// parseInlines replaces the raw content of paragraphs, headings and table
+// cells below n with inline nodes, once all link definitions are known.
+func (p *blockParser) parseInlines(n *Node) {
+	switch n.Kind {
+	case Paragraph, Heading, TableCell:
+		n.Children = parseInline(n.raw, p.refs)
+		n.raw = ""
+		return
+	}
+	for _, c := range n.Children {
+		p.parseInlines(c)
+	}
+}
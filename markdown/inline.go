@@ -0,0 +1,471 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	autolinkURI   = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\x00-\x20]*)>`)
+	autolinkEmail = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	inlineHTML    = regexp.MustCompile(`^(?:<[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][A-Za-z0-9_.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>|</[A-Za-z][A-Za-z0-9-]*\s*>|<!--[\s\S]*?-->)`)
+)
+
+const asciiPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// unescape removes backslash escapes of ASCII punctuation and decodes HTML
+// entities, as in link destinations and titles.
+func unescape(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunct, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return html.UnescapeString(b.String())
+}
+
+// delimiter is a run of "*" or "_" that may open or close emphasis.
+type delimiter struct {
+	node     *Node
+	char     byte
+	count    int
+	orig     int
+	canOpen  bool
+	canClose bool
+}
+
+type inlineParser struct {
+	src    string
+	pos    int
+	refs   map[string]linkRef
+	nodes  []*Node
+	delims []*delimiter
+	text   strings.Builder
+}
+
+func parseInline(src string, refs map[string]linkRef) []*Node {
+	p := &inlineParser{src: src, refs: refs}
+	p.run()
+	return mergeText(processEmphasis(p.nodes, p.delims))
+}
+
+func (p *inlineParser) flushText() {
+	if p.text.Len() > 0 {
+		p.nodes = append(p.nodes, &Node{Kind: Text, Literal: html.UnescapeString(p.text.String())})
+		p.text.Reset()
+	}
+}
+
+func (p *inlineParser) add(n *Node) {
+	p.flushText()
+	p.nodes = append(p.nodes, n)
+}
+
+func (p *inlineParser) run() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '\\':
+			p.escape()
+		case '`':
+			p.codeSpan()
+		case '*', '_':
+			p.delimiterRun(c)
+		case '!':
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '[' && p.link(p.pos+1, true) {
+				continue
+			}
+			p.text.WriteByte(c)
+			p.pos++
+		case '[':
+			if p.link(p.pos, false) {
+				continue
+			}
+			p.text.WriteByte(c)
+			p.pos++
+		case '<':
+			p.angle()
+		case '\n':
+			p.lineBreak()
+		default:
+			end := p.pos + 1
+			for end < len(p.src) && strings.IndexByte("\\`*_![<\n", p.src[end]) < 0 {
+				end++
+			}
+			p.text.WriteString(p.src[p.pos:end])
+			p.pos = end
+		}
+	}
+	p.flushText()
+}
+
+func (p *inlineParser) escape() {
+	if p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if next == '\n' {
+			p.add(&Node{Kind: HardBreak})
+			p.pos += 2
+			return
+		}
+		if strings.IndexByte(asciiPunct, next) >= 0 {
+			// Escaped characters are never entities, so bypass unescaping.
+			p.flushText()
+			p.nodes = append(p.nodes, &Node{Kind: Text, Literal: string(next)})
+			p.pos += 2
+			return
+		}
+	}
+	p.text.WriteByte('\\')
+	p.pos++
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// closingBackticks returns the index of the next run of exactly n backticks
+// at or after i, or -1.
+func closingBackticks(s string, i, n int) int {
+	for i < len(s) {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		j += i
+		run := runLength(s, j, '`')
+		if run == n {
+			return j
+		}
+		i = j + run
+	}
+	return -1
+}
+
+func (p *inlineParser) codeSpan() {
+	n := runLength(p.src, p.pos, '`')
+	start := p.pos + n
+	end := closingBackticks(p.src, start, n)
+	if end < 0 {
+		p.text.WriteString(p.src[p.pos:start])
+		p.pos = start
+		return
+	}
+
+	code := strings.ReplaceAll(p.src[start:end], "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	p.add(&Node{Kind: Code, Literal: code})
+	p.pos = end + n
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func (p *inlineParser) delimiterRun(c byte) {
+	n := runLength(p.src, p.pos, c)
+	before, after := ' ', ' '
+	if p.pos > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.src[:p.pos])
+	}
+	if p.pos+n < len(p.src) {
+		after, _ = utf8.DecodeRuneInString(p.src[p.pos+n:])
+	}
+
+	left := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	right := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+	d := &delimiter{char: c, count: n, orig: n, canOpen: left, canClose: right}
+	if c == '_' {
+		d.canOpen = left && (!right || isPunct(before))
+		d.canClose = right && (!left || isPunct(after))
+	}
+
+	d.node = &Node{Kind: Text, Literal: p.src[p.pos : p.pos+n]}
+	p.add(d.node)
+	p.delims = append(p.delims, d)
+	p.pos += n
+}
+
+func (p *inlineParser) angle() {
+	rest := p.src[p.pos:]
+	if m := autolinkURI.FindStringSubmatch(rest); m != nil {
+		p.add(&Node{Kind: Link, Dest: m[1], Children: []*Node{{Kind: Text, Literal: m[1]}}})
+		p.pos += len(m[0])
+		return
+	}
+	if m := autolinkEmail.FindStringSubmatch(rest); m != nil {
+		p.add(&Node{Kind: Link, Dest: "mailto:" + m[1], Children: []*Node{{Kind: Text, Literal: m[1]}}})
+		p.pos += len(m[0])
+		return
+	}
+	if m := inlineHTML.FindString(rest); m != "" {
+		p.add(&Node{Kind: HTMLInline, Literal: m})
+		p.pos += len(m)
+		return
+	}
+	p.text.WriteByte('<')
+	p.pos++
+}
+
+func (p *inlineParser) lineBreak() {
+	p.flushText()
+	kind := SoftBreak
+	if len(p.nodes) > 0 {
+		if last := p.nodes[len(p.nodes)-1]; last.Kind == Text {
+			trimmed := strings.TrimRight(last.Literal, " ")
+			if len(last.Literal)-len(trimmed) >= 2 {
+				kind = HardBreak
+			}
+			last.Literal = trimmed
+		}
+	}
+	p.nodes = append(p.nodes, &Node{Kind: kind})
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// closingBracket returns the index of the "]" matching the "[" at i, or -1.
+func closingBracket(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			n := runLength(s, j, '`')
+			if end := closingBackticks(s, j+n, n); end >= 0 {
+				j = end + n - 1
+			} else {
+				j += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// link parses a link, or an image when image is set, whose text starts with
+// the "[" at open. It reports false if there is none.
+func (p *inlineParser) link(open int, image bool) bool {
+	close := closingBracket(p.src, open)
+	if close < 0 {
+		return false
+	}
+	text := p.src[open+1 : close]
+
+	dest, title, end, ok := inlineDestination(p.src, close+1)
+	if !ok {
+		label := text
+		end = close + 1
+		if end < len(p.src) && p.src[end] == '[' {
+			if c := closingBracket(p.src, end); c >= 0 {
+				if c > end+1 {
+					label = p.src[end+1 : c]
+				}
+				end = c + 1
+			}
+		}
+		ref, found := p.refs[normalizeLabel(label)]
+		if !found {
+			return false
+		}
+		dest, title = ref.dest, ref.title
+	}
+
+	n := &Node{Kind: Link, Dest: dest, Title: title, Children: parseInline(text, p.refs)}
+	if image {
+		n.Kind = Image
+	}
+	p.add(n)
+	p.pos = end
+	return true
+}
+
+// inlineDestination parses "(dest "title")" at i.
+func inlineDestination(s string, i int) (dest, title string, end int, ok bool) {
+	if i >= len(s) || s[i] != '(' {
+		return "", "", 0, false
+	}
+	i = skipSpace(s, i+1)
+
+	switch {
+	case i < len(s) && s[i] == '<':
+		j := i + 1
+		for j < len(s) && s[j] != '>' && s[j] != '\n' {
+			if s[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(s) || s[j] != '>' {
+			return "", "", 0, false
+		}
+		dest = s[i+1 : j]
+		i = j + 1
+	default:
+		j, depth := i, 0
+	loop:
+		for j < len(s) {
+			switch c := s[j]; {
+			case c == '\\' && j+1 < len(s):
+				j++
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			case c <= ' ':
+				break loop
+			}
+			j++
+		}
+		dest = s[i:j]
+		i = j
+	}
+
+	j := skipSpace(s, i)
+	if j < len(s) && j > i && strings.IndexByte(`"'(`, s[j]) >= 0 {
+		closer := s[j]
+		if closer == '(' {
+			closer = ')'
+		}
+		k := j + 1
+		for k < len(s) && s[k] != closer {
+			if s[k] == '\\' {
+				k++
+			}
+			k++
+		}
+		if k >= len(s) {
+			return "", "", 0, false
+		}
+		title = s[j+1 : k]
+		j = skipSpace(s, k+1)
+	}
+	if j >= len(s) || s[j] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(dest), unescape(title), j + 1, true
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// processEmphasis turns matching delimiter runs into Emphasis and Strong
+// nodes, following the CommonMark algorithm.
+func processEmphasis(nodes []*Node, delims []*delimiter) []*Node {
+	indexOf := func(n *Node) int {
+		for i, m := range nodes {
+			if m == n {
+				return i
+			}
+		}
+		return -1
+	}
+
+	ci := 0
+	for ci < len(delims) {
+		closer := delims[ci]
+		if !closer.canClose {
+			ci++
+			continue
+		}
+
+		oi := -1
+		for k := ci - 1; k >= 0; k-- {
+			o := delims[k]
+			if o.char != closer.char || !o.canOpen {
+				continue
+			}
+			if (o.canClose || closer.canOpen) && (o.orig+closer.orig)%3 == 0 && (o.orig%3 != 0 || closer.orig%3 != 0) {
+				continue
+			}
+			oi = k
+			break
+		}
+		if oi < 0 {
+			if !closer.canOpen {
+				delims = append(delims[:ci], delims[ci+1:]...)
+			} else {
+				ci++
+			}
+			continue
+		}
+
+		opener := delims[oi]
+		use, kind := 1, Emphasis
+		if opener.count >= 2 && closer.count >= 2 {
+			use, kind = 2, Strong
+		}
+		a, b := indexOf(opener.node), indexOf(closer.node)
+		inner := append([]*Node(nil), nodes[a+1:b]...)
+		wrapped := append(append(append([]*Node(nil), nodes[:a+1]...), &Node{Kind: kind, Children: inner}), nodes[b:]...)
+		nodes = wrapped
+
+		opener.count -= use
+		opener.node.Literal = opener.node.Literal[:opener.count]
+		closer.count -= use
+		closer.node.Literal = closer.node.Literal[:closer.count]
+
+		delims = append(delims[:oi+1], delims[ci:]...)
+		ci = oi + 1
+		if opener.count == 0 {
+			nodes = append(nodes[:indexOf(opener.node)], nodes[indexOf(opener.node)+1:]...)
+			delims = append(delims[:oi], delims[oi+1:]...)
+			ci--
+		}
+		if closer.count == 0 {
+			nodes = append(nodes[:indexOf(closer.node)], nodes[indexOf(closer.node)+1:]...)
+			delims = append(delims[:ci], delims[ci+1:]...)
+		}
+	}
+	return nodes
+}
+
+// mergeText joins adjacent Text nodes and drops empty ones.
+func mergeText(nodes []*Node) []*Node {
+	var out []*Node
+	for _, n := range nodes {
+		if n.Kind == Text {
+			if n.Literal == "" {
+				continue
+			}
+			if len(out) > 0 && out[len(out)-1].Kind == Text {
+				out[len(out)-1] = &Node{Kind: Text, Literal: out[len(out)-1].Literal + n.Literal}
+				continue
+			}
+		}
+		if n.Kind == Emphasis || n.Kind == Strong {
+			n.Children = mergeText(n.Children)
+		}
+		out = append(out, n)
+	}
+	return out
+}
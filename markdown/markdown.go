@@ -0,0 +1,275 @@
+// Package markdown converts CommonMark, with GitHub-style tables, to Typst
+// markup suitable for the content argument of Client.Convert.
+//
+// Headings, paragraphs, lists, block quotes, code, links, images, tables and
+// thematic breaks are supported. Raw HTML is dropped unless a Hook renders
+// it. Text is escaped so that it is printed literally.
+//
+// It has no dependencies outside the standard library.
+package markdown
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+	"github.com/4sigma/typstpdfgenerator/internal/typstmarkup"
+)
+
+// Hook renders a node in place of the default rendering. children is the
+// rendered content of the node's children. A hook returns false to fall back
+// to the default rendering.
+type Hook func(n *Node, children string) (string, bool)
+
+// Converter converts Markdown to Typst markup.
+type Converter struct {
+	// Hooks override the rendering of nodes by kind.
+	Hooks map[Kind]Hook
+	// Image resolves the destination of an image to the media file it is
+	// loaded from; the image is referenced by the file's name. If nil,
+	// destinations are referenced as they are.
+	Image func(dest string) (typstpdfgenerator.MediaFile, error)
+}
+
+// Result is the outcome of a conversion.
+type Result struct {
+	// Markup is the Typst markup.
+	Markup string
+	// Media holds the files resolved by Converter.Image, once each.
+	Media []typstpdfgenerator.MediaFile
+}
+
+// Convert converts src with the default Converter.
+func Convert(src []byte) (string, error) {
+	res, err := (&Converter{}).Convert(src)
+	if err != nil {
+		return "", err
+	}
+	return res.Markup, nil
+}
+
+// Convert converts src to Typst markup.
+func (c *Converter) Convert(src []byte) (*Result, error) {
+	return c.Render(Parse(src))
+}
+
+// Render renders a parsed document to Typst markup.
+func (c *Converter) Render(doc *Node) (*Result, error) {
+	r := &renderer{c: c, seen: make(map[string]bool)}
+	markup := r.render(doc)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &Result{Markup: markup, Media: r.media}, nil
+}
+
+// ImagesFromDir returns a Converter.Image function that loads images from
+// dir and names them by their paths relative to it. Remote images and paths
+// outside dir are rejected.
+func ImagesFromDir(dir string) func(dest string) (typstpdfgenerator.MediaFile, error) {
+	return ImagesFromFS(os.DirFS(dir))
+}
+
+// ImagesFromFS is like ImagesFromDir for an fs.FS.
+func ImagesFromFS(fsys fs.FS) func(dest string) (typstpdfgenerator.MediaFile, error) {
+	return typstmarkup.ImagesFromFS(fsys, false)
+}
+
+type renderer struct {
+	c     *Converter
+	media []typstpdfgenerator.MediaFile
+	seen  map[string]bool
+	err   error
+}
+
+func (r *renderer) render(n *Node) string {
+	var children string
+	switch n.Kind {
+	case Document, BlockQuote, ListItem:
+		children = r.blocks(n.Children, "\n\n")
+	case List, Table, TableRow:
+		// Rendered by the default rendering of the node itself.
+	default:
+		children = r.inlines(n.Children)
+	}
+
+	if hook, ok := r.c.Hooks[n.Kind]; ok {
+		if out, ok := hook(n, children); ok {
+			return out
+		}
+	}
+
+	switch n.Kind {
+	case Document:
+		if children == "" {
+			return ""
+		}
+		return children + "\n"
+	case Paragraph:
+		return typstmarkup.GuardLineStart(children)
+	case Heading:
+		return strings.Repeat("=", n.Level) + " " + children
+	case BlockQuote:
+		return "#quote(block: true)[\n" + children + "\n]"
+	case List:
+		return r.list(n)
+	case ListItem:
+		return children
+	case CodeBlock:
+		return codeBlock(n.Literal, n.Info)
+	case ThematicBreak:
+		return "#line(length: 100%)"
+	case HTMLBlock, HTMLInline:
+		return ""
+	case Table:
+		return r.table(n)
+	case TableCell:
+		return "[" + children + "]"
+	case Text:
+		return typstpdfgenerator.EscapeMarkup(n.Literal)
+	case Emphasis:
+		return "#emph[" + children + "]"
+	case Strong:
+		return "#strong[" + children + "]"
+	case Code:
+		return typstmarkup.InlineCode(n.Literal)
+	case Link:
+		if children == "" || n.Children[0].Kind == Text && n.PlainText() == n.Dest {
+			return "#link(" + typstpdfgenerator.QuoteString(n.Dest) + ")"
+		}
+		return "#link(" + typstpdfgenerator.QuoteString(n.Dest) + ")[" + children + "]"
+	case Image:
+		return r.image(n)
+	case SoftBreak:
+		return " "
+	case HardBreak:
+		return "#linebreak()"
+	}
+	return children
+}
+
+func (r *renderer) blocks(nodes []*Node, sep string) string {
+	var parts []string
+	for _, n := range nodes {
+		if s := r.render(n); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// inlines concatenates rendered inline nodes. An embedded expression such as
+// "#emph[...]" would continue into a following "(", "[" or ".", so it is
+// ended with a semicolon there.
+func (r *renderer) inlines(nodes []*Node) string {
+	var b strings.Builder
+	prev := ""
+	for _, n := range nodes {
+		s := r.render(n)
+		if s == "" {
+			continue
+		}
+		if typstmarkup.NeedsSemicolon(prev, s) {
+			b.WriteByte(';')
+		}
+		b.WriteString(s)
+		prev = s
+	}
+	return b.String()
+}
+
+func (r *renderer) list(n *Node) string {
+	sep := "\n"
+	if !n.Tight {
+		sep = "\n\n"
+	}
+
+	items := make([]string, len(n.Children))
+	for i, item := range n.Children {
+		marker := "- "
+		if n.Ordered {
+			marker = fmt.Sprintf("%d. ", n.Start+i)
+		}
+		var body string
+		if n.Tight {
+			body = r.blocks(item.Children, "\n")
+		} else {
+			body = r.render(item)
+		}
+		if hook, ok := r.c.Hooks[ListItem]; ok && n.Tight {
+			if out, ok := hook(item, body); ok {
+				body = out
+			}
+		}
+		items[i] = marker + typstmarkup.Indent(body, strings.Repeat(" ", len(marker)))
+	}
+	return strings.Join(items, sep)
+}
+
+func (r *renderer) table(n *Node) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#table(\n  columns: %d,\n", len(n.Align))
+
+	aligned := false
+	names := make([]string, len(n.Align))
+	for i, a := range n.Align {
+		names[i] = [...]string{"auto", "left", "center", "right"}[a]
+		aligned = aligned || a != AlignNone
+	}
+	if aligned {
+		fmt.Fprintf(&b, "  align: (%s),\n", strings.Join(names, ", "))
+	}
+
+	for _, row := range n.Children {
+		cells := make([]string, len(row.Children))
+		for i, cell := range row.Children {
+			cells[i] = r.render(cell)
+		}
+		line := strings.Join(cells, ", ")
+		if hook, ok := r.c.Hooks[TableRow]; ok {
+			if out, ok := hook(row, line); ok {
+				line = out
+			}
+		}
+		if row.Header {
+			line = "table.header(" + line + ")"
+		}
+		b.WriteString("  " + line + ",\n")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (r *renderer) image(n *Node) string {
+	name := n.Dest
+	if r.c.Image != nil {
+		file, err := r.c.Image(n.Dest)
+		if err != nil {
+			if r.err == nil {
+				r.err = fmt.Errorf("failed to resolve image %q: %w", n.Dest, err)
+			}
+			return ""
+		}
+		name = file.Name
+		if !r.seen[name] {
+			r.seen[name] = true
+			r.media = append(r.media, file)
+		}
+	}
+
+	out := "#image(" + typstpdfgenerator.QuoteString(name)
+	if alt := n.PlainText(); alt != "" {
+		out += ", alt: " + typstpdfgenerator.QuoteString(alt)
+	}
+	return out + ")"
+}
+
+var langTag = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+#.-]*`)
+
+func codeBlock(code, info string) string {
+	lang := langTag.FindString(strings.TrimSpace(info))
+	return typstmarkup.CodeBlock(strings.TrimSuffix(code, "\n"), lang)
+}
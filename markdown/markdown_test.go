@@ -0,0 +1,149 @@
+package markdown
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"heading", "# Title\n\n### Sub #", "= Title\n\n=== Sub\n"},
+		{"setext heading", "Title\n=====\n\nSub\n---", "= Title\n\n== Sub\n"},
+		{"paragraphs", "one\ntwo\n\nthree", "one two\n\nthree\n"},
+		{"escaping", "#hash $5 a_b 1 < 2 > 0 @ref // c = d", "\\#hash \\$5 a\\_b 1 \\< 2 \\> 0 \\@ref \\/\\/ c \\= d\n"},
+		{"backslash escape", `\*not em\*`, "\\*not em\\*\n"},
+		{"numbered paragraph", `2024\. A year`, "2024\\. A year\n"},
+		{"emphasis", "*em* _em_ **strong** __strong__", "#emph[em] #emph[em] #strong[strong] #strong[strong]\n"},
+		{"nested emphasis", "***both*** and *a **b** c*", "#emph[#strong[both]] and #emph[a #strong[b] c]\n"},
+		{"intraword underscore", "snake_case_name", "snake\\_case\\_name\n"},
+		{"unmatched", "a * b **c", "a \\* b \\*\\*c\n"},
+		{"inline code", "run `go test` or ``a ` b``", "run `go test` or #raw(\"a ` b\")\n"},
+		{"link", `[docs](https://typst.app "Typst")`, "#link(\"https://typst.app\")[docs]\n"},
+		{"autolink", "<https://typst.app>", "#link(\"https://typst.app\")\n"},
+		{"email", "<me@example.com>", "#link(\"mailto:me@example.com\")[me\\@example.com]\n"},
+		{"reference link", "[Typst][t]\n\n[t]: https://typst.app", "#link(\"https://typst.app\")[Typst]\n"},
+		{"expression guard", "*a*(b) **c**.", "#emph[a];(b) #strong[c];.\n"},
+		{"hard break", "a  \nb\\\nc", "a#linebreak()b#linebreak()c\n"},
+		{"image", "![A *cat*](img/cat.png)", "#image(\"img/cat.png\", alt: \"A cat\")\n"},
+		{"bullet list", "- a\n- b\n  - c\n* d", "- a\n- b\n  - c\n\n- d\n"},
+		{"ordered list", "3. a\n4. b", "3. a\n4. b\n"},
+		{"loose list", "1. a\n\n   more\n2. b", "1. a\n\n   more\n\n2. b\n"},
+		{"block quote", "> quoted\nlazy\n\n> > nested", "#quote(block: true)[\nquoted lazy\n]\n\n#quote(block: true)[\n#quote(block: true)[\nnested\n]\n]\n"},
+		{"fenced code", "```go\nfmt.Println(\"*\")\n```", "```go\nfmt.Println(\"*\")\n```\n"},
+		{"fenced backticks", "~~~\n```\n~~~", "````\n```\n````\n"},
+		{"indented code", "    x := 1\n\n    y := 2", "```\nx := 1\n\ny := 2\n```\n"},
+		{"thematic break", "a\n\n***\n\nb", "a\n\n#line(length: 100%)\n\nb\n"},
+		{"html dropped", "<div>\nhi\n</div>\n\na <b>b</b>", "a b\n"},
+		{"entities", "&amp; &copy; `&amp;`", "& © `&amp;`\n"},
+		{
+			"table",
+			"| Item | Qty | Price |\n|:-----|:---:|------:|\n| *Tea* | 2 | 1\\|2 |\n| Cake |",
+			"#table(\n  columns: 3,\n  align: (left, center, right),\n  table.header([Item], [Qty], [Price]),\n  [#emph[Tea]], [2], [1|2],\n  [Cake], [], [],\n)\n",
+		},
+		{"table without alignment", "a|b\n-|-\n1|2", "#table(\n  columns: 2,\n  table.header([a], [b]),\n  [1], [2],\n)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("Failed to convert: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertHooks(t *testing.T) {
+	c := &Converter{Hooks: map[Kind]Hook{
+		BlockQuote: func(n *Node, children string) (string, bool) {
+			return "#block(inset: 1em)[" + children + "]", true
+		},
+		HTMLInline: func(n *Node, children string) (string, bool) {
+			return "#linebreak()", n.Literal == "<br>"
+		},
+		Heading: func(n *Node, children string) (string, bool) {
+			// Fall back to the default rendering for other levels.
+			return "#title[" + children + "]", n.Level == 1
+		},
+	}}
+
+	res, err := c.Convert([]byte("# Report\n\n## Summary\n\n> a<br>b<span>c</span>"))
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	want := "#title[Report]\n\n== Summary\n\n#block(inset: 1em)[a#linebreak()bc]\n"
+	if res.Markup != want {
+		t.Errorf("Markup =\n%s\nwant\n%s", res.Markup, want)
+	}
+}
+
+func TestConvertImages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"img/logo.png":  {Data: []byte("logo")},
+		"img/chart.png": {Data: []byte("chart")},
+	}
+	c := &Converter{Image: ImagesFromFS(fsys)}
+
+	res, err := c.Convert([]byte("![Logo](./img/logo.png) ![](img/chart.png)\n\n![again](img/logo.png)"))
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	want := "#image(\"img/logo.png\", alt: \"Logo\") #image(\"img/chart.png\")\n\n#image(\"img/logo.png\", alt: \"again\")\n"
+	if res.Markup != want {
+		t.Errorf("Markup =\n%s\nwant\n%s", res.Markup, want)
+	}
+	if len(res.Media) != 2 || res.Media[0].Name != "img/logo.png" || string(res.Media[1].Data) != "chart" {
+		t.Errorf("Media = %+v, want logo and chart once each", res.Media)
+	}
+
+	for _, dest := range []string{"img/missing.png", "../secret.png", "https://example.com/a.png"} {
+		if _, err := c.Convert([]byte("![x](" + dest + ")")); err == nil {
+			t.Errorf("Convert with image %q succeeded, want error", dest)
+		}
+	}
+
+	renamed := &Converter{Image: func(dest string) (typstpdfgenerator.MediaFile, error) {
+		if dest != "a.png" {
+			return typstpdfgenerator.MediaFile{}, errors.New("unknown")
+		}
+		return typstpdfgenerator.MediaFile{Name: "media/0.png", Data: []byte("a")}, nil
+	}}
+	res, err = renamed.Convert([]byte("![a](a.png)"))
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if res.Markup != "#image(\"media/0.png\", alt: \"a\")\n" {
+		t.Errorf("Markup = %q, want the rewritten name", res.Markup)
+	}
+}
+
+func TestConvertExample(t *testing.T) {
+	src, err := os.ReadFile("../test/typst/elspub/md_content/content.md")
+	if err != nil {
+		t.Fatalf("Failed to read example: %v", err)
+	}
+
+	got, err := Convert(src)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if !strings.HasPrefix(got, "= ") {
+		t.Errorf("Converted example does not start with a heading:\n%s", got)
+	}
+	if want, n := strings.Count(string(src), "\n# "), strings.Count(got, "\n= "); n != want {
+		t.Errorf("Converted example has %d headings after the first, want %d", n, want)
+	}
+}
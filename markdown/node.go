@@ -0,0 +1,115 @@
+package markdown
+
+// Kind identifies the type of a Node.
+type Kind int
+
+const (
+	Document Kind = iota
+	Paragraph
+	Heading
+	BlockQuote
+	List
+	ListItem
+	CodeBlock
+	ThematicBreak
+	HTMLBlock
+	Table
+	TableRow
+	TableCell
+
+	Text
+	Emphasis
+	Strong
+	Code
+	Link
+	Image
+	SoftBreak
+	HardBreak
+	HTMLInline
+)
+
+var kindNames = [...]string{
+	Document:      "Document",
+	Paragraph:     "Paragraph",
+	Heading:       "Heading",
+	BlockQuote:    "BlockQuote",
+	List:          "List",
+	ListItem:      "ListItem",
+	CodeBlock:     "CodeBlock",
+	ThematicBreak: "ThematicBreak",
+	HTMLBlock:     "HTMLBlock",
+	Table:         "Table",
+	TableRow:      "TableRow",
+	TableCell:     "TableCell",
+	Text:          "Text",
+	Emphasis:      "Emphasis",
+	Strong:        "Strong",
+	Code:          "Code",
+	Link:          "Link",
+	Image:         "Image",
+	SoftBreak:     "SoftBreak",
+	HardBreak:     "HardBreak",
+	HTMLInline:    "HTMLInline",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(?)"
+}
+
+// Alignment is the alignment of a table column.
+type Alignment int
+
+const (
+	AlignNone Alignment = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Node is an element of a parsed Markdown document.
+type Node struct {
+	Kind     Kind
+	Children []*Node
+
+	// Literal is the text of Text, Code, CodeBlock, HTMLBlock and
+	// HTMLInline nodes.
+	Literal string
+	// Level is the level of a Heading, 1 to 6.
+	Level int
+	// Ordered, Start and Tight describe a List. Tight lists have no blank
+	// lines between their items.
+	Ordered bool
+	Start   int
+	Tight   bool
+	// Info is the info string of a fenced CodeBlock, e.g. its language.
+	Info string
+	// Dest and Title are the destination and title of a Link or Image.
+	Dest  string
+	Title string
+	// Align holds the column alignments of a Table.
+	Align []Alignment
+	// Header marks the header TableRow.
+	Header bool
+
+	// raw is the unparsed inline content of paragraphs, headings and cells.
+	raw string
+}
+
+// PlainText returns the text of n and its descendants without markup, e.g.
+// the alt text of an Image.
+func (n *Node) PlainText() string {
+	switch n.Kind {
+	case Text, Code:
+		return n.Literal
+	case SoftBreak, HardBreak:
+		return " "
+	}
+	var s string
+	for _, c := range n.Children {
+		s += c.PlainText()
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// mediaStoreHeader is set by gateways that keep uploaded media addressable by
+// content digest. Its value lists the supported digest algorithms.
+const mediaStoreHeader = "X-Typst-Media-Store"
+
+const (
+	mediaStoreUnknown int32 = iota
+	mediaStoreSupported
+	mediaStoreUnsupported
+)
+
+// WithMediaStore enables content-addressed media uploads.
+//
+// Once the gateway has advertised a media store (via the X-Typst-Media-Store
+// response header), the client first sends the SHA-256 digests of all media
+// and uploads only the blobs the gateway reports as missing. Until then, and
+// whenever the negotiation fails, the full request is sent as usual.
+func WithMediaStore() Option {
+	return func(c *Client) error {
+		c.mediaStore = &mediaStore{}
+		return nil
+	}
+}
+
+type mediaStore struct {
+	state atomic.Int32
+}
+
+type mediaQuery struct {
+	Digests []string `json:"digests"`
+}
+
+type mediaQueryResponse struct {
+	Missing []string `json:"missing"`
+}
+
+func (s *mediaStore) supported() bool {
+	return s.state.Load() == mediaStoreSupported
+}
+
+// observe records whether a render response advertised the media store.
+func (s *mediaStore) observe(resp *http.Response) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	for _, alg := range strings.Split(resp.Header.Get(mediaStoreHeader), ",") {
+		if strings.EqualFold(strings.TrimSpace(alg), "sha256") {
+			s.state.Store(mediaStoreSupported)
+			return
+		}
+	}
+	s.state.Store(mediaStoreUnsupported)
+}
+
+func mediaDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// exchange sends reqBody to the gateway, uploading only the media the
+// gateway lacks when the media store is available.
+func (c *Client) exchange(ctx context.Context, correlationID string, reqBody typstRequest, jsonData []byte, media []MediaFile) ([]byte, ResponseInfo, error) {
+	if c.mediaStore == nil || len(media) == 0 || !c.mediaStore.supported() {
+		return c.send(ctx, correlationID, jsonData)
+	}
+
+	partial, ok := c.partialRequest(ctx, correlationID, reqBody, media)
+	if !ok {
+		return c.send(ctx, correlationID, jsonData)
+	}
+
+	pdfData, info, err := c.send(ctx, correlationID, partial)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusConflict {
+		// A referenced blob was evicted between the query and the render.
+		return c.send(ctx, correlationID, jsonData)
+	}
+	return pdfData, info, err
+}
+
+// partialRequest asks the gateway which media blobs it lacks and encodes a
+// request that references the others by digest. ok is false when the caller
+// should fall back to the full request.
+func (c *Client) partialRequest(ctx context.Context, correlationID string, reqBody typstRequest, media []MediaFile) (jsonData []byte, ok bool) {
+	refs := make(map[string]string, len(media))
+	query := mediaQuery{Digests: make([]string, 0, len(media))}
+	seen := make(map[string]bool, len(media))
+	for _, m := range media {
+		digest := mediaDigest(m.Data)
+		refs[m.Name] = digest
+		if !seen[digest] {
+			seen[digest] = true
+			query.Digests = append(query.Digests, digest)
+		}
+	}
+
+	var result mediaQueryResponse
+	if err := c.doJSON(ctx, http.MethodPost, c.endpoint("media", "missing"), correlationID, query, &result); err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			switch httpErr.StatusCode {
+			case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				c.mediaStore.state.Store(mediaStoreUnsupported)
+			}
+		}
+		return nil, false
+	}
+
+	missing := make(map[string]bool, len(result.Missing))
+	for _, digest := range result.Missing {
+		missing[digest] = true
+	}
+
+	upload := make(map[string]string, len(result.Missing))
+	for name, digest := range refs {
+		if missing[digest] {
+			upload[name] = reqBody.Media[name]
+			delete(refs, name)
+		}
+	}
+
+	reqBody.Media = upload
+	reqBody.MediaRefs = refs
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, false
+	}
+	return jsonData, true
+}
@@ -0,0 +1,161 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// fakeMediaStore is a gateway that keeps uploaded media by digest.
+type fakeMediaStore struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   []map[string]string
+	advertise bool
+
+	evictOnRender bool
+}
+
+func (s *fakeMediaStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/media/missing" {
+		if !s.advertise {
+			http.NotFound(w, r)
+			return
+		}
+		var q mediaQuery
+		_ = json.NewDecoder(r.Body).Decode(&q)
+		resp := mediaQueryResponse{Missing: []string{}}
+		for _, d := range q.Digests {
+			if _, ok := s.blobs[d]; !ok {
+				resp.Missing = append(resp.Missing, d)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var req typstRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if s.evictOnRender {
+		s.blobs = map[string][]byte{}
+		s.evictOnRender = false
+	}
+	s.uploads = append(s.uploads, req.Media)
+	for name, digest := range req.MediaRefs {
+		if _, ok := s.blobs[digest]; !ok {
+			http.Error(w, "unknown media "+name, http.StatusConflict)
+			return
+		}
+	}
+	for _, encoded := range req.Media {
+		data, _ := base64.StdEncoding.DecodeString(encoded)
+		s.blobs[mediaDigest(data)] = data
+	}
+	if s.advertise {
+		w.Header().Set(mediaStoreHeader, "sha256")
+	}
+	writePDFResponse(w, fakePDF)
+}
+
+func TestMediaStoreUploadsOnlyMissingMedia(t *testing.T) {
+	store := &fakeMediaStore{blobs: map[string][]byte{}, advertise: true}
+	server := newFakeGateway(t, store.ServeHTTP)
+
+	client, err := New("test-key", server.URL, WithMediaStore())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	font := MediaFile{Name: "fonts/Lato-Regular.ttf", Data: []byte("font-data")}
+	logo := MediaFile{Name: "logo.png", Data: []byte("logo-data")}
+
+	steps := []struct {
+		media      []MediaFile
+		wantUpload []string
+	}{
+		{media: []MediaFile{font}, wantUpload: []string{"fonts/Lato-Regular.ttf"}},
+		{media: []MediaFile{font}, wantUpload: nil},
+		{media: []MediaFile{font, logo}, wantUpload: []string{"logo.png"}},
+	}
+
+	for i, step := range steps {
+		var buf bytes.Buffer
+		if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, step.media); err != nil {
+			t.Fatalf("Step %d: Convert failed: %v", i, err)
+		}
+		if buf.String() != fakePDF {
+			t.Fatalf("Step %d: unexpected PDF %q", i, buf.String())
+		}
+
+		uploaded := store.uploads[len(store.uploads)-1]
+		if len(uploaded) != len(step.wantUpload) {
+			t.Fatalf("Step %d: uploaded %d blobs, want %d", i, len(uploaded), len(step.wantUpload))
+		}
+		for _, name := range step.wantUpload {
+			if _, ok := uploaded[name]; !ok {
+				t.Errorf("Step %d: expected %s to be uploaded", i, name)
+			}
+		}
+	}
+}
+
+func TestMediaStoreFallsBackWhenEvicted(t *testing.T) {
+	store := &fakeMediaStore{blobs: map[string][]byte{}, advertise: true}
+	server := newFakeGateway(t, store.ServeHTTP)
+
+	client, err := New("test-key", server.URL, WithMediaStore())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	media := []MediaFile{{Name: "data.json", Data: []byte(`{}`)}}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, media); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// The query still reports the blob as present, but it is gone by the
+	// time the render request arrives.
+	store.mu.Lock()
+	store.evictOnRender = true
+	store.mu.Unlock()
+
+	buf.Reset()
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, media); err != nil {
+		t.Fatalf("Convert after eviction failed: %v", err)
+	}
+	if got := store.uploads[len(store.uploads)-1]; len(got) != 1 {
+		t.Errorf("Expected full upload after fallback, got %d blobs", len(got))
+	}
+}
+
+func TestMediaStoreNotAdvertised(t *testing.T) {
+	store := &fakeMediaStore{blobs: map[string][]byte{}}
+	server := newFakeGateway(t, store.ServeHTTP)
+
+	client, err := New("test-key", server.URL, WithMediaStore())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	media := []MediaFile{{Name: "data.json", Data: []byte(`{}`)}}
+
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, media); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if got := store.uploads[i]; len(got) != 1 {
+			t.Errorf("Request %d: expected full upload, got %d blobs", i, len(got))
+		}
+	}
+	if client.mediaStore.supported() {
+		t.Error("Media store should not be marked as supported")
+	}
+}
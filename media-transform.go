@@ -0,0 +1,356 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"slices"
+	"sync"
+)
+
+// MediaTransformer rewrites a media file before it is sent. Transformers
+// return files they do not handle unchanged.
+type MediaTransformer interface {
+	Transform(m MediaFile) (MediaFile, error)
+}
+
+// Transform returns m passed through transformers in order.
+func (m MediaFile) Transform(transformers ...MediaTransformer) (MediaFile, error) {
+	for _, t := range transformers {
+		var err error
+		if m, err = t.Transform(m); err != nil {
+			return MediaFile{}, err
+		}
+	}
+	return m, nil
+}
+
+// WithMediaTransformers makes Convert and Submit pass every media file
+// through transformers. Convert reports the bytes saved in
+// ResponseInfo.MediaBytesSaved.
+func WithMediaTransformers(transformers ...MediaTransformer) Option {
+	return func(c *Client) error {
+		c.transformers = append(c.transformers, transformers...)
+		return nil
+	}
+}
+
+// transformMedia applies the client's transformers and returns the number of
+// bytes saved.
+func (c *Client) transformMedia(media []MediaFile) ([]MediaFile, int, error) {
+	if len(c.transformers) == 0 {
+		return media, 0, nil
+	}
+	transformed := make([]MediaFile, len(media))
+	saved := 0
+	for i, m := range media {
+		t, err := m.Transform(c.transformers...)
+		if err != nil {
+			return nil, 0, err
+		}
+		transformed[i] = t
+		saved += len(m.Data) - len(t.Data)
+	}
+	return transformed, saved, nil
+}
+
+// ImageOptimizer downscales PNG and JPEG images larger than needed to print
+// them at MaxDimension and DPI, and re-encodes them. Other files, images
+// that are small enough and images that would not get smaller are left
+// unchanged. Downscaled images record DPI, so that their natural size in
+// typst is MaxDimension.
+//
+// Results are cached by image content, so images sent with every conversion
+// are only re-encoded once. An ImageOptimizer must not be copied after first
+// use.
+type ImageOptimizer struct {
+	// MaxDimension is the longest side images are printed at, in points.
+	MaxDimension float64
+	// DPI is the resolution to keep at that size.
+	DPI float64
+	// JPEGQuality is the quality JPEG images are re-encoded with, 85 if zero.
+	JPEGQuality int
+
+	mu    sync.Mutex
+	cache map[optimizedImage][]byte
+}
+
+// maxOptimizedImages bounds the results an ImageOptimizer caches.
+const maxOptimizedImages = 256
+
+// optimizedImage identifies an image and the settings it was optimized with.
+type optimizedImage struct {
+	sum          [sha256.Size]byte
+	maxDimension float64
+	dpi          float64
+	jpegQuality  int
+}
+
+func (o *ImageOptimizer) Transform(m MediaFile) (MediaFile, error) {
+	if o.MaxDimension <= 0 || o.DPI <= 0 {
+		return MediaFile{}, fmt.Errorf("image optimizer needs a positive max dimension and DPI")
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(m.Data))
+	if err != nil || format != "png" && format != "jpeg" {
+		return m, nil
+	}
+	maxPixels := int(math.Ceil(o.MaxDimension / 72 * o.DPI))
+	if max(config.Width, config.Height) <= maxPixels {
+		return m, nil
+	}
+	// Re-encoding drops the Exif orientation, which would turn the image.
+	if format == "jpeg" && jpegOrientation(m.Data) > 1 {
+		return m, nil
+	}
+
+	key := optimizedImage{sha256.Sum256(m.Data), o.MaxDimension, o.DPI, o.JPEGQuality}
+	o.mu.Lock()
+	data, ok := o.cache[key]
+	o.mu.Unlock()
+	if !ok {
+		if data, err = o.optimize(m, config, format, maxPixels); err != nil {
+			return MediaFile{}, err
+		}
+		o.mu.Lock()
+		if o.cache == nil || len(o.cache) >= maxOptimizedImages {
+			o.cache = make(map[optimizedImage][]byte)
+		}
+		o.cache[key] = data
+		o.mu.Unlock()
+	}
+	// A nil result means the image would not get smaller.
+	if data == nil {
+		return m, nil
+	}
+	return MediaFile{Name: m.Name, Data: data}, nil
+}
+
+// optimize downscales and re-encodes an image, returning nil if that does not
+// make it smaller.
+func (o *ImageOptimizer) optimize(m MediaFile, config image.Config, format string, maxPixels int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %w", m.Name, err)
+	}
+	w, h := config.Width, config.Height
+	if w >= h {
+		w, h = maxPixels, max(1, int(math.Round(float64(h)*float64(maxPixels)/float64(w))))
+	} else {
+		w, h = max(1, int(math.Round(float64(w)*float64(maxPixels)/float64(h)))), maxPixels
+	}
+	var dst image.Image = downscale(src, w, h)
+	switch src.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		gray := image.NewGray(dst.Bounds())
+		draw.Draw(gray, gray.Bounds(), dst, image.Point{}, draw.Src)
+		dst = gray
+	}
+
+	var buf bytes.Buffer
+	if format == "png" {
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, dst)
+	} else {
+		quality := o.JPEGQuality
+		if quality == 0 {
+			quality = 85
+		}
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image %s: %w", m.Name, err)
+	}
+
+	var data []byte
+	if format == "png" {
+		data = pngWithDPI(buf.Bytes(), o.DPI)
+	} else {
+		data = jpegWithDPI(buf.Bytes(), o.DPI)
+	}
+	if len(data) >= len(m.Data) {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// downscale resizes src to w×h, averaging the source pixels each destination
+// pixel covers. Source rows are read one at a time, so besides the result it
+// only keeps a few rows in memory.
+func downscale(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	// Scale rows, then columns; premultiplied RGBA averages correctly.
+	xs, ys := boxWeights(sw, w), boxWeights(sh, h)
+	line := make([]uint8, sw*4)
+	scaled := make([]float32, w*4)
+	scaledY := -1
+	sum := make([]float32, w*4)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, ws := range ys {
+		clear(sum)
+		for _, c := range ws {
+			// Adjacent destination rows share their boundary source row.
+			if c.i != scaledY {
+				readRow(src, b.Min.Y+c.i, line)
+				clear(scaled)
+				for x, cs := range xs {
+					for _, cx := range cs {
+						for k := range 4 {
+							scaled[x*4+k] += float32(line[cx.i*4+k]) * cx.w
+						}
+					}
+				}
+				scaledY = c.i
+			}
+			for k, v := range scaled {
+				sum[k] += v * c.w
+			}
+		}
+		row := dst.Pix[y*dst.Stride:]
+		for k, v := range sum {
+			row[k] = uint8(min(255, math.Round(float64(v))))
+		}
+	}
+	return dst
+}
+
+// readRow reads row y of src into row as premultiplied 8-bit RGBA.
+func readRow(src image.Image, y int, row []uint8) {
+	b := src.Bounds()
+	switch img := src.(type) {
+	case *image.RGBA:
+		copy(row, img.Pix[img.PixOffset(b.Min.X, y):])
+	case *image.NRGBA:
+		pix := img.Pix[img.PixOffset(b.Min.X, y):]
+		for i := 0; i < len(row); i += 4 {
+			a := uint32(pix[i+3])
+			for k := range 3 {
+				row[i+k] = uint8((uint32(pix[i+k])*a + 127) / 255)
+			}
+			row[i+3] = pix[i+3]
+		}
+	case *image.YCbCr:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			i := (x - b.Min.X) * 4
+			row[i], row[i+1], row[i+2] = color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			row[i+3] = 255
+		}
+	case *image.Gray:
+		pix := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := range b.Dx() {
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = pix[x], pix[x], pix[x], 255
+		}
+	default:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := src.At(x, y).RGBA()
+			i := (x - b.Min.X) * 4
+			row[i], row[i+1], row[i+2], row[i+3] = uint8(r>>8), uint8(g>>8), uint8(bl>>8), uint8(a>>8)
+		}
+	}
+}
+
+type boxWeight struct {
+	i int
+	w float32
+}
+
+// boxWeights returns, for every destination index, the source indexes it
+// covers and their share.
+func boxWeights(srcLen, dstLen int) [][]boxWeight {
+	scale := float64(srcLen) / float64(dstLen)
+	weights := make([][]boxWeight, dstLen)
+	for d := range dstLen {
+		start, end := float64(d)*scale, float64(d+1)*scale
+		for i := int(start); i < srcLen && float64(i) < end; i++ {
+			overlap := math.Min(end, float64(i+1)) - math.Max(start, float64(i))
+			if overlap > 0 {
+				weights[d] = append(weights[d], boxWeight{i: i, w: float32(overlap / scale)})
+			}
+		}
+	}
+	return weights
+}
+
+// pngWithDPI inserts a pHYs chunk after the IHDR chunk of an encoded PNG.
+func pngWithDPI(data []byte, dpi float64) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	ppm := uint32(math.Round(dpi / 0.0254))
+
+	chunk := make([]byte, 0, 21)
+	chunk = binary.BigEndian.AppendUint32(chunk, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1) // unit is the meter
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return slices.Concat(data[:ihdrEnd], chunk, data[ihdrEnd:])
+}
+
+// jpegWithDPI inserts a JFIF APP0 segment recording dpi after the SOI marker
+// of an encoded JPEG.
+func jpegWithDPI(data []byte, dpi float64) []byte {
+	density := uint16(min(math.MaxUint16, math.Round(dpi)))
+	app0 := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1}
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	app0 = binary.BigEndian.AppendUint16(app0, density)
+	app0 = append(app0, 0, 0)
+	return slices.Concat(data[:2], app0, data[2:])
+}
+
+// jpegOrientation returns the Exif orientation of a JPEG, or 0 if it has none.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || i+2+size > len(data) {
+			return 0
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 0
+}
+
+// exifOrientation reads the orientation tag of IFD0 in TIFF data.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	// Compare before converting, the offset may not fit an int.
+	offset := order.Uint32(tiff[4:])
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return 0
+	}
+	ifd := int(offset)
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; e+12 <= len(tiff) && n > 0; e, n = e+12, n-1 {
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageOptimizer(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testTypstDir, "dashing-dept-news", "prometheus.png"))
+	if err != nil {
+		t.Fatalf("Failed to read image: %v", err)
+	}
+
+	// 2 inches at 300 DPI: 600 pixels.
+	optimizer := &ImageOptimizer{MaxDimension: 144, DPI: 300}
+	m, err := optimizer.Transform(MediaFile{Name: "prometheus.png", Data: data})
+	if err != nil {
+		t.Fatalf("Failed to optimize image: %v", err)
+	}
+	if len(m.Data) >= len(data) {
+		t.Errorf("Optimized image is %d bytes, original %d", len(m.Data), len(data))
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(m.Data))
+	if err != nil || format != "png" || config.Width != 600 || config.Height != 169 {
+		t.Errorf("Optimized image is %s %dx%d: %v", format, config.Width, config.Height, err)
+	}
+	if !bytes.Contains(m.Data, []byte("pHYs")) {
+		t.Error("Optimized image does not record its DPI")
+	}
+
+	if got, err := (&ImageOptimizer{MaxDimension: 1000, DPI: 300}).Transform(MediaFile{Name: "prometheus.png", Data: data}); err != nil || !bytes.Equal(got.Data, data) {
+		t.Errorf("Image within the limit changed: %v", err)
+	}
+	if got, err := optimizer.Transform(MediaFile{Name: "data.json", Data: []byte("{}")}); err != nil || string(got.Data) != "{}" {
+		t.Errorf("Non-image media changed: %q, %v", got.Data, err)
+	}
+	if _, err := (&ImageOptimizer{}).Transform(MediaFile{Name: "prometheus.png", Data: data}); err == nil {
+		t.Error("Expected error without max dimension and DPI")
+	}
+}
+
+func TestImageOptimizerJPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 800))
+	for y := range 800 {
+		for x := range 400 {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("Failed to encode image: %v", err)
+	}
+
+	m, err := (&ImageOptimizer{MaxDimension: 72, DPI: 100}).Transform(MediaFile{Name: "photo.jpg", Data: buf.Bytes()})
+	if err != nil {
+		t.Fatalf("Failed to optimize image: %v", err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(m.Data))
+	if err != nil || format != "jpeg" || config.Width != 50 || config.Height != 100 {
+		t.Errorf("Optimized image is %s %dx%d: %v", format, config.Width, config.Height, err)
+	}
+	if !bytes.HasPrefix(m.Data[2:], []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F'}) {
+		t.Error("Optimized image does not record its DPI")
+	}
+}
+
+func TestWithMediaTransformers(t *testing.T) {
+	var got map[string]string
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var req typstRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		got = req.Media
+		writePDFResponse(w, fakePDF)
+	})
+
+	data, err := os.ReadFile(filepath.Join(testTypstDir, "dashing-dept-news", "prometheus.png"))
+	if err != nil {
+		t.Fatalf("Failed to read image: %v", err)
+	}
+	client, err := New("test-key", server.URL, WithMediaTransformers(&ImageOptimizer{MaxDimension: 144, DPI: 150}))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	info, err := client.Convert(context.Background(), &buf, "", []byte(`#image("prometheus.png")`), nil, []MediaFile{{Name: "prometheus.png", Data: data}})
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	sent, _ := base64.StdEncoding.DecodeString(got["prometheus.png"])
+	if info.MediaBytesSaved <= 0 || info.MediaBytesSaved != len(data)-len(sent) {
+		t.Errorf("MediaBytesSaved = %d, sent %d of %d bytes", info.MediaBytesSaved, len(sent), len(data))
+	}
+}
+
+func TestImageOptimizerCache(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testTypstDir, "dashing-dept-news", "prometheus.png"))
+	if err != nil {
+		t.Fatalf("Failed to read image: %v", err)
+	}
+
+	optimizer := &ImageOptimizer{MaxDimension: 144, DPI: 300}
+	first, err := optimizer.Transform(MediaFile{Name: "a.png", Data: data})
+	if err != nil {
+		t.Fatalf("Failed to optimize image: %v", err)
+	}
+	second, err := optimizer.Transform(MediaFile{Name: "b.png", Data: data})
+	if err != nil {
+		t.Fatalf("Failed to optimize image: %v", err)
+	}
+	if second.Name != "b.png" || !bytes.Equal(first.Data, second.Data) {
+		t.Errorf("Cached result differs: %s, %d and %d bytes", second.Name, len(first.Data), len(second.Data))
+	}
+	if len(optimizer.cache) != 1 {
+		t.Errorf("Expected one cached image, got %d", len(optimizer.cache))
+	}
+
+	optimizer.DPI = 150
+	third, err := optimizer.Transform(MediaFile{Name: "a.png", Data: data})
+	if err != nil {
+		t.Fatalf("Failed to optimize image: %v", err)
+	}
+	if config, _, _ := image.DecodeConfig(bytes.NewReader(third.Data)); config.Width != 300 {
+		t.Errorf("Changed settings reused the cached result: width %d", config.Width)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	fill := func(img draw.Image) image.Image {
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{200, 100, 50, 128}), image.Point{}, draw.Src)
+		return img
+	}
+	rect := image.Rect(0, 0, 7, 5)
+	tests := []struct {
+		name string
+		src  image.Image
+	}{
+		{"rgba", fill(image.NewRGBA(rect))},
+		{"nrgba", fill(image.NewNRGBA(rect))},
+		{"sub-image", fill(image.NewNRGBA(image.Rect(0, 0, 20, 20))).(*image.NRGBA).SubImage(image.Rect(3, 4, 10, 9))},
+		{"rgba64", fill(image.NewRGBA64(rect))},
+	}
+	for _, tt := range tests {
+		want := color.RGBAModel.Convert(tt.src.At(tt.src.Bounds().Min.X, tt.src.Bounds().Min.Y)).(color.RGBA)
+		dst := downscale(tt.src, 3, 2)
+		for y := range 2 {
+			for x := range 3 {
+				if got := dst.RGBAAt(x, y); absDiff(got.R, want.R) > 1 || absDiff(got.A, want.A) > 1 {
+					t.Errorf("%s: pixel %d,%d = %v, want %v", tt.name, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	gray := image.NewGray(rect)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for i := range gray.Pix {
+		gray.Pix[i] = 90
+	}
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = 90
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = 128, 128
+	}
+	for _, src := range []image.Image{gray, ycbcr} {
+		if got := downscale(src, 3, 2).RGBAAt(1, 1); got != (color.RGBA{90, 90, 90, 255}) {
+			t.Errorf("%T: pixel = %v, want gray 90", src, got)
+		}
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestExifOrientation(t *testing.T) {
+	entry := []byte{0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0, 0, 0}
+	valid := append([]byte("II*\x00\x08\x00\x00\x00\x01\x00"), entry...)
+	if got := exifOrientation(valid); got != 6 {
+		t.Errorf("Expected orientation 6, got %d", got)
+	}
+	outOfRange := append([]byte("II*\x00\xf0\xff\xff\xff\x01\x00"), entry...)
+	if got := exifOrientation(outOfRange); got != 0 {
+		t.Errorf("Expected no orientation for an out of range IFD offset, got %d", got)
+	}
+}
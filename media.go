@@ -0,0 +1,151 @@
+package typstpdfgenerator
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LoadMedia loads a media file, named by its base name, or every file below a
+// media directory, named by its slash-separated path relative to the
+// directory. A directory laid out like the template's working directory can
+// thus be attached as is.
+func LoadMedia(path string) ([]MediaFile, error) {
+	return loadMedia(path, nil)
+}
+
+// loadMedia is LoadMedia leaving out the files below a directory for which
+// skip, if non-nil, returns true.
+func loadMedia(path string, skip func(path string) bool) ([]MediaFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media: %w", err)
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read media: %w", err)
+		}
+		return []MediaFile{{Name: filepath.Base(path), Data: data}}, nil
+	}
+
+	var media []MediaFile
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || skip != nil && skip(p) {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		media = append(media, MediaFile{Name: filepath.ToSlash(rel), Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media directory: %w", err)
+	}
+	return media, nil
+}
+
+// InvalidMedia is a media entry rejected by NormalizeMedia.
+type InvalidMedia struct {
+	// Index is the position of the entry in the media slice.
+	Index  int
+	Name   string
+	Reason string
+}
+
+// MediaError lists the media entries with invalid names.
+type MediaError struct {
+	Invalid []InvalidMedia
+}
+
+func (e *MediaError) Error() string {
+	parts := make([]string, len(e.Invalid))
+	for i, m := range e.Invalid {
+		parts[i] = fmt.Sprintf("%q: %s", m.Name, m.Reason)
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidMedia, strings.Join(parts, "; "))
+}
+
+func (e *MediaError) Unwrap() error {
+	return ErrInvalidMedia
+}
+
+// NormalizeMedia returns media with names cleaned to relative slash-separated
+// paths, as the gateway stores them. Empty names, absolute paths, backslashes
+// and paths escaping the media root are rejected, as are names that clean to
+// the same path as an earlier entry, since only one of them would reach the
+// gateway. With foldCase, names differing only in case are rejected too, as
+// they collide on case-insensitive file systems.
+func NormalizeMedia(media []MediaFile, foldCase bool) ([]MediaFile, error) {
+	normalized := make([]MediaFile, len(media))
+	seen := make(map[string]int, len(media))
+	var invalid []InvalidMedia
+	for i, m := range media {
+		name, reason := normalizeMediaName(m.Name)
+		if reason == "" {
+			key := name
+			if foldCase {
+				key = strings.ToLower(name)
+			}
+			if j, ok := seen[key]; !ok {
+				seen[key] = i
+			} else if normalized[j].Name == name {
+				reason = fmt.Sprintf("duplicate of %q", media[j].Name)
+			} else {
+				reason = fmt.Sprintf("differs only in case from %q", media[j].Name)
+			}
+		}
+		if reason != "" {
+			invalid = append(invalid, InvalidMedia{Index: i, Name: m.Name, Reason: reason})
+		}
+		normalized[i] = MediaFile{Name: name, Data: m.Data}
+	}
+
+	if len(invalid) > 0 {
+		return nil, &MediaError{Invalid: invalid}
+	}
+	return normalized, nil
+}
+
+// normalizeMediaName cleans a media name, or returns why it is invalid.
+func normalizeMediaName(name string) (string, string) {
+	switch {
+	case name == "":
+		return "", "empty name"
+	case strings.ContainsRune(name, '\\'):
+		return "", "contains a backslash"
+	case strings.HasPrefix(name, "/") || len(name) >= 2 && name[1] == ':' && isASCIILetter(name[0]):
+		return "", "absolute path"
+	}
+
+	cleaned := path.Clean(name)
+	switch {
+	case cleaned == ".":
+		return "", "empty name"
+	case cleaned == ".." || strings.HasPrefix(cleaned, "../"):
+		return "", "escapes the media root"
+	}
+	return cleaned, ""
+}
+
+func isASCIILetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// WithMediaCaseCheck makes Convert and Submit reject media names that differ
+// only in case, for gateways storing media on case-insensitive file systems.
+func WithMediaCaseCheck() Option {
+	return func(c *Client) error {
+		c.mediaFoldCase = true
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeMedia(t *testing.T) {
+	media := []MediaFile{
+		{Name: "./img//logo.png"},
+		{Name: "data/../data.json"},
+		{Name: "Fonts/Lato.ttf"},
+	}
+	normalized, err := NormalizeMedia(media, true)
+	if err != nil {
+		t.Fatalf("Failed to normalize media: %v", err)
+	}
+	if got := mediaNames(normalized); got[0] != "Fonts/Lato.ttf" || got[1] != "data.json" || got[2] != "img/logo.png" {
+		t.Errorf("Normalized names = %v", got)
+	}
+	if media[0].Name != "./img//logo.png" {
+		t.Error("NormalizeMedia modified its argument")
+	}
+
+	tests := []struct {
+		name     string
+		foldCase bool
+		reason   string
+	}{
+		{"../../etc/passwd", false, "escapes the media root"},
+		{"img/../../x.png", false, "escapes the media root"},
+		{"/etc/passwd", false, "absolute path"},
+		{"C:/Windows/x.png", false, "absolute path"},
+		{`img\logo.png`, false, "contains a backslash"},
+		{"", false, "empty name"},
+		{"./", false, "empty name"},
+		{"img/logo.png", false, `duplicate of "./img//logo.png"`},
+		{"IMG/Logo.png", false, ""},
+		{"IMG/Logo.png", true, `differs only in case from "./img//logo.png"`},
+	}
+	for _, tt := range tests {
+		_, err := NormalizeMedia([]MediaFile{media[0], {Name: tt.name}}, tt.foldCase)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("NormalizeMedia(%q) failed: %v", tt.name, err)
+			}
+			continue
+		}
+
+		var mediaErr *MediaError
+		if !errors.As(err, &mediaErr) || !errors.Is(err, ErrInvalidMedia) {
+			t.Errorf("NormalizeMedia(%q): expected MediaError, got %v", tt.name, err)
+			continue
+		}
+		if len(mediaErr.Invalid) != 1 || mediaErr.Invalid[0] != (InvalidMedia{Index: 1, Name: tt.name, Reason: tt.reason}) {
+			t.Errorf("NormalizeMedia(%q) invalid = %+v, want %q", tt.name, mediaErr.Invalid, tt.reason)
+		}
+	}
+}
+
+func TestConvertRejectsInvalidMedia(t *testing.T) {
+	requests := 0
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writePDFResponse(w, fakePDF)
+	})
+	client, err := New("test-key", server.URL, WithMediaCaseCheck())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	media := []MediaFile{{Name: "logo.png", Data: []byte("a")}, {Name: "../logo.png"}, {Name: "Logo.PNG"}}
+	_, err = client.Convert(context.Background(), &buf, "", []byte("= Title"), nil, media)
+	var mediaErr *MediaError
+	if !errors.As(err, &mediaErr) || len(mediaErr.Invalid) != 2 {
+		t.Fatalf("Expected MediaError with 2 entries, got %v", err)
+	}
+	if _, err := client.Submit(context.Background(), &Request{Template: []byte("= Title"), Media: media}); !errors.Is(err, ErrInvalidMedia) {
+		t.Errorf("Expected ErrInvalidMedia from Submit, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Invalid media sent to the gateway")
+	}
+}
@@ -0,0 +1,189 @@
+// Package metrics provides a typstpdfgenerator.Observer that aggregates
+// conversion latency, payload sizes and outcomes, and exposes them through
+// expvar or the Prometheus text exposition format.
+//
+// It has no dependencies outside the standard library.
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// Default histogram buckets.
+var (
+	LatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+	SizeBuckets    = []float64{1 << 10, 16 << 10, 128 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+)
+
+// Histogram is a cumulative histogram with fixed upper bounds.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"` // cumulative, len(Bounds)+1 with +Inf last
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(v float64) {
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Counts[len(h.Bounds)]++
+	h.Sum += v
+	h.Count++
+}
+
+func (h *Histogram) clone() *Histogram {
+	return &Histogram{Bounds: h.Bounds, Counts: slices.Clone(h.Counts), Sum: h.Sum, Count: h.Count}
+}
+
+// Snapshot is a point-in-time copy of a Collector's metrics.
+type Snapshot struct {
+	InFlight      int64             `json:"in_flight"`
+	Conversions   map[string]uint64 `json:"conversions"` // by outcome: "ok" or error class
+	Latency       *Histogram        `json:"latency_seconds"`
+	RequestBytes  *Histogram        `json:"request_bytes"`
+	ResponseBytes *Histogram        `json:"response_bytes"`
+}
+
+// Collector is a typstpdfgenerator.Observer aggregating conversion metrics.
+type Collector struct {
+	mu            sync.Mutex
+	inFlight      int64
+	conversions   map[string]uint64
+	latency       *Histogram
+	requestBytes  *Histogram
+	responseBytes *Histogram
+}
+
+var _ typstpdfgenerator.Observer = (*Collector)(nil)
+
+func NewCollector() *Collector {
+	return &Collector{
+		conversions:   make(map[string]uint64),
+		latency:       newHistogram(LatencyBuckets),
+		requestBytes:  newHistogram(SizeBuckets),
+		responseBytes: newHistogram(SizeBuckets),
+	}
+}
+
+func (c *Collector) ConversionStarted(context.Context, typstpdfgenerator.StartEvent) {
+	c.mu.Lock()
+	c.inFlight++
+	c.mu.Unlock()
+}
+
+func (c *Collector) ConversionFinished(_ context.Context, ev typstpdfgenerator.FinishEvent) {
+	outcome := ev.ErrorClass
+	if outcome == "" {
+		outcome = "ok"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	c.conversions[outcome]++
+	c.latency.observe(ev.Latency.Seconds())
+	if ev.RequestBytes > 0 {
+		c.requestBytes.observe(float64(ev.RequestBytes))
+	}
+	if ev.ResponseBytes > 0 {
+		c.responseBytes.observe(float64(ev.ResponseBytes))
+	}
+}
+
+func (c *Collector) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conversions := make(map[string]uint64, len(c.conversions))
+	for k, v := range c.conversions {
+		conversions[k] = v
+	}
+	return Snapshot{
+		InFlight:      c.inFlight,
+		Conversions:   conversions,
+		Latency:       c.latency.clone(),
+		RequestBytes:  c.requestBytes.clone(),
+		ResponseBytes: c.responseBytes.clone(),
+	}
+}
+
+// Publish exposes the collector's snapshot as an expvar variable, served as
+// JSON on /debug/vars. Like expvar.Publish, it panics if name is taken.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return c.Snapshot() }))
+}
+
+// Handler serves the metrics in the Prometheus text exposition format.
+func (c *Collector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = c.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	s := c.Snapshot()
+
+	ew := &errWriter{w: w}
+	ew.printf("# HELP typst_conversions_in_flight Conversions currently in progress.\n")
+	ew.printf("# TYPE typst_conversions_in_flight gauge\n")
+	ew.printf("typst_conversions_in_flight %d\n", s.InFlight)
+
+	ew.printf("# HELP typst_conversions_total Finished conversions by outcome.\n")
+	ew.printf("# TYPE typst_conversions_total counter\n")
+	outcomes := make([]string, 0, len(s.Conversions))
+	for k := range s.Conversions {
+		outcomes = append(outcomes, k)
+	}
+	sort.Strings(outcomes)
+	for _, outcome := range outcomes {
+		ew.printf("typst_conversions_total{outcome=%q} %d\n", outcome, s.Conversions[outcome])
+	}
+
+	writeHistogram(ew, "typst_conversion_duration_seconds", "Conversion latency.", s.Latency)
+	writeHistogram(ew, "typst_request_bytes", "Size of request bodies sent to the gateway.", s.RequestBytes)
+	writeHistogram(ew, "typst_response_bytes", "Size of response bodies received from the gateway.", s.ResponseBytes)
+
+	return ew.err
+}
+
+func writeHistogram(ew *errWriter, name, help string, h *Histogram) {
+	ew.printf("# HELP %s %s\n", name, help)
+	ew.printf("# TYPE %s histogram\n", name)
+	for i, b := range h.Bounds {
+		ew.printf("%s_bucket{le=\"%g\"} %d\n", name, b, h.Counts[i])
+	}
+	ew.printf("%s_bucket{le=\"+Inf\"} %d\n", name, h.Counts[len(h.Bounds)])
+	ew.printf("%s_sum %g\n", name, h.Sum)
+	ew.printf("%s_count %d\n", name, h.Count)
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...any) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
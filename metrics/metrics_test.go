@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	ctx := context.Background()
+
+	events := []typstpdfgenerator.FinishEvent{
+		{Latency: 200 * time.Millisecond, RequestBytes: 2048, ResponseBytes: 50 << 10, StatusCode: 200},
+		{Latency: 3 * time.Second, RequestBytes: 2048, StatusCode: 502, ErrorClass: "http_502"},
+		{Latency: 40 * time.Millisecond, ErrorClass: "connection"},
+	}
+	for _, ev := range events {
+		c.ConversionStarted(ctx, typstpdfgenerator.StartEvent{})
+		c.ConversionFinished(ctx, ev)
+	}
+	c.ConversionStarted(ctx, typstpdfgenerator.StartEvent{})
+
+	s := c.Snapshot()
+	if s.InFlight != 1 {
+		t.Errorf("InFlight = %d, want 1", s.InFlight)
+	}
+	if s.Conversions["ok"] != 1 || s.Conversions["http_502"] != 1 || s.Conversions["connection"] != 1 {
+		t.Errorf("Unexpected conversions: %v", s.Conversions)
+	}
+	if s.Latency.Count != 3 || s.RequestBytes.Count != 2 || s.ResponseBytes.Count != 1 {
+		t.Errorf("Unexpected histogram counts: %d %d %d", s.Latency.Count, s.RequestBytes.Count, s.ResponseBytes.Count)
+	}
+
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		"typst_conversions_in_flight 1",
+		`typst_conversions_total{outcome="http_502"} 1`,
+		`typst_conversion_duration_seconds_bucket{le="0.25"} 2`,
+		`typst_conversion_duration_seconds_bucket{le="+Inf"} 3`,
+		"typst_request_bytes_count 2",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Prometheus output missing %q:\n%s", want, body)
+		}
+	}
+}
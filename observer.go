@@ -0,0 +1,85 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Observer receives instrumentation events for every Convert call. Methods are
+// called synchronously and must be safe for concurrent use.
+type Observer interface {
+	ConversionStarted(ctx context.Context, ev StartEvent)
+	ConversionFinished(ctx context.Context, ev FinishEvent)
+}
+
+type StartEvent struct {
+	CorrelationID string
+	TemplateBytes int
+	// MediaCount is the number of media files passed to Convert, before
+	// discovered media, fonts and packages are attached.
+	MediaCount int
+}
+
+type FinishEvent struct {
+	CorrelationID string
+	Latency       time.Duration
+	RequestBytes  int
+	ResponseBytes int
+	// StatusCode is zero when no response was received.
+	StatusCode int
+	// ErrorClass is empty on success, see ErrorClass.
+	ErrorClass string
+	Err        error
+}
+
+// WithObserver registers an Observer. It may be given several times.
+func WithObserver(o Observer) Option {
+	return func(c *Client) error {
+		if o == nil {
+			return nil
+		}
+		c.observers = append(c.observers, o)
+		return nil
+	}
+}
+
+// ErrorClass maps an error returned by Convert to a low-cardinality label:
+// "" for nil, "http_<status>" for *HTTPError, "not_generated", "unsupported",
+// "too_large", "unresolved_media", "invalid_media", "font_not_found",
+// "package_mismatch", "canceled", "timeout", "connection" or "other".
+func ErrorClass(err error) string {
+	var httpErr *HTTPError
+	var resolveErr *ResolveError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &httpErr):
+		return "http_" + strconv.Itoa(httpErr.StatusCode)
+	case errors.Is(err, ErrNotGenerated):
+		return "not_generated"
+	case errors.Is(err, ErrUnsupported):
+		return "unsupported"
+	case errors.Is(err, ErrRequestTooLarge):
+		return "too_large"
+	case errors.As(err, &resolveErr):
+		return "unresolved_media"
+	case errors.Is(err, ErrInvalidMedia):
+		return "invalid_media"
+	case errors.Is(err, ErrFontNotFound):
+		return "font_not_found"
+	case errors.Is(err, ErrPackageMismatch):
+		return "package_mismatch"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) || errors.Is(err, ErrConnection) {
+		return "connection"
+	}
+	return "other"
+}
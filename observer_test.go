@@ -0,0 +1,117 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	started  []StartEvent
+	finished []FinishEvent
+}
+
+func (o *recordingObserver) ConversionStarted(_ context.Context, ev StartEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started = append(o.started, ev)
+}
+
+func (o *recordingObserver) ConversionFinished(_ context.Context, ev FinishEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished = append(o.finished, ev)
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: ""},
+		{err: &HTTPError{StatusCode: 502}, want: "http_502"},
+		{err: &NotGeneratedError{Message: "bad"}, want: "not_generated"},
+		{err: &UnsupportedError{Feature: "output format"}, want: "unsupported"},
+		{err: fmt.Errorf("%w: request is 10 bytes", ErrRequestTooLarge), want: "too_large"},
+		{err: &ResolveError{}, want: "unresolved_media"},
+		{err: &MediaError{}, want: "invalid_media"},
+		{err: &FontError{Families: []string{"Inter"}}, want: "font_not_found"},
+		{err: fmt.Errorf("%w: @preview/cetz:0.3.0 is not locked", ErrPackageMismatch), want: "package_mismatch"},
+		{err: &ConnectionError{Err: context.DeadlineExceeded}, want: "timeout"},
+		{err: &ConnectionError{Err: context.Canceled}, want: "canceled"},
+		{err: &ConnectionError{Err: errors.New("dial tcp: refused")}, want: "connection"},
+		{err: fmt.Errorf("failed to write PDF data: %w", errors.New("disk full")), want: "other"},
+	}
+
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestObserverEvents(t *testing.T) {
+	fail := false
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		writePDFResponse(w, fakePDF)
+	})
+
+	observer := &recordingObserver{}
+	client, err := New("test-key", server.URL, WithObserver(observer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	fail = true
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if len(observer.started) != 2 || len(observer.finished) != 2 {
+		t.Fatalf("Expected 2 start and finish events, got %d and %d", len(observer.started), len(observer.finished))
+	}
+
+	ok, failed := observer.finished[0], observer.finished[1]
+	if ok.ErrorClass != "" || ok.StatusCode != http.StatusOK || ok.RequestBytes == 0 || ok.ResponseBytes == 0 {
+		t.Errorf("Unexpected success event: %+v", ok)
+	}
+	if failed.ErrorClass != "http_503" || failed.Err == nil {
+		t.Errorf("Unexpected failure event: %+v", failed)
+	}
+}
+
+func TestObserverEventsBeforeSending(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Request sent despite invalid media")
+	})
+
+	observer := &recordingObserver{}
+	client, err := New("test-key", server.URL, WithObserver(observer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, []MediaFile{{Name: "../x.png"}}); err == nil {
+		t.Fatal("Expected error")
+	}
+	if len(observer.started) != 1 || len(observer.finished) != 1 {
+		t.Fatalf("Expected 1 start and finish event, got %d and %d", len(observer.started), len(observer.finished))
+	}
+	if ev := observer.finished[0]; ev.ErrorClass != "invalid_media" || ev.StatusCode != 0 || ev.CorrelationID == "" {
+		t.Errorf("Unexpected failure event: %+v", ev)
+	}
+}
@@ -0,0 +1,296 @@
+package typstpdfgenerator
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PackageDir is the directory vendored packages are attached under, passed
+// to the gateway with --package-path.
+const PackageDir = "packages"
+
+// ErrPackageMismatch is returned when a vendored package does not match its
+// lockfile entry.
+var ErrPackageMismatch = errors.New("package does not match lockfile")
+
+// PackageSpec identifies a typst package, as in @preview/cetz:0.3.0.
+type PackageSpec struct {
+	Namespace string
+	Name      string
+	Version   string
+}
+
+var packageSpec = regexp.MustCompile(`^@([a-zA-Z0-9_-]+)/([a-zA-Z0-9_-]+):([0-9]+\.[0-9]+\.[0-9]+)$`)
+
+// ParsePackageSpec parses a package import path.
+func ParsePackageSpec(s string) (PackageSpec, error) {
+	m := packageSpec.FindStringSubmatch(s)
+	if m == nil {
+		return PackageSpec{}, fmt.Errorf("invalid package spec %q", s)
+	}
+	return PackageSpec{Namespace: m[1], Name: m[2], Version: m[3]}, nil
+}
+
+func (p PackageSpec) String() string {
+	return fmt.Sprintf("@%s/%s:%s", p.Namespace, p.Name, p.Version)
+}
+
+// Dir returns the directory of the package in a package path or cache.
+func (p PackageSpec) Dir() string {
+	return path.Join(p.Namespace, p.Name, p.Version)
+}
+
+// LockedPackage is a lockfile entry.
+type LockedPackage struct {
+	Package string `json:"package"`
+	// Hash is the h1: hash of the package files, as in go.sum.
+	Hash         string   `json:"hash"`
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// Lockfile records the vendored packages of a template and their hashes.
+type Lockfile struct {
+	Packages []LockedPackage `json:"packages"`
+}
+
+// ReadLockfile reads a lockfile written as JSON.
+func ReadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+	return &lock, nil
+}
+
+// Verify checks that every package in media under PackageDir is locked and
+// hashes as recorded.
+func (l *Lockfile) Verify(media []MediaFile) error {
+	for spec, files := range packageFiles(media) {
+		i := slices.IndexFunc(l.Packages, func(p LockedPackage) bool { return p.Package == spec.String() })
+		if i < 0 {
+			return fmt.Errorf("%w: %s is not locked", ErrPackageMismatch, spec)
+		}
+		if hash := hashPackage(files); hash != l.Packages[i].Hash {
+			return fmt.Errorf("%w: %s has hash %s, want %s", ErrPackageMismatch, spec, hash, l.Packages[i].Hash)
+		}
+	}
+	return nil
+}
+
+// VendoredPackages are the packages a template imports, ready to be sent.
+type VendoredPackages struct {
+	// Media holds the package files under PackageDir.
+	Media []MediaFile
+	Lock  Lockfile
+}
+
+// DefaultPackageCacheDir returns the directory typst downloads packages to.
+func DefaultPackageCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "typst", "packages"), nil
+}
+
+// VendorPackages finds the packages imported by template and the local
+// modules in media, and loads them and the packages they import in turn from
+// cache, a package cache or package path laid out as namespace/name/version.
+//
+// Packages already in media under PackageDir are not loaded again, but are
+// scanned and locked.
+func VendorPackages(cache fs.FS, template []byte, media []MediaFile) (*VendoredPackages, error) {
+	given := packageFiles(media)
+
+	var queue []PackageSpec
+	seen := make(map[PackageSpec]bool)
+	enqueue := func(file string, src []byte) ([]string, error) {
+		masked, _ := scanTypst(file, src)
+		var deps []string
+		for _, ref := range packageReferences(file, masked) {
+			spec, err := ParsePackageSpec(ref.Literal)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d:%d: %w", ref.File, ref.Line, ref.Column, err)
+			}
+			if !slices.Contains(deps, spec.String()) {
+				deps = append(deps, spec.String())
+			}
+			if !seen[spec] {
+				seen[spec] = true
+				queue = append(queue, spec)
+			}
+		}
+		return deps, nil
+	}
+
+	if _, err := enqueue(templateName, template); err != nil {
+		return nil, err
+	}
+	for _, m := range media {
+		name := cleanMediaName(m.Name)
+		if path.Ext(name) == ".typ" && !inDir(name, PackageDir) {
+			if _, err := enqueue(name, m.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	vendored := &VendoredPackages{}
+	for len(queue) > 0 {
+		spec := queue[0]
+		queue = queue[1:]
+
+		files, ok := given[spec]
+		if !ok {
+			var err error
+			if files, err = loadPackage(cache, spec); err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				vendored.Media = append(vendored.Media, MediaFile{Name: path.Join(PackageDir, spec.Dir(), f.Name), Data: f.Data})
+			}
+		}
+
+		locked := LockedPackage{Package: spec.String(), Hash: hashPackage(files)}
+		for _, f := range files {
+			if path.Ext(f.Name) != ".typ" {
+				continue
+			}
+			deps, err := enqueue(path.Join(PackageDir, spec.Dir(), f.Name), f.Data)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range deps {
+				if !slices.Contains(locked.Dependencies, d) {
+					locked.Dependencies = append(locked.Dependencies, d)
+				}
+			}
+		}
+		slices.Sort(locked.Dependencies)
+		vendored.Lock.Packages = append(vendored.Lock.Packages, locked)
+	}
+
+	slices.SortFunc(vendored.Lock.Packages, func(a, b LockedPackage) int { return strings.Compare(a.Package, b.Package) })
+	return vendored, nil
+}
+
+// VendorPackagesDir is like VendorPackages with the packages below dir.
+func VendorPackagesDir(dir string, template []byte, media []MediaFile) (*VendoredPackages, error) {
+	return VendorPackages(os.DirFS(dir), template, media)
+}
+
+// WithPackages makes Convert vendor the packages a template imports from
+// cache, attach them under PackageDir and pass --package-path to the gateway,
+// so that it does not download them. If lock is not nil, conversions fail
+// with ErrPackageMismatch when a package differs from the lockfile.
+func WithPackages(cache fs.FS, lock *Lockfile) Option {
+	return func(c *Client) error {
+		c.packageFS = cache
+		c.packageLock = lock
+		return nil
+	}
+}
+
+func (c *Client) vendorPackages(templateData []byte, options []string, media []MediaFile) ([]string, []MediaFile, error) {
+	if c.packageFS == nil {
+		return options, media, nil
+	}
+	vendored, err := VendorPackages(c.packageFS, templateData, media)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(vendored.Lock.Packages) == 0 {
+		return options, media, nil
+	}
+	if c.packageLock != nil {
+		if err := c.packageLock.Verify(append(slices.Clip(media), vendored.Media...)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !slices.ContainsFunc(options, func(o string) bool { return o == "--package-path" || strings.HasPrefix(o, "--package-path=") }) {
+		options = append(slices.Clip(options), "--package-path="+PackageDir)
+	}
+	return options, append(slices.Clip(media), vendored.Media...), nil
+}
+
+// loadPackage reads the files of a package, named relative to its directory.
+func loadPackage(cache fs.FS, spec PackageSpec) ([]MediaFile, error) {
+	if cache == nil {
+		return nil, fmt.Errorf("package %s: %w", spec, fs.ErrNotExist)
+	}
+	var files []MediaFile
+	err := fs.WalkDir(cache, spec.Dir(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(cache, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, MediaFile{Name: strings.TrimPrefix(p, spec.Dir()+"/"), Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load package %s: %w", spec, err)
+	}
+	return files, nil
+}
+
+// packageFiles groups the media under PackageDir by package, named relative
+// to the package directory.
+func packageFiles(media []MediaFile) map[PackageSpec][]MediaFile {
+	packages := make(map[PackageSpec][]MediaFile)
+	for _, m := range media {
+		name := cleanMediaName(m.Name)
+		parts := strings.SplitN(name, "/", 5)
+		if len(parts) < 5 || parts[0] != PackageDir {
+			continue
+		}
+		spec, err := ParsePackageSpec("@" + parts[1] + "/" + parts[2] + ":" + parts[3])
+		if err != nil {
+			continue
+		}
+		packages[spec] = append(packages[spec], MediaFile{Name: parts[4], Data: m.Data})
+	}
+	return packages
+}
+
+// hashPackage returns the dirhash h1: hash of the files of a package.
+func hashPackage(files []MediaFile) string {
+	files = slices.SortedFunc(slices.Values(files), func(a, b MediaFile) int { return strings.Compare(a.Name, b.Name) })
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(f.Data), f.Name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// packageReferences finds the package imports and includes in a masked
+// source.
+func packageReferences(file string, masked []byte) []reference {
+	var refs []reference
+	for _, m := range moduleLoad.FindAllSubmatchIndex(masked, -1) {
+		literal := unquote(string(masked[m[4]:m[5]]))
+		if !strings.HasPrefix(literal, "@") {
+			continue
+		}
+		line, col := position(masked, m[4])
+		refs = append(refs, reference{File: file, Line: line, Column: col, Func: string(masked[m[2]:m[3]]), Literal: literal})
+	}
+	return refs
+}
@@ -0,0 +1,130 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func testPackageCache() fstest.MapFS {
+	return fstest.MapFS{
+		"preview/report/0.2.0/typst.toml":   {Data: []byte("[package]\nname = \"report\"\nversion = \"0.2.0\"\nentrypoint = \"lib.typ\"")},
+		"preview/report/0.2.0/lib.typ":      {Data: []byte(`#import "@preview/cetz:0.3.0": canvas` + "\n" + `#import "util.typ": *`)},
+		"preview/report/0.2.0/util.typ":     {Data: []byte(`// #import "@preview/commented:1.0.0"`)},
+		"preview/cetz/0.3.0/typst.toml":     {Data: []byte("[package]\nname = \"cetz\"\nversion = \"0.3.0\"\nentrypoint = \"src/lib.typ\"")},
+		"preview/cetz/0.3.0/src/lib.typ":    {Data: []byte(`#let canvas(body) = body`)},
+		"preview/unused/1.0.0/typst.toml":   {Data: []byte("[package]")},
+		"preview/report/0.1.0/typst.toml":   {Data: []byte("[package]")},
+		"preview/report/0.1.0/lib.typ":      {Data: []byte("")},
+		"preview/report/0.2.0/assets/x.svg": {Data: []byte("<svg/>")},
+	}
+}
+
+func TestParsePackageSpec(t *testing.T) {
+	spec, err := ParsePackageSpec("@preview/articulate-coderscompass:0.1.7")
+	if err != nil {
+		t.Fatalf("Failed to parse package spec: %v", err)
+	}
+	if spec != (PackageSpec{Namespace: "preview", Name: "articulate-coderscompass", Version: "0.1.7"}) || spec.Dir() != "preview/articulate-coderscompass/0.1.7" {
+		t.Errorf("Unexpected package spec %+v", spec)
+	}
+
+	for _, s := range []string{"@preview/cetz", "preview/cetz:0.3.0", "@preview/../x:1.0.0", "@preview/cetz:0.3"} {
+		if _, err := ParsePackageSpec(s); err == nil {
+			t.Errorf("ParsePackageSpec(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestVendorPackages(t *testing.T) {
+	template := []byte(`#import "@preview/report:0.2.0": report
+#import "lib/local.typ": *`)
+	media := []MediaFile{{Name: "lib/local.typ", Data: []byte(`#import "@preview/cetz:0.3.0"`)}}
+
+	vendored, err := VendorPackages(testPackageCache(), template, media)
+	if err != nil {
+		t.Fatalf("Failed to vendor packages: %v", err)
+	}
+	want := []string{
+		"packages/preview/cetz/0.3.0/src/lib.typ",
+		"packages/preview/cetz/0.3.0/typst.toml",
+		"packages/preview/report/0.2.0/assets/x.svg",
+		"packages/preview/report/0.2.0/lib.typ",
+		"packages/preview/report/0.2.0/typst.toml",
+		"packages/preview/report/0.2.0/util.typ",
+	}
+	if got := mediaNames(vendored.Media); !slices.Equal(got, want) {
+		t.Errorf("Vendored %v, want %v", got, want)
+	}
+
+	packages := vendored.Lock.Packages
+	if len(packages) != 2 || packages[0].Package != "@preview/cetz:0.3.0" || packages[1].Package != "@preview/report:0.2.0" {
+		t.Fatalf("Unexpected lockfile %+v", vendored.Lock)
+	}
+	if !slices.Equal(packages[1].Dependencies, []string{"@preview/cetz:0.3.0"}) {
+		t.Errorf("Unexpected dependencies %v", packages[1].Dependencies)
+	}
+	if err := vendored.Lock.Verify(vendored.Media); err != nil {
+		t.Errorf("Failed to verify vendored packages: %v", err)
+	}
+
+	tampered := slices.Clone(vendored.Media)
+	tampered[0] = MediaFile{Name: tampered[0].Name, Data: []byte("changed")}
+	if err := vendored.Lock.Verify(tampered); !errors.Is(err, ErrPackageMismatch) {
+		t.Errorf("Expected ErrPackageMismatch, got %v", err)
+	}
+
+	again, err := VendorPackages(testPackageCache(), template, append(media, vendored.Media...))
+	if err != nil {
+		t.Fatalf("Failed to vendor packages: %v", err)
+	}
+	if len(again.Media) != 0 || !slices.EqualFunc(again.Lock.Packages, packages, func(a, b LockedPackage) bool { return a.Hash == b.Hash }) {
+		t.Errorf("Given packages not reused: %v", mediaNames(again.Media))
+	}
+
+	if _, err := VendorPackages(testPackageCache(), []byte(`#import "@preview/missing:1.0.0"`), nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestWithPackages(t *testing.T) {
+	var got typstRequest
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		writePDFResponse(w, fakePDF)
+	})
+
+	template := []byte(`#import "@preview/cetz:0.3.0": canvas`)
+	vendored, err := VendorPackages(testPackageCache(), template, nil)
+	if err != nil {
+		t.Fatalf("Failed to vendor packages: %v", err)
+	}
+	client, err := New("test-key", server.URL, WithPackages(testPackageCache(), &vendored.Lock))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", template, nil, nil); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if _, ok := got.Media["packages/preview/cetz/0.3.0/src/lib.typ"]; !ok {
+		t.Errorf("Package not attached")
+	}
+	if !slices.Contains(got.Options, "--package-path=packages") || !slices.Contains(got.Options, "--ignore-system-fonts") {
+		t.Errorf("Unexpected options %v", got.Options)
+	}
+
+	_, err = client.Convert(context.Background(), &buf, "", []byte(`#import "@preview/report:0.2.0"`), nil, nil)
+	if !errors.Is(err, ErrPackageMismatch) {
+		t.Errorf("Expected ErrPackageMismatch for an unlocked package, got %v", err)
+	}
+}
@@ -0,0 +1,541 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// templateName is the file name diagnostics use for the template itself.
+const templateName = "main.typ"
+
+// Preflight checks req locally, without a compiler, for mistakes that would
+// otherwise only be reported after a round trip to the gateway:
+//
+//   - unbalanced brackets, braces and parentheses, and unclosed strings, raw
+//     text and comments
+//   - files read with image, read, json, csv, yaml, toml or xml, or loaded
+//     with #import or #include, that are not in req.Media
+//   - when --ignore-system-fonts is in effect, fonts named in font arguments
+//     that are neither shipped in the font path nor embedded in typst
+//
+// Local modules in req.Media are checked as well. Errors would fail the
+// conversion; warnings, like missing fonts, are only reported by typst. The
+// diagnostics are nil if nothing was found.
+func (c *Client) Preflight(req *Request) []Diagnostic {
+	media := make(map[string]bool, len(req.Media))
+	sources := make(map[string][]byte)
+	for _, m := range req.Media {
+		name := cleanMediaName(m.Name)
+		media[name] = true
+		if strings.HasSuffix(name, ".typ") {
+			sources[name] = m.Data
+		}
+	}
+
+	var diags []Diagnostic
+	var families []reference
+	checked := map[string]bool{templateName: true}
+	queue := []string{templateName}
+	sources[templateName] = req.Template
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+
+		masked, scanDiags := scanTypst(file, sources[file])
+		diags = append(diags, scanDiags...)
+		families = append(families, fontReferences(file, masked)...)
+
+		for _, ref := range fileReferences(file, masked) {
+			switch {
+			case ref.Path == "":
+				d := ref.diagnostic("error", fmt.Sprintf("path %q is outside the project root", ref.Literal))
+				diags = append(diags, d)
+			case !media[ref.Path]:
+				d := ref.diagnostic("error", fmt.Sprintf("file not found: %s", ref.Path))
+				d.Hints = []string{"add it to the request media"}
+				diags = append(diags, d)
+			case (ref.Func == "import" || ref.Func == "include") && !checked[ref.Path]:
+				checked[ref.Path] = true
+				queue = append(queue, ref.Path)
+			}
+		}
+	}
+
+	return append(diags, c.checkFonts(c.resolveOptions(req.Options), req.Media, families)...)
+}
+
+// cleanMediaName returns the path a media file is found at by the compiler.
+func cleanMediaName(name string) string {
+	return path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))[1:]
+}
+
+// Fonts embedded in the typst compiler, available unless
+// --ignore-embedded-fonts is set.
+var embeddedFonts = []string{"libertinus serif", "new computer modern", "new computer modern math", "dejavu sans mono"}
+
+func (c *Client) checkFonts(options []string, media []MediaFile, refs []reference) []Diagnostic {
+	if !slices.Contains(options, "--ignore-system-fonts") || len(refs) == 0 {
+		return nil
+	}
+
+	available, diags := shippedFonts(options, media)
+	if !slices.Contains(options, "--ignore-embedded-fonts") {
+		for _, f := range embeddedFonts {
+			available[f] = true
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, ref := range refs {
+		family := strings.ToLower(ref.Literal)
+		if available[family] || reported[family] {
+			continue
+		}
+		reported[family] = true
+		d := ref.diagnostic("warning", "unknown font family: "+family)
+		d.Hints = []string{"ship the font in the font path, system fonts are ignored"}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// shippedFonts returns the lowercase families of the fonts in media that are
+// in a font path of options, with warnings for fonts that cannot be read.
+func shippedFonts(options []string, media []MediaFile) (map[string]bool, []Diagnostic) {
+	var fontPaths []string
+	for i, opt := range options {
+		switch {
+		case strings.HasPrefix(opt, "--font-path="):
+			fontPaths = append(fontPaths, cleanMediaName(strings.TrimPrefix(opt, "--font-path=")))
+		case opt == "--font-path" && i+1 < len(options):
+			fontPaths = append(fontPaths, cleanMediaName(options[i+1]))
+		}
+	}
+
+	var diags []Diagnostic
+	available := make(map[string]bool)
+	for _, m := range media {
+		name := cleanMediaName(m.Name)
+		if !isFontFile(name) || !slices.ContainsFunc(fontPaths, func(dir string) bool { return inDir(name, dir) }) {
+			continue
+		}
+		faces, err := readFontNames(m.Data)
+		if err != nil {
+			diags = append(diags, Diagnostic{File: name, Severity: "warning", Message: "failed to read font: " + err.Error()})
+			continue
+		}
+		for _, face := range faces {
+			available[strings.ToLower(face.Family)] = true
+		}
+	}
+	return available, diags
+}
+
+func isFontFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".ttf", ".otf", ".ttc", ".otc":
+		return true
+	}
+	return false
+}
+
+func inDir(name, dir string) bool {
+	return dir == "" || dir == "." || strings.HasPrefix(name, dir+"/")
+}
+
+// reference is a string literal in a Typst source.
+type reference struct {
+	File    string
+	Line    int
+	Column  int
+	Func    string
+	Literal string
+	// Path is the literal resolved against the project root, or empty if it
+	// points outside of it.
+	Path string
+}
+
+func (r reference) diagnostic(severity, message string) Diagnostic {
+	return Diagnostic{File: r.File, Line: r.Line, Column: r.Column, Severity: severity, Message: message}
+}
+
+var (
+	fileCall    = regexp.MustCompile(`(?:^|[^\w.-])(image|read|json|csv|yaml|toml|xml|cbor)\(\s*("(?:[^"\\]|\\.)*")`)
+	moduleLoad  = regexp.MustCompile(`(?:^|[^\w.-])(import|include)\s+("(?:[^"\\]|\\.)*")`)
+	fontArg     = regexp.MustCompile(`(?:^|[^\w-])font\s*:\s*`)
+	stringToken = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+)
+
+// fileReferences finds the files loaded by literal paths in a masked source.
+// Package imports are skipped.
+func fileReferences(file string, masked []byte) []reference {
+	var refs []reference
+	for _, re := range []*regexp.Regexp{fileCall, moduleLoad} {
+		for _, m := range re.FindAllSubmatchIndex(masked, -1) {
+			literal := unquote(string(masked[m[4]:m[5]]))
+			if strings.HasPrefix(literal, "@") {
+				continue
+			}
+			line, col := position(masked, m[4])
+			refs = append(refs, reference{
+				File: file, Line: line, Column: col,
+				Func:    string(masked[m[2]:m[3]]),
+				Literal: literal,
+				Path:    resolvePath(file, literal),
+			})
+		}
+	}
+	slices.SortFunc(refs, func(a, b reference) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return refs
+}
+
+// resolvePath resolves a path as typst does: relative to the file, or to the
+// project root if it starts with "/".
+func resolvePath(file, p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(path.Dir(file), p)
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return ""
+		}
+	}
+	return path.Clean("/" + p)[1:]
+}
+
+// fontReferences finds the font families named in font arguments.
+func fontReferences(file string, masked []byte) []reference {
+	var refs []reference
+	for _, m := range fontArg.FindAllIndex(masked, -1) {
+		start := m[1]
+		end := start
+		switch {
+		case start < len(masked) && masked[start] == '"':
+			if loc := stringToken.FindIndex(masked[start:]); loc != nil && loc[0] == 0 {
+				end = start + loc[1]
+			}
+		case start < len(masked) && masked[start] == '(':
+			depth := 0
+			for end = start; end < len(masked); end++ {
+				if masked[end] == '(' {
+					depth++
+				} else if masked[end] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		}
+
+		value := masked[start:end]
+		for _, loc := range stringToken.FindAllIndex(value, -1) {
+			// Skip the regex of (name: "...", covers: "...") dictionaries.
+			if bytes.HasSuffix(bytes.TrimRight(value[:loc[0]], " \t\n"), []byte("covers:")) {
+				continue
+			}
+			line, col := position(masked, start+loc[0])
+			refs = append(refs, reference{File: file, Line: line, Column: col, Func: "font", Literal: unquote(string(value[loc[0]:loc[1]]))})
+		}
+	}
+	return refs
+}
+
+var unquoter = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+// unquote returns the value of a Typst string literal.
+func unquote(s string) string {
+	return unquoter.Replace(s[1 : len(s)-1])
+}
+
+// position returns the 1-based line and column of offset i.
+func position(src []byte, i int) (line, col int) {
+	before := src[:i]
+	line = bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// Keywords that start a statement running to the end of the line when
+// embedded in markup with "#".
+var statementKeywords = map[string]bool{
+	"let": true, "set": true, "show": true, "import": true, "include": true, "if": true, "else": true,
+	"for": true, "while": true, "return": true, "context": true, "break": true, "continue": true,
+}
+
+type openDelim struct {
+	ch     byte
+	offset int
+	// embedded marks a delimiter of an expression embedded in markup, which
+	// may continue with further calls after it is closed.
+	embedded bool
+}
+
+// typstScanner checks the delimiters of a Typst source. It distinguishes
+// markup from code well enough to know where parentheses and strings are
+// syntax, without parsing expressions.
+type typstScanner struct {
+	file   string
+	src    []byte
+	masked []byte
+	stack  []openDelim
+	diags  []Diagnostic
+	// statement is set in markup after a "#let", "#set" and the like, up to
+	// the end of the line.
+	statement bool
+	// embedded is set in markup right after an embedded expression such as
+	// "#f(x)", which continues if followed by "(", "[" or ".field".
+	embedded bool
+}
+
+// scanTypst checks the delimiters of src and returns it with comments, raw
+// text and math blanked out, so that string literals found in it are code.
+func scanTypst(file string, src []byte) ([]byte, []Diagnostic) {
+	s := &typstScanner{file: file, src: src, masked: bytes.Clone(src)}
+	s.scan()
+	return s.masked, s.diags
+}
+
+func (s *typstScanner) report(offset int, message string) {
+	line, col := position(s.src, offset)
+	s.diags = append(s.diags, Diagnostic{File: s.file, Line: line, Column: col, Severity: "error", Message: message})
+}
+
+func (s *typstScanner) blank(from, to int) {
+	for k := from; k < to && k < len(s.masked); k++ {
+		if s.masked[k] != '\n' {
+			s.masked[k] = ' '
+		}
+	}
+}
+
+// inCode reports whether the scanner is in code rather than markup.
+func (s *typstScanner) inCode() bool {
+	if len(s.stack) > 0 && s.stack[len(s.stack)-1].ch != '[' {
+		return true
+	}
+	return s.statement
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '-'
+}
+
+func (s *typstScanner) ident(i int) int {
+	for i < len(s.src) && isIdentChar(s.src[i]) {
+		i++
+	}
+	return i
+}
+
+func (s *typstScanner) scan() {
+	src := s.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		var next byte
+		if i+1 < len(src) {
+			next = src[i+1]
+		}
+		code := s.inCode()
+
+		if s.embedded && !code {
+			switch {
+			case c == '(' || c == '[':
+				s.stack = append(s.stack, openDelim{ch: c, offset: i, embedded: true})
+				s.embedded, s.statement = false, false
+				i++
+				continue
+			case c == '.' && isIdentStart(next):
+				i = s.ident(i + 1)
+				continue
+			}
+			s.embedded = false
+		}
+
+		switch {
+		case c == '/' && next == '/' && !(!code && i > 0 && src[i-1] == ':'):
+			end := bytes.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			s.blank(i, i+end)
+			i += end
+		case c == '/' && next == '*':
+			i = s.blockComment(i)
+		case c == '\\' && !code:
+			i += 2
+		case c == '`':
+			i = s.raw(i)
+		case c == '"' && code:
+			i = s.str(i)
+		case c == '$':
+			i = s.math(i)
+		case c == '#' && !code:
+			i = s.hash(i)
+		case c == '\n':
+			if len(s.stack) == 0 || s.stack[len(s.stack)-1].ch == '[' {
+				s.statement = false
+			}
+			i++
+		case c == '[' || (code && (c == '(' || c == '{')):
+			s.stack = append(s.stack, openDelim{ch: c, offset: i})
+			if c == '[' {
+				s.statement = false
+			}
+			i++
+		case c == ']' || (code && (c == ')' || c == '}')):
+			s.close(i, c)
+			i++
+		default:
+			i++
+		}
+	}
+
+	for _, d := range s.stack {
+		s.report(d.offset, "unclosed delimiter")
+	}
+}
+
+var closers = map[byte]byte{')': '(', ']': '[', '}': '{'}
+
+func (s *typstScanner) close(i int, c byte) {
+	open := closers[c]
+	for k := len(s.stack) - 1; k >= 0; k-- {
+		if s.stack[k].ch != open {
+			continue
+		}
+		for _, d := range s.stack[k+1:] {
+			s.report(d.offset, "unclosed delimiter")
+		}
+		embedded := s.stack[k].embedded
+		s.stack = s.stack[:k]
+		if !s.inCode() {
+			s.embedded = embedded
+		}
+		return
+	}
+	s.report(i, "unexpected closing delimiter")
+}
+
+// hash handles a "#" in markup, which starts an embedded expression.
+func (s *typstScanner) hash(i int) int {
+	if i+1 >= len(s.src) {
+		return i + 1
+	}
+	switch c := s.src[i+1]; {
+	case isIdentStart(c):
+		end := s.ident(i + 1)
+		if statementKeywords[string(s.src[i+1:end])] {
+			s.statement = true
+		} else {
+			s.embedded = true
+		}
+		return end
+	case c == '(' || c == '[' || c == '{':
+		s.stack = append(s.stack, openDelim{ch: c, offset: i + 1, embedded: true})
+		return i + 2
+	case c == '"':
+		// A string literal embedded in markup.
+		return s.str(i + 1)
+	}
+	return i + 1
+}
+
+func (s *typstScanner) blockComment(i int) int {
+	depth := 0
+	for j := i; j+1 < len(s.src); j++ {
+		switch {
+		case s.src[j] == '/' && s.src[j+1] == '*':
+			depth++
+			j++
+		case s.src[j] == '*' && s.src[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				s.blank(i, j+1)
+				return j + 1
+			}
+		}
+	}
+	s.report(i, "unclosed comment")
+	s.blank(i, len(s.src))
+	return len(s.src)
+}
+
+func (s *typstScanner) raw(i int) int {
+	n := 0
+	for i+n < len(s.src) && s.src[i+n] == '`' {
+		n++
+	}
+	if n == 2 {
+		// An empty raw text.
+		return i + 2
+	}
+	fence := bytes.Repeat([]byte("`"), n)
+	for j := i + n; j < len(s.src); {
+		k := bytes.Index(s.src[j:], fence)
+		if k < 0 {
+			break
+		}
+		end := j + k
+		run := end
+		for run < len(s.src) && s.src[run] == '`' {
+			run++
+		}
+		if n == 1 || run-end == n {
+			s.blank(i, run)
+			return run
+		}
+		j = run
+	}
+	s.report(i, "unclosed raw text")
+	s.blank(i, len(s.src))
+	return len(s.src)
+}
+
+func (s *typstScanner) str(i int) int {
+	for j := i + 1; j < len(s.src); j++ {
+		switch s.src[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	s.report(i, "unclosed string")
+	return len(s.src)
+}
+
+func (s *typstScanner) math(i int) int {
+	for j := i + 1; j < len(s.src); j++ {
+		switch s.src[j] {
+		case '\\':
+			j++
+		case '"':
+			// Text in math may contain a dollar sign.
+			for j++; j < len(s.src) && s.src[j] != '"'; j++ {
+				if s.src[j] == '\\' {
+					j++
+				}
+			}
+		case '$':
+			s.blank(i, j+1)
+			return j + 1
+		}
+	}
+	s.report(i, "unclosed delimiter")
+	s.blank(i, len(s.src))
+	return len(s.src)
+}
@@ -0,0 +1,131 @@
+package typstpdfgenerator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflightDelimiters(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{"balanced", "#set text(size: 10pt)\n#let f(x) = [*#x*]\n= Title\n#f[a](b)", nil},
+		{"prose parentheses", "Some (text and a smiley :) here.\n#box[x] (see above", nil},
+		{"escaped brackets", `Use \[ and \] or \# freely.`, nil},
+		{"strings", `#let s = "a ) ] }" + "\"("`, nil},
+		{"markup quotes", `He said "hi [there]`, nil},
+		{"comments", "// (\n/* [ /* nested ) */ */ ok", nil},
+		{"urls", "See https://typst.app/docs [here]", nil},
+		{"raw", "`#f(` and ```\n#g[\n``` end", nil},
+		{"math", "$[0, 1)$ and $ (a $", nil},
+		{"unclosed bracket", "#box[\nunterminated", []string{"main.typ:1:5: error: unclosed delimiter"}},
+		{"unclosed call", "#box(width: 1pt\n\ntext", []string{"main.typ:1:5: error: unclosed delimiter"}},
+		{"unexpected closer", "text ]", []string{"main.typ:1:6: error: unexpected closing delimiter"}},
+		{"mismatch", "#f({)", []string{"main.typ:1:4: error: unclosed delimiter"}},
+		{"unclosed string", "#let s = \"abc", []string{"main.typ:1:10: error: unclosed string"}},
+		{"unclosed raw", "```\ncode", []string{"main.typ:1:1: error: unclosed raw text"}},
+		{"unclosed comment", "a /* b", []string{"main.typ:1:3: error: unclosed comment"}},
+		{"unknown variables are left to typst", "#this is not valid typst syntax {{{", nil},
+	}
+
+	client, err := New("test-key", "http://localhost")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := client.Preflight(&Request{Template: []byte(tt.source), Options: []string{"--diagnostic-format=short"}})
+			var got []string
+			for _, d := range diags {
+				got = append(got, d.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Preflight(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreflightFiles(t *testing.T) {
+	client, err := New("test-key", "http://localhost")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	template := `#import "@preview/cetz:0.3.0": canvas
+#import "lib/util.typ": helper
+#let data = json("data.json")
+#image("img/logo.png")
+#image("/img/missing.png")
+// #image("commented.png")
+` + "`#read(\"raw.txt\")`" + `
+#read("../secret.txt")`
+	media := []MediaFile{
+		{Name: "data.json", Data: []byte("{}")},
+		{Name: "./img/logo.png", Data: []byte("png")},
+		{Name: "lib/util.typ", Data: []byte(`#let helper = csv("table.csv")` + "\n" + `#let logo = image("../img/logo.png")`)},
+	}
+
+	diags := client.Preflight(&Request{Template: []byte(template), Options: []string{"--root=."}, Media: media})
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"main.typ:5:8: error: file not found: img/missing.png\n  hint: add it to the request media",
+		`main.typ:8:7: error: path "../secret.txt" is outside the project root`,
+		"lib/util.typ:1:19: error: file not found: lib/table.csv\n  hint: add it to the request media",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Preflight diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPreflightFonts(t *testing.T) {
+	client, err := New("test-key", "http://localhost")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	lato, err := os.ReadFile(filepath.Join(testTypstDir, "elspub", "fonts", "Lato-Black.ttf"))
+	if err != nil {
+		t.Fatalf("Failed to read font: %v", err)
+	}
+	template := []byte(`#set text(font: "Lato")
+#set text(font: ("Liberation Sans", "Libertinus Serif", (name: "lato", covers: "latin-in-cjk")))
+#show raw: set text(font: "DejaVu Sans Mono")`)
+	media := []MediaFile{{Name: "fonts/Lato-Black.ttf", Data: lato}}
+
+	tests := []struct {
+		name    string
+		options []string
+		media   []MediaFile
+		want    []string
+	}{
+		{"default options", nil, media, []string{"main.typ:2:18: warning: unknown font family: liberation sans"}},
+		{"missing font file", nil, nil, []string{"main.typ:1:17: warning: unknown font family: lato", "main.typ:2:18: warning: unknown font family: liberation sans"}},
+		{"font outside font path", []string{"--ignore-system-fonts", "--font-path=other"}, media, []string{"main.typ:1:17: warning: unknown font family: lato", "main.typ:2:18: warning: unknown font family: liberation sans"}},
+		{"system fonts allowed", []string{"--font-path=fonts"}, nil, nil},
+		{"embedded fonts ignored", []string{"--ignore-system-fonts", "--ignore-embedded-fonts", "--font-path", "fonts"}, media, []string{
+			"main.typ:2:18: warning: unknown font family: liberation sans",
+			"main.typ:2:37: warning: unknown font family: libertinus serif",
+			"main.typ:3:27: warning: unknown font family: dejavu sans mono",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := client.Preflight(&Request{Template: template, Options: tt.options, Media: tt.media})
+			var got []string
+			for _, d := range diags {
+				got = append(got, strings.SplitN(d.String(), "\n", 2)[0])
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Preflight diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Default delimiters of preprocessing actions. Neither clashes with Typst
+// markup or code, unlike text/template's "{{" and "}}".
+const (
+	DefaultLeftDelim  = "<%"
+	DefaultRightDelim = "%>"
+)
+
+// Preprocessor runs a .typ template through text/template before it is sent,
+// for conditional blocks and loops driven by Go data:
+//
+//	<% range .Items %>
+//	- <% markup .Name %>: <% markup .Price %>
+//	<% end %>
+//
+// Besides the text/template builtins, actions can use the functions of
+// TemplateFuncs to insert values safely.
+type Preprocessor struct {
+	// LeftDelim and RightDelim default to DefaultLeftDelim and
+	// DefaultRightDelim.
+	LeftDelim, RightDelim string
+	// Funcs are added to TemplateFuncs, replacing functions of the same name.
+	Funcs template.FuncMap
+}
+
+// PreprocessError is a template error located in the original template.
+type PreprocessError struct {
+	Name    string
+	Line    int
+	Column  int
+	Message string
+	Err     error
+}
+
+func (e *PreprocessError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.Name, e.Line, e.Column, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Name, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+func (e *PreprocessError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic returns the error in the form of a typst diagnostic.
+func (e *PreprocessError) Diagnostic() Diagnostic {
+	return Diagnostic{File: e.Name, Line: e.Line, Column: e.Column, Severity: "error", Message: e.Message}
+}
+
+// Preprocess executes src as a template named name with the default
+// Preprocessor.
+func Preprocess(name string, src []byte, data any) ([]byte, error) {
+	return (&Preprocessor{}).Execute(name, src, data)
+}
+
+// Execute executes src as a template named name, e.g. its file name, and
+// returns the Typst source to send. Errors are reported as *PreprocessError.
+func (p *Preprocessor) Execute(name string, src []byte, data any) ([]byte, error) {
+	left, right := p.LeftDelim, p.RightDelim
+	if left == "" {
+		left = DefaultLeftDelim
+	}
+	if right == "" {
+		right = DefaultRightDelim
+	}
+
+	funcs := TemplateFuncs()
+	for k, fn := range p.Funcs {
+		funcs[k] = fn
+	}
+
+	tmpl, err := template.New(name).Delims(left, right).Funcs(funcs).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return nil, newPreprocessError(name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, newPreprocessError(name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Template errors look like "template: name:12:7: executing ..." or, when
+// parsing, "template: name:12: unexpected ...", with a zero-based column.
+// Templates are parsed from the original source, so their positions are those
+// of the .typ file.
+var (
+	templateErrorPosition = regexp.MustCompile(`^template: (?:.*?):(\d+)(?::(\d+))?: (.*)$`)
+	executingPrefix       = regexp.MustCompile(`^executing ".*?" `)
+)
+
+func newPreprocessError(name string, err error) error {
+	pe := &PreprocessError{Name: name, Message: err.Error(), Err: err}
+
+	if m := templateErrorPosition.FindStringSubmatch(err.Error()); m != nil {
+		pe.Line, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			col, _ := strconv.Atoi(m[2])
+			pe.Column = col + 1
+		}
+		pe.Message = executingPrefix.ReplaceAllString(m[3], "")
+	}
+	return pe
+}
+
+// TemplateFuncs returns the functions available to preprocessed templates:
+//
+//	markup  escapes a value for Typst markup, so "#", "*" or "$" in data are
+//	        printed literally instead of being interpreted
+//	string  quotes a value as a Typst string literal, for use in code
+//	raw     wraps a value in a raw block that its backticks cannot close
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"markup": func(v any) string { return EscapeMarkup(fmt.Sprint(v)) },
+		"string": func(v any) string { return QuoteString(fmt.Sprint(v)) },
+		"raw":    func(v any) string { return rawBlock(fmt.Sprint(v)) },
+	}
+}
+
+var markupEscaper = strings.NewReplacer(
+	`\`, `\\`, `#`, `\#`, `$`, `\$`, `*`, `\*`, `_`, `\_`, "`", "\\`",
+	`<`, `\<`, `>`, `\>`, `@`, `\@`, `[`, `\[`, `]`, `\]`, `~`, `\~`,
+	`/`, `\/`, `=`, `\=`, `-`, `\-`, `+`, `\+`,
+)
+
+// EscapeMarkup escapes s so that it is rendered literally in Typst markup.
+func EscapeMarkup(s string) string {
+	return markupEscaper.Replace(s)
+}
+
+var stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// QuoteString returns s as a Typst string literal.
+func QuoteString(s string) string {
+	return `"` + stringEscaper.Replace(s) + `"`
+}
+
+var backtickRun = regexp.MustCompile("`+")
+
+func rawBlock(s string) string {
+	fence := 1
+	for _, run := range backtickRun.FindAllString(s, -1) {
+		fence = max(fence, len(run)+1)
+	}
+	// A fence of two backticks is an empty raw block, so use at least three.
+	if fence == 2 {
+		fence = 3
+	}
+	delim := strings.Repeat("`", fence)
+	if fence >= 3 {
+		// Separate the content so that it is not taken as a language tag.
+		return delim + " " + s + " " + delim
+	}
+	return delim + s + delim
+}
@@ -0,0 +1,138 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestPreprocess(t *testing.T) {
+	src := `#set page(paper: "a4")
+<% range .Items -%>
+- <% markup .Name %>: #<% string .Price %>
+<% end -%>
+<% if .Draft %>#text(red)[DRAFT]<% end %>
+#let dict = (a: 1, b: {2})
+`
+	data := map[string]any{
+		"Items": []map[string]any{
+			{"Name": "Widget #1 *new*", "Price": "9.99"},
+			{"Name": `Say "hi"`, "Price": "1\n"},
+		},
+		"Draft": true,
+	}
+
+	out, err := Preprocess("invoice.typ", []byte(src), data)
+	if err != nil {
+		t.Fatalf("Failed to preprocess: %v", err)
+	}
+	want := `#set page(paper: "a4")
+- Widget \#1 \*new\*: #"9.99"
+- Say "hi": #"1\n"
+#text(red)[DRAFT]
+#let dict = (a: 1, b: {2})
+`
+	if string(out) != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		line    int
+		column  int
+		message string
+	}{
+		{name: "parse", src: "= Title\n\n<% if .X %>", line: 3, message: "unexpected EOF"},
+		{name: "execute", src: "= Title\n#<% string .Missing %>\n", line: 2, column: 12, message: "map has no entry for key"},
+		{name: "unknown function", src: "<% upper .X %>", line: 1, message: `function "upper" not defined`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Preprocess("doc.typ", []byte(tt.src), map[string]any{"X": true})
+			var pe *PreprocessError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Expected *PreprocessError, got %v", err)
+			}
+			if pe.Line != tt.line || pe.Column != tt.column || !strings.Contains(pe.Message, tt.message) {
+				t.Errorf("Unexpected error position or message: %+v", pe)
+			}
+
+			diags := DiagnosticsFromError(ResponseInfo{}, err)
+			if len(diags) != 1 || diags[0].File != "doc.typ" || diags[0].Line != tt.line {
+				t.Errorf("Unexpected diagnostics: %+v", diags)
+			}
+		})
+	}
+}
+
+func TestPreprocessorCustomDelimsAndFuncs(t *testing.T) {
+	p := &Preprocessor{
+		LeftDelim:  "[[%",
+		RightDelim: "%]]",
+		Funcs:      template.FuncMap{"upper": strings.ToUpper},
+	}
+	out, err := p.Execute("doc.typ", []byte("= [[% upper .Title %]]"), map[string]string{"Title": "report"})
+	if err != nil {
+		t.Fatalf("Failed to preprocess: %v", err)
+	}
+	if string(out) != "= REPORT" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		fn, in, want string
+	}{
+		{fn: "markup", in: `$5 <label> @ref [x] a_b \ // c`, want: `\$5 \<label\> \@ref \[x\] a\_b \\ \/\/ c`},
+		{fn: "markup", in: "= not a heading", want: `\= not a heading`},
+		{fn: "string", in: "tab\there \"q\" \\", want: `"tab\there \"q\" \\"`},
+		{fn: "raw", in: "plain", want: "`plain`"},
+		{fn: "raw", in: "a ` b", want: "``` a ` b ```"},
+		{fn: "raw", in: "x ```go y", want: "```` x ```go y ````"},
+	}
+
+	funcs := TemplateFuncs()
+	for _, tt := range tests {
+		fn := funcs[tt.fn].(func(any) string)
+		if got := fn(tt.in); got != tt.want {
+			t.Errorf("%s(%q) = %q, want %q", tt.fn, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderPreprocessedTemplate(t *testing.T) {
+	var got typstRequest
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		writePDFResponse(w, fakePDF)
+	})
+
+	r := NewRegistry()
+	tpl := &Template{Name: "letter", Version: Version{Major: 1}, Source: []byte("Dear <% markup .Name %>,"), Preprocess: true}
+	if err := r.Register(tpl); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	client, err := New("test-key", server.URL, WithRegistry(r))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Render(context.Background(), &buf, "letter", map[string]string{"Name": "Ada_L"}); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if source, _ := base64.StdEncoding.DecodeString(got.Template); string(source) != `Dear Ada\_L,` {
+		t.Errorf("Expected preprocessed template, got %q", source)
+	}
+}
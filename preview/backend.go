@@ -0,0 +1,205 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+// Format is the image format pages are rendered to.
+type Format string
+
+const (
+	SVG Format = "svg"
+	PNG Format = "png"
+)
+
+func (f Format) contentType() string {
+	if f == PNG {
+		return "image/png"
+	}
+	return "image/svg+xml"
+}
+
+// Backend renders a template to one image per page.
+//
+// Diagnostics are returned on success too, e.g. for compiler warnings.
+type Backend interface {
+	Render(ctx context.Context, req *typstpdfgenerator.Request, format Format) (pages [][]byte, diags []typstpdfgenerator.Diagnostic, err error)
+}
+
+// ErrUnknownPageCount is returned by ClientBackend when the pages of the PDF
+// cannot be counted, as with PDFs using compressed object streams.
+var ErrUnknownPageCount = errors.New("cannot count the pages of the PDF")
+
+// ClientBackend renders through a gateway with Client.Convert.
+//
+// The gateway returns a single file per request, so the template is first
+// compiled to PDF to collect diagnostics and count the pages, then every page
+// is rendered on its own with typst's --format and --pages options. When the
+// page count cannot be determined it fails with ErrUnknownPageCount rather
+// than showing some of the pages; CommandBackend renders all pages at once.
+//
+// The gateway must render images: ClientBackend fails with an
+// *typstpdfgenerator.UnsupportedError when its capabilities do not list the
+// format. The reference gateway in package gateway only renders PDFs, so use
+// CommandBackend with it.
+type ClientBackend struct {
+	Client *typstpdfgenerator.Client
+}
+
+func (b *ClientBackend) Render(ctx context.Context, req *typstpdfgenerator.Request, format Format) ([][]byte, []typstpdfgenerator.Diagnostic, error) {
+	if err := b.checkFormat(ctx, format); err != nil {
+		return nil, nil, err
+	}
+
+	options := req.Options
+	if len(options) == 0 {
+		options = typstpdfgenerator.DefaultOptions()
+	}
+
+	var pdf bytes.Buffer
+	info, err := b.Client.Convert(ctx, &pdf, req.Content, req.Template, options, req.Media)
+	if err != nil {
+		return nil, typstpdfgenerator.DiagnosticsFromError(info, err), err
+	}
+	diags := typstpdfgenerator.ParseDiagnostics(info.Stderr)
+	if info.Pages == 0 {
+		return nil, diags, fmt.Errorf("%w, use a local typst executable to preview it", ErrUnknownPageCount)
+	}
+
+	pages := make([][]byte, info.Pages)
+	errs := make([]error, len(pages))
+	var wg sync.WaitGroup
+	for i := range pages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pageOptions := append(slices.Clone(options), "--format="+string(format), "--pages="+strconv.Itoa(i+1))
+			var page bytes.Buffer
+			if _, err := b.Client.Convert(ctx, &page, req.Content, req.Template, pageOptions, req.Media); err != nil {
+				errs[i] = fmt.Errorf("failed to render page %d: %w", i+1, err)
+				return
+			}
+			pages[i] = page.Bytes()
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, diags, err
+	}
+	return pages, diags, nil
+}
+
+// checkFormat fails when the gateway reports that it cannot render format.
+// Gateways that do not expose capabilities are assumed to render it.
+func (b *ClientBackend) checkFormat(ctx context.Context, format Format) error {
+	caps, err := b.Client.Capabilities(ctx)
+	var httpErr *typstpdfgenerator.HTTPError
+	switch {
+	case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound:
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to fetch gateway capabilities: %w", err)
+	case !caps.SupportsFormat(string(format)):
+		return &typstpdfgenerator.UnsupportedError{Feature: "output format", Detail: string(format)}
+	}
+	return nil
+}
+
+// CommandBackend renders with a local typst executable, for working offline.
+type CommandBackend struct {
+	// Path is the typst executable, "typst" from PATH by default.
+	Path string
+	// PPI is the resolution of PNG pages, typst's default if zero.
+	PPI int
+}
+
+func (b *CommandBackend) Render(ctx context.Context, req *typstpdfgenerator.Request, format Format) ([][]byte, []typstpdfgenerator.Diagnostic, error) {
+	dir, err := os.MkdirTemp("", "typst-preview-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "main.typ"), req.Template, 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write template: %w", err)
+	}
+	for _, m := range req.Media {
+		path := filepath.Join(dir, filepath.FromSlash(m.Name))
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return nil, nil, fmt.Errorf("invalid media name %q", m.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to write media: %w", err)
+		}
+		if err := os.WriteFile(path, m.Data, 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed to write media: %w", err)
+		}
+	}
+
+	options := req.Options
+	if len(options) == 0 {
+		options = typstpdfgenerator.DefaultOptions()
+	}
+	args := append([]string{"compile"}, options...)
+	if !slices.ContainsFunc(options, func(opt string) bool { return strings.HasPrefix(opt, "--diagnostic-format") }) {
+		args = append(args, "--diagnostic-format=short")
+	}
+	args = append(args, "--format="+string(format))
+	if format == PNG && b.PPI > 0 {
+		args = append(args, "--ppi="+strconv.Itoa(b.PPI))
+	}
+	args = append(args, "main.typ", "page-{0p}."+string(format))
+
+	path := b.Path
+	if path == "" {
+		path = "typst"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	diags := typstpdfgenerator.ParseDiagnostics(stderr.String())
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = runErr.Error()
+			}
+			return nil, diags, &typstpdfgenerator.NotGeneratedError{Message: msg}
+		}
+		return nil, diags, fmt.Errorf("failed to run typst: %w", runErr)
+	}
+
+	// Zero-padded page numbers sort in page order.
+	files, err := filepath.Glob(filepath.Join(dir, "page-*."+string(format)))
+	if err != nil {
+		return nil, diags, err
+	}
+	slices.Sort(files)
+	pages := make([][]byte, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, diags, fmt.Errorf("failed to read page: %w", err)
+		}
+		pages = append(pages, data)
+	}
+	return pages, diags, nil
+}
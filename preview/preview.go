@@ -0,0 +1,246 @@
+// Package preview serves a live preview of a Typst template: a local web page
+// showing the rendered pages as images, reloaded over Server-Sent Events
+// whenever the template or its media change, with compiler diagnostics
+// overlaid on the page.
+//
+//	srv := &preview.Server{
+//		Backend:  &preview.ClientBackend{Client: client},
+//		Template: "report.typ",
+//		Media:    []string{"assets"},
+//	}
+//	go srv.Run(ctx)
+//	http.ListenAndServe("localhost:8080", srv)
+package preview
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// Server renders a template on every change and serves the result. It is an
+// http.Handler; Run must be running for it to show anything.
+type Server struct {
+	Backend  Backend
+	Template string
+	// Media lists media files and directories, loaded with
+	// typstpdfgenerator.LoadMedia.
+	Media   []string
+	Content string
+	Options []string
+	// Format is the image format of the pages, SVG by default.
+	Format Format
+
+	// Interval and Debounce are passed to typstpdfgenerator.PollChanges.
+	Interval time.Duration
+	Debounce time.Duration
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+
+	mu          sync.Mutex
+	state       State
+	pages       [][]byte
+	subscribers map[chan State]struct{}
+}
+
+// State describes the latest render. It is sent to the browser on every
+// change.
+type State struct {
+	// Version increases with every render and is used to bust image caches.
+	Version     int                            `json:"version"`
+	Rendering   bool                           `json:"rendering"`
+	Pages       int                            `json:"pages"`
+	Format      Format                         `json:"format"`
+	Changed     []string                       `json:"changed,omitempty"`
+	Error       string                         `json:"error,omitempty"`
+	Diagnostics []typstpdfgenerator.Diagnostic `json:"diagnostics,omitempty"`
+	Duration    time.Duration                  `json:"duration"`
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.Format == "" {
+			s.Format = SVG
+		}
+		s.state.Format = s.Format
+		s.subscribers = make(map[chan State]struct{})
+
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("GET /{$}", s.handleIndex)
+		s.mux.HandleFunc("GET /state", s.handleState)
+		s.mux.HandleFunc("GET /events", s.handleEvents)
+		s.mux.HandleFunc("GET /pages/{page}", s.handlePage)
+	})
+}
+
+// Run renders the template and re-renders it on every change until ctx is
+// done, returning ctx's error.
+func (s *Server) Run(ctx context.Context) error {
+	if s.Backend == nil {
+		return fmt.Errorf("preview backend cannot be nil")
+	}
+	if s.Template == "" {
+		return fmt.Errorf("preview template must be set")
+	}
+	if s.Format != "" && s.Format != SVG && s.Format != PNG {
+		return fmt.Errorf("unsupported preview format %q", s.Format)
+	}
+	s.init()
+
+	paths := append([]string{s.Template}, s.Media...)
+	return typstpdfgenerator.PollChanges(ctx, paths, s.Interval, s.Debounce, func(changed []string) {
+		s.render(ctx, changed)
+	})
+}
+
+// State returns the state of the latest render.
+func (s *Server) State() State {
+	s.init()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Server) render(ctx context.Context, changed []string) {
+	s.mu.Lock()
+	s.state.Rendering = true
+	s.state.Changed = changed
+	s.publishLocked()
+	s.mu.Unlock()
+
+	start := time.Now()
+	pages, diags, err := s.renderPages(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Version++
+	s.state.Rendering = false
+	s.state.Diagnostics = diags
+	s.state.Duration = time.Since(start)
+	s.state.Error = ""
+	if err != nil {
+		// Keep showing the last good pages below the diagnostics.
+		s.state.Error = err.Error()
+	} else {
+		s.pages = pages
+		s.state.Pages = len(pages)
+	}
+	s.publishLocked()
+}
+
+func (s *Server) renderPages(ctx context.Context) ([][]byte, []typstpdfgenerator.Diagnostic, error) {
+	templateData, err := os.ReadFile(s.Template)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	var media []typstpdfgenerator.MediaFile
+	for _, path := range s.Media {
+		files, err := typstpdfgenerator.LoadMedia(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		media = append(media, files...)
+	}
+
+	req := &typstpdfgenerator.Request{Content: s.Content, Template: templateData, Options: s.Options, Media: media}
+	return s.Backend.Render(ctx, req, s.Format)
+}
+
+func (s *Server) publishLocked() {
+	for ch := range s.subscribers {
+		// Subscribers only need the latest state, so replace a pending one.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s.state
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init()
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(indexHTML)
+}
+
+func (s *Server) handleState(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.State())
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan State, 1)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	ch <- s.state
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case state := <-ch:
+			data, err := json.Marshal(state)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("page"))
+
+	s.mu.Lock()
+	var page []byte
+	if err == nil && n >= 1 && n <= len(s.pages) {
+		page = s.pages[n-1]
+	}
+	s.mu.Unlock()
+
+	if page == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", s.Format.contentType())
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(page)
+}
@@ -0,0 +1,288 @@
+package preview
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	typstpdfgenerator "github.com/4sigma/typstpdfgenerator"
+)
+
+type gatewayRequest struct {
+	Template string   `json:"template"`
+	Options  []string `json:"options"`
+}
+
+// newFakeGateway renders a two page PDF, or a page as "<svg>page N</svg>"
+// when asked for --format=svg, and reports PDF and SVG in its capabilities. Templates containing "broken" fail with a
+// diagnostic on line 2, and those containing "compressed" hide their pages in
+// an object stream.
+func newFakeGateway(t *testing.T) *typstpdfgenerator.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities" {
+			_ = json.NewEncoder(w).Encode(typstpdfgenerator.Capabilities{OutputFormats: []string{"pdf", "svg"}})
+			return
+		}
+		var req gatewayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template, _ := base64.StdEncoding.DecodeString(req.Template)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(template), "broken") {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error":   true,
+				"message": "compilation failed",
+				"stderr":  "main.typ:2:3: error: unknown variable: foo\nhint: did you mean bar?\n",
+			})
+			return
+		}
+
+		out := "%PDF-1.7 /Type /Page /Type /Page " + string(template)
+		if strings.Contains(string(template), "compressed") {
+			out = "%PDF-1.7 /Type /ObjStm " + string(template)
+		}
+		if slices.Contains(req.Options, "--format=svg") {
+			page := strings.TrimPrefix(req.Options[len(req.Options)-1], "--pages=")
+			out = "<svg>page " + page + " " + string(template) + "</svg>"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"pdf":    base64.StdEncoding.EncodeToString([]byte(out)),
+			"stderr": "main.typ:1:1: warning: unused import\n",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := typstpdfgenerator.New("test-key", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+func TestClientBackend(t *testing.T) {
+	backend := &ClientBackend{Client: newFakeGateway(t)}
+
+	pages, diags, err := backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("doc")}, SVG)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	want := []string{"<svg>page 1 doc</svg>", "<svg>page 2 doc</svg>"}
+	if len(pages) != len(want) {
+		t.Fatalf("Expected %d pages, got %d", len(want), len(pages))
+	}
+	for i, page := range pages {
+		if string(page) != want[i] {
+			t.Errorf("Expected page %d to be %q, got %q", i+1, want[i], page)
+		}
+	}
+	if len(diags) != 1 || diags[0].Severity != "warning" {
+		t.Errorf("Expected one warning, got %+v", diags)
+	}
+
+	_, diags, err = backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("broken")}, SVG)
+	if !errors.Is(err, typstpdfgenerator.ErrNotGenerated) {
+		t.Fatalf("Expected ErrNotGenerated, got %v", err)
+	}
+	if len(diags) != 1 || diags[0].Line != 2 || len(diags[0].Hints) != 1 {
+		t.Errorf("Expected one diagnostic with a hint on line 2, got %+v", diags)
+	}
+
+	pages, diags, err = backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("compressed")}, SVG)
+	if !errors.Is(err, ErrUnknownPageCount) || pages != nil {
+		t.Errorf("Expected ErrUnknownPageCount, got %d pages, %v", len(pages), err)
+	}
+	if len(diags) != 1 {
+		t.Errorf("Expected the warning with the error, got %+v", diags)
+	}
+
+	_, _, err = backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("doc")}, PNG)
+	var unsupported *typstpdfgenerator.UnsupportedError
+	if !errors.As(err, &unsupported) || unsupported.Detail != "png" {
+		t.Errorf("Expected PNG to be unsupported, got %v", err)
+	}
+}
+
+func TestCommandBackend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake typst executable is a shell script")
+	}
+
+	// The fake typst writes two pages, or fails when the template contains
+	// "broken".
+	dir := t.TempDir()
+	typst := filepath.Join(dir, "typst")
+	script := `#!/bin/sh
+if grep -q broken main.typ; then
+	echo "main.typ:2:3: error: unknown variable: foo" >&2
+	exit 1
+fi
+test -f assets/logo.svg || exit 3
+echo one > page-01.svg
+echo two > page-02.svg
+`
+	if err := os.WriteFile(typst, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake typst: %v", err)
+	}
+	backend := &CommandBackend{Path: typst}
+	media := []typstpdfgenerator.MediaFile{{Name: "assets/logo.svg", Data: []byte("<svg/>")}}
+
+	pages, _, err := backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("doc"), Media: media}, SVG)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if len(pages) != 2 || string(pages[0]) != "one\n" || string(pages[1]) != "two\n" {
+		t.Errorf("Unexpected pages: %q", pages)
+	}
+
+	_, diags, err := backend.Render(context.Background(), &typstpdfgenerator.Request{Template: []byte("broken")}, SVG)
+	if !errors.Is(err, typstpdfgenerator.ErrNotGenerated) {
+		t.Fatalf("Expected ErrNotGenerated, got %v", err)
+	}
+	if len(diags) != 1 || diags[0].Line != 2 {
+		t.Errorf("Expected one diagnostic on line 2, got %+v", diags)
+	}
+
+	_, _, err = backend.Render(context.Background(), &typstpdfgenerator.Request{
+		Template: []byte("doc"),
+		Media:    []typstpdfgenerator.MediaFile{{Name: "../escape", Data: []byte("x")}},
+	}, SVG)
+	if err == nil {
+		t.Errorf("Expected error for media outside the work directory")
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "main.typ")
+	if err := os.WriteFile(templatePath, []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	srv := &Server{
+		Backend:  &ClientBackend{Client: newFakeGateway(t)},
+		Template: templatePath,
+		Interval: 10 * time.Millisecond,
+		Debounce: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+
+	resp, err := http.Get(httpServer.URL + "/events")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	events := make(chan State)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var state State
+			if err := json.Unmarshal([]byte(data), &state); err != nil {
+				continue
+			}
+			select {
+			case events <- state:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	waitFor := func(version int) State {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case state := <-events:
+				if state.Version >= version && !state.Rendering {
+					return state
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for render %d", version)
+				return State{}
+			}
+		}
+	}
+	get := func(path string) (int, string, string) {
+		t.Helper()
+		resp, err := http.Get(httpServer.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	state := waitFor(1)
+	if state.Pages != 2 || state.Error != "" || state.Format != SVG {
+		t.Fatalf("Unexpected state after first render: %+v", state)
+	}
+	if status, ct, body := get("/pages/2"); status != http.StatusOK || ct != "image/svg+xml" || body != "<svg>page 2 first</svg>" {
+		t.Errorf("Unexpected page 2: %d %s %q", status, ct, body)
+	}
+	if status, _, _ := get("/pages/3"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing page, got %d", status)
+	}
+	if status, _, body := get("/"); status != http.StatusOK || !strings.Contains(body, "EventSource") {
+		t.Errorf("Unexpected index page: %d", status)
+	}
+
+	if err := os.WriteFile(templatePath, []byte("broken template"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	state = waitFor(2)
+	if state.Error == "" || len(state.Diagnostics) != 1 || state.Diagnostics[0].Line != 2 {
+		t.Fatalf("Expected error with diagnostic on line 2, got %+v", state)
+	}
+	if !slices.Equal(state.Changed, []string{templatePath}) {
+		t.Errorf("Expected changed files [%s], got %v", templatePath, state.Changed)
+	}
+	// The last good render stays available.
+	if _, _, body := get("/pages/1"); body != "<svg>page 1 first</svg>" {
+		t.Errorf("Expected previous page 1, got %q", body)
+	}
+
+	var current State
+	_, _, body := get("/state")
+	if err := json.Unmarshal([]byte(body), &current); err != nil {
+		t.Fatalf("Failed to decode state: %v", err)
+	}
+	if current.Version != state.Version {
+		t.Errorf("Expected state version %d, got %d", state.Version, current.Version)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
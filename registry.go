@@ -0,0 +1,463 @@
+package typstpdfgenerator
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version is a semantic version of a registered template.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses "1.2.3", optionally prefixed with "v".
+func ParseVersion(s string) (Version, error) {
+	v, parts, err := parseVersionPrefix(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected major.minor.patch", s)
+	}
+	return v, nil
+}
+
+// parseVersionPrefix parses "1", "1.2" or "1.2.3" and returns how many parts
+// were given.
+func parseVersionPrefix(s string) (Version, int, error) {
+	fields := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(fields) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	var nums [3]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, len(fields), nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or +1 depending on whether v sorts before, equal to or
+// after o.
+func (v Version) Compare(o Version) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	return cmp.Compare(v.Patch, o.Patch)
+}
+
+// Template is a template registered under a name and version.
+type Template struct {
+	Name    string
+	Version Version
+	Source  []byte
+	Media   []MediaFile
+	// Options replace the client's default options when rendering.
+	Options []string
+	// DataFile is the media name Render attaches its data under as JSON,
+	// "data.json" by default. The name is passed as sys.inputs.data.
+	DataFile string
+	// Deprecated, if set, explains what to use instead. Rendering a
+	// deprecated template logs a warning.
+	Deprecated string
+	// Preprocess makes Render execute Source with Preprocess and the render
+	// data before sending it.
+	Preprocess bool
+}
+
+// Ref returns "name@version".
+func (t *Template) Ref() string {
+	return t.Name + "@" + t.Version.String()
+}
+
+// ErrTemplateNotFound is returned when a reference matches no registered
+// template.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Registry holds named, versioned templates so that services refer to
+// "invoice@2" rather than to a path in their checkout.
+//
+// References are "name", "name@major", "name@major.minor" or
+// "name@major.minor.patch" and resolve to the highest matching version. A
+// bare name resolves to its pinned version, if any.
+type Registry struct {
+	// OnDeprecated, if set, is called the first time a deprecated template
+	// version is rendered.
+	OnDeprecated func(t *Template)
+
+	mu        sync.RWMutex
+	templates map[string][]*Template // sorted by ascending version
+	pins      map[string]string
+	warned    map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		templates: make(map[string][]*Template),
+		pins:      make(map[string]string),
+		warned:    make(map[string]bool),
+	}
+}
+
+// WithRegistry makes the templates of r available to Render.
+func WithRegistry(r *Registry) Option {
+	return func(c *Client) error {
+		if r == nil {
+			return fmt.Errorf("registry cannot be nil")
+		}
+		c.registry = r
+		return nil
+	}
+}
+
+// Register adds t. Registering the same name and version twice is an error.
+func (r *Registry) Register(t *Template) error {
+	if t == nil {
+		return fmt.Errorf("template cannot be nil")
+	}
+	if t.Name == "" || strings.ContainsAny(t.Name, "@/") {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	if len(t.Source) == 0 {
+		return fmt.Errorf("template %s has no source", t.Ref())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.templates[t.Name]
+	i, found := slices.BinarySearchFunc(versions, t.Version, func(e *Template, v Version) int {
+		return e.Version.Compare(v)
+	})
+	if found {
+		return fmt.Errorf("template %s is already registered", t.Ref())
+	}
+	r.templates[t.Name] = slices.Insert(versions, i, t)
+	return nil
+}
+
+// Lookup resolves a reference to a registered template.
+func (r *Registry) Lookup(ref string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookupLocked(ref)
+}
+
+func (r *Registry) lookupLocked(ref string) (*Template, error) {
+	name, constraint, hasVersion := strings.Cut(ref, "@")
+	if !hasVersion {
+		if pinned, ok := r.pins[name]; ok {
+			constraint, hasVersion = pinned, true
+		}
+	}
+
+	versions := r.templates[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	if !hasVersion {
+		return versions[len(versions)-1], nil
+	}
+
+	want, parts, err := parseVersionPrefix(constraint)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i].Version
+		if v.Major == want.Major && (parts < 2 || v.Minor == want.Minor) && (parts < 3 || v.Patch == want.Patch) {
+			return versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s@%s", ErrTemplateNotFound, name, constraint)
+}
+
+// Pin makes the bare name of ref resolve like ref, e.g. Pin("invoice@2")
+// keeps "invoice" on major version 2 after invoice 3 is registered.
+func (r *Registry) Pin(ref string) error {
+	name, constraint, ok := strings.Cut(ref, "@")
+	if !ok {
+		return fmt.Errorf("pin %q must include a version", ref)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.lookupLocked(ref); err != nil {
+		return err
+	}
+	r.pins[name] = constraint
+	return nil
+}
+
+// Unpin makes the bare name resolve to its latest version again.
+func (r *Registry) Unpin(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pins, name)
+}
+
+// List returns all registered templates ordered by name and version.
+func (r *Registry) List() []*Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var list []*Template
+	for _, name := range names {
+		list = append(list, r.templates[name]...)
+	}
+	return list
+}
+
+// Render renders a registered template with data, which is attached as JSON
+// (see Template.DataFile). data may be nil.
+func (c *Client) Render(ctx context.Context, w io.Writer, ref string, data any) (ResponseInfo, error) {
+	if c.registry == nil {
+		return ResponseInfo{}, fmt.Errorf("no template registry, see WithRegistry")
+	}
+	t, err := c.registry.Lookup(ref)
+	if err != nil {
+		return ResponseInfo{}, err
+	}
+	if t.Deprecated != "" {
+		c.registry.deprecated(ctx, c, t)
+	}
+
+	source := t.Source
+	if t.Preprocess {
+		if source, err = Preprocess(t.Ref(), t.Source, data); err != nil {
+			return ResponseInfo{}, err
+		}
+	}
+
+	options := c.resolveOptions(t.Options)
+	media := t.Media
+	if data != nil {
+		dataJSON, err := json.Marshal(data)
+		if err != nil {
+			return ResponseInfo{}, fmt.Errorf("failed to encode template data: %w", err)
+		}
+		dataFile := t.DataFile
+		if dataFile == "" {
+			dataFile = "data.json"
+		}
+		media = append(slices.Clone(media), MediaFile{Name: dataFile, Data: dataJSON})
+		options = append(slices.Clone(options), "--input", "data="+dataFile)
+	}
+
+	return c.Convert(ctx, w, "", source, options, media)
+}
+
+// deprecated reports the first render of a deprecated template version.
+func (r *Registry) deprecated(ctx context.Context, c *Client, t *Template) {
+	r.mu.Lock()
+	first := !r.warned[t.Ref()]
+	r.warned[t.Ref()] = true
+	r.mu.Unlock()
+	if !first {
+		return
+	}
+
+	if c.logger != nil {
+		c.logger.WarnContext(ctx, "typst template is deprecated", "template", t.Ref(), "deprecated", t.Deprecated)
+	}
+	if r.OnDeprecated != nil {
+		r.OnDeprecated(t)
+	}
+}
+
+// templateManifest is the optional template.json of a template directory.
+type templateManifest struct {
+	Version    string   `json:"version"`
+	Main       string   `json:"main"`
+	Options    []string `json:"options"`
+	Data       string   `json:"data"`
+	Deprecated string   `json:"deprecated"`
+	Preprocess bool     `json:"preprocess"`
+}
+
+// LoadDir registers the templates below dir, see LoadFS.
+func (r *Registry) LoadDir(dir string) error {
+	return r.LoadFS(os.DirFS(dir))
+}
+
+// LoadFS registers every template directory at the root of fsys. A template
+// directory is laid out like those in test/typst:
+//
+//	invoice/invoice.typ     main file, or main.typ
+//	invoice/logo.png        media, named relative to the template directory
+//	invoice/template.json   optional: version, main, options, data,
+//	                        deprecated, preprocess
+//
+// Without a template.json the version is 1.0.0. Several versions can be kept
+// side by side in version directories such as invoice/2.1.0/.
+func (r *Registry) LoadFS(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read template directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+
+		versionDirs, err := r.versionDirs(fsys, name)
+		if err != nil {
+			return err
+		}
+		if len(versionDirs) == 0 {
+			versionDirs = map[string]string{name: ""}
+		}
+		for dir, version := range versionDirs {
+			t, err := loadTemplateDir(fsys, name, dir, version)
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				continue
+			}
+			if err := r.Register(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// versionDirs returns the version subdirectories of a template directory,
+// mapped to their version.
+func (r *Registry) versionDirs(fsys fs.FS, name string) (map[string]string, error) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory: %w", err)
+	}
+	dirs := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := ParseVersion(entry.Name()); err == nil {
+			dirs[path.Join(name, entry.Name())] = entry.Name()
+		}
+	}
+	return dirs, nil
+}
+
+// loadTemplateDir loads the template in dir. It returns nil for directories
+// without a main file.
+func loadTemplateDir(fsys fs.FS, name, dir, version string) (*Template, error) {
+	var manifest templateManifest
+	if data, err := fs.ReadFile(fsys, path.Join(dir, "template.json")); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s/template.json: %w", dir, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if manifest.Version != "" {
+		version = manifest.Version
+	}
+	if version == "" {
+		version = "1.0.0"
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", dir, err)
+	}
+
+	main := manifest.Main
+	if main == "" {
+		for _, candidate := range []string{name + ".typ", "main.typ"} {
+			if _, err := fs.Stat(fsys, path.Join(dir, candidate)); err == nil {
+				main = candidate
+				break
+			}
+		}
+		if main == "" {
+			return nil, nil
+		}
+	}
+	source, err := fs.ReadFile(fsys, path.Join(dir, main))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	media, err := loadMediaFS(fsys, dir, func(rel string) bool {
+		return rel == main || rel == "template.json"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{
+		Name:       name,
+		Version:    v,
+		Source:     source,
+		Media:      media,
+		Options:    manifest.Options,
+		DataFile:   manifest.Data,
+		Deprecated: manifest.Deprecated,
+		Preprocess: manifest.Preprocess,
+	}, nil
+}
+
+// loadMediaFS loads every file below dir, named by its slash-separated path
+// relative to dir, except those skip returns true for. Version directories
+// of a template are not descended into.
+func loadMediaFS(fsys fs.FS, dir string, skip func(rel string) bool) ([]MediaFile, error) {
+	var media []MediaFile
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+		if d.IsDir() {
+			if _, err := ParseVersion(d.Name()); err == nil && p != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if skip != nil && skip(rel) {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		media = append(media, MediaFile{Name: rel, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media directory: %w", err)
+	}
+	return media, nil
+}
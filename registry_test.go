@@ -0,0 +1,183 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegistryLookup(t *testing.T) {
+	r := NewRegistry()
+	for _, v := range []string{"1.0.0", "2.0.0", "2.1.0", "2.1.3", "3.0.0"} {
+		version, err := ParseVersion(v)
+		if err != nil {
+			t.Fatalf("Failed to parse version: %v", err)
+		}
+		if err := r.Register(&Template{Name: "invoice", Version: version, Source: []byte(v)}); err != nil {
+			t.Fatalf("Failed to register: %v", err)
+		}
+	}
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "invoice", want: "3.0.0"},
+		{ref: "invoice@2", want: "2.1.3"},
+		{ref: "invoice@2.0", want: "2.0.0"},
+		{ref: "invoice@v2.1.0", want: "2.1.0"},
+		{ref: "invoice@4", want: ""},
+		{ref: "receipt", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			tpl, err := r.Lookup(tt.ref)
+			if tt.want == "" {
+				if !errors.Is(err, ErrTemplateNotFound) {
+					t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to look up: %v", err)
+			}
+			if got := tpl.Version.String(); got != tt.want {
+				t.Errorf("Expected version %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if err := r.Register(&Template{Name: "invoice", Version: Version{Major: 2}, Source: []byte("dup")}); err == nil {
+		t.Error("Expected error registering a duplicate version")
+	}
+
+	if err := r.Pin("invoice@2"); err != nil {
+		t.Fatalf("Failed to pin: %v", err)
+	}
+	if tpl, _ := r.Lookup("invoice"); tpl.Ref() != "invoice@2.1.3" {
+		t.Errorf("Expected pinned invoice@2.1.3, got %s", tpl.Ref())
+	}
+	r.Unpin("invoice")
+	if tpl, _ := r.Lookup("invoice"); tpl.Ref() != "invoice@3.0.0" {
+		t.Errorf("Expected latest invoice@3.0.0 after unpinning, got %s", tpl.Ref())
+	}
+	if err := r.Pin("invoice@5"); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound pinning a missing version, got %v", err)
+	}
+}
+
+func TestRegistryLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"invoice/invoice.typ":            {Data: []byte("v1")},
+		"invoice/logo.png":               {Data: []byte("png")},
+		"receipt/1.0.0/main.typ":         {Data: []byte("receipt v1")},
+		"receipt/1.0.0/template.json":    {Data: []byte(`{"deprecated": "use receipt@2"}`)},
+		"receipt/2.0.0/receipt.typ":      {Data: []byte("receipt v2")},
+		"receipt/2.0.0/template.json":    {Data: []byte(`{"options": ["--ppi=300"], "data": "ctx.json"}`)},
+		"receipt/2.0.0/img/header.svg":   {Data: []byte("<svg/>")},
+		"notes/README.md":                {Data: []byte("not a template")},
+		"README.md":                      {Data: []byte("ignored")},
+		"letter/template.json":           {Data: []byte(`{"version": "4.2.0", "main": "body.typ"}`)},
+		"letter/body.typ":                {Data: []byte("letter")},
+		"letter/fonts/Inter-Regular.ttf": {Data: []byte("font")},
+	}
+
+	r := NewRegistry()
+	if err := r.LoadFS(fsys); err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	var refs []string
+	for _, tpl := range r.List() {
+		refs = append(refs, tpl.Ref())
+	}
+	want := []string{"invoice@1.0.0", "letter@4.2.0", "receipt@1.0.0", "receipt@2.0.0"}
+	if !slices.Equal(refs, want) {
+		t.Fatalf("Expected templates %v, got %v", want, refs)
+	}
+
+	receipt, _ := r.Lookup("receipt@2")
+	if len(receipt.Media) != 1 || receipt.Media[0].Name != "img/header.svg" {
+		t.Errorf("Unexpected receipt media: %+v", receipt.Media)
+	}
+	if receipt.DataFile != "ctx.json" || !slices.Equal(receipt.Options, []string{"--ppi=300"}) {
+		t.Errorf("Manifest not applied: %+v", receipt)
+	}
+	if old, _ := r.Lookup("receipt@1"); old.Deprecated != "use receipt@2" || len(old.Media) != 0 {
+		t.Errorf("Unexpected receipt@1: %+v", old)
+	}
+	if letter, _ := r.Lookup("letter"); string(letter.Source) != "letter" || len(letter.Media) != 1 {
+		t.Errorf("Unexpected letter: %+v", letter)
+	}
+}
+
+func TestRegistryLoadDir(t *testing.T) {
+	r := NewRegistry()
+	if err := r.LoadDir("test/typst"); err != nil {
+		t.Fatalf("Failed to load test templates: %v", err)
+	}
+
+	elspub, err := r.Lookup("elspub@1")
+	if err != nil {
+		t.Fatalf("Failed to look up elspub: %v", err)
+	}
+	if !slices.ContainsFunc(elspub.Media, func(m MediaFile) bool { return m.Name == "test_data.json" }) {
+		t.Error("Expected elspub media to include test_data.json")
+	}
+}
+
+func TestRender(t *testing.T) {
+	var got typstRequest
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		writePDFResponse(w, fakePDF)
+	})
+
+	r := NewRegistry()
+	if err := r.Register(&Template{Name: "invoice", Version: Version{Major: 2}, Source: []byte("new")}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := r.Register(&Template{Name: "invoice", Version: Version{Major: 1}, Source: []byte("old"), Deprecated: "use invoice@2"}); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	var deprecated []string
+	r.OnDeprecated = func(tpl *Template) { deprecated = append(deprecated, tpl.Ref()) }
+
+	client, err := New("test-key", server.URL, WithRegistry(r))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	data := map[string]any{"number": 42}
+	if _, err := client.Render(context.Background(), &buf, "invoice@2", data); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if buf.String() != fakePDF {
+		t.Errorf("Expected PDF output, got %q", buf.String())
+	}
+	if tpl, _ := base64.StdEncoding.DecodeString(got.Template); string(tpl) != "new" {
+		t.Errorf("Expected invoice@2 source, got %q", tpl)
+	}
+	if dataJSON, _ := base64.StdEncoding.DecodeString(got.Media["data.json"]); string(dataJSON) != `{"number":42}` {
+		t.Errorf("Expected data.json media, got %q", dataJSON)
+	}
+	if !slices.Contains(got.Options, "data=data.json") || !slices.Contains(got.Options, "--ignore-system-fonts") {
+		t.Errorf("Expected default options with data input, got %v", got.Options)
+	}
+
+	for range 2 {
+		if _, err := client.Render(context.Background(), &buf, "invoice@1", nil); err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+	}
+	if !slices.Equal(deprecated, []string{"invoice@1.0.0"}) {
+		t.Errorf("Expected one deprecation warning, got %v", deprecated)
+	}
+}
@@ -0,0 +1,129 @@
+package typstpdfgenerator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// UnresolvedReference is a file loaded by a template that could not be
+// found.
+type UnresolvedReference struct {
+	// File, Line and Column locate the reference in the template or module.
+	File   string
+	Line   int
+	Column int
+	// Path is the referenced path relative to the project root, or as written
+	// if it points outside of it.
+	Path string
+	Err  error
+}
+
+// ResolveError lists the references ResolveMedia could not resolve.
+type ResolveError struct {
+	Unresolved []UnresolvedReference
+}
+
+func (e *ResolveError) Error() string {
+	parts := make([]string, len(e.Unresolved))
+	for i, u := range e.Unresolved {
+		parts[i] = fmt.Sprintf("%s:%d:%d: %s: %v", u.File, u.Line, u.Column, u.Path, u.Err)
+	}
+	return "unresolved media: " + strings.Join(parts, "; ")
+}
+
+// Diagnostics returns one error diagnostic per unresolved reference.
+func (e *ResolveError) Diagnostics() []Diagnostic {
+	diags := make([]Diagnostic, len(e.Unresolved))
+	for i, u := range e.Unresolved {
+		diags[i] = Diagnostic{File: u.File, Line: u.Line, Column: u.Column, Severity: "error", Message: fmt.Sprintf("file not found: %s", u.Path)}
+	}
+	return diags
+}
+
+var errOutsideRoot = errors.New("path is outside the project root")
+
+// ResolveMedia finds the files that template and the local modules it
+// imports or includes load by literal paths, with image, read, json, csv,
+// yaml, toml, xml or cbor, and loads them from fsys, which holds the
+// template's directory. Package imports are skipped.
+//
+// Files already in media are not loaded again, but modules among them are
+// scanned. The loaded files are returned; references that could not be
+// loaded are reported with a *ResolveError alongside the others.
+func ResolveMedia(fsys fs.FS, template []byte, media []MediaFile) ([]MediaFile, error) {
+	sources := make(map[string][]byte)
+	present := make(map[string]bool, len(media))
+	for _, m := range media {
+		name := cleanMediaName(m.Name)
+		present[name] = true
+		sources[name] = m.Data
+	}
+	sources[templateName] = template
+
+	var resolved []MediaFile
+	var unresolved []UnresolvedReference
+	queue := []string{templateName}
+	scanned := map[string]bool{templateName: true}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+
+		masked, _ := scanTypst(file, sources[file])
+		for _, ref := range fileReferences(file, masked) {
+			if ref.Path == "" {
+				unresolved = append(unresolved, UnresolvedReference{File: ref.File, Line: ref.Line, Column: ref.Column, Path: ref.Literal, Err: errOutsideRoot})
+				continue
+			}
+			if !present[ref.Path] {
+				data, err := fs.ReadFile(fsys, ref.Path)
+				if err != nil {
+					unresolved = append(unresolved, UnresolvedReference{File: ref.File, Line: ref.Line, Column: ref.Column, Path: ref.Path, Err: err})
+					continue
+				}
+				present[ref.Path] = true
+				sources[ref.Path] = data
+				resolved = append(resolved, MediaFile{Name: ref.Path, Data: data})
+			}
+			if (ref.Func == "import" || ref.Func == "include") && !scanned[ref.Path] {
+				scanned[ref.Path] = true
+				queue = append(queue, ref.Path)
+			}
+		}
+	}
+
+	if len(unresolved) > 0 {
+		return resolved, &ResolveError{Unresolved: unresolved}
+	}
+	return resolved, nil
+}
+
+// ResolveMediaDir is like ResolveMedia with the files of dir.
+func ResolveMediaDir(dir string, template []byte, media []MediaFile) ([]MediaFile, error) {
+	return ResolveMedia(os.DirFS(dir), template, media)
+}
+
+// WithMediaResolver makes Convert attach the files a template loads by literal
+// paths from fsys, unless they are passed as media already. Conversions
+// referencing files that are in neither fail with a *ResolveError before
+// anything is sent.
+func WithMediaResolver(fsys fs.FS) Option {
+	return func(c *Client) error {
+		c.mediaFS = fsys
+		return nil
+	}
+}
+
+func (c *Client) resolveMedia(templateData []byte, media []MediaFile) ([]MediaFile, error) {
+	if c.mediaFS == nil {
+		return media, nil
+	}
+	resolved, err := ResolveMedia(c.mediaFS, templateData, media)
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clip(media), resolved...), nil
+}
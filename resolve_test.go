@@ -0,0 +1,120 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func mediaNames(media []MediaFile) []string {
+	names := make([]string, len(media))
+	for i, m := range media {
+		names[i] = m.Name
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestResolveMedia(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.json":          {Data: []byte(`{}`)},
+		"img/logo.png":       {Data: []byte("logo")},
+		"lib/util.typ":       {Data: []byte(`#let rows = csv("rows.csv")` + "\n" + `#include "../chapters/intro.typ"`)},
+		"lib/rows.csv":       {Data: []byte("a,b")},
+		"chapters/intro.typ": {Data: []byte(`#image("/img/logo.png") #yaml("meta.yaml")`)},
+		"chapters/meta.yaml": {Data: []byte("k: v")},
+		"unused.png":         {Data: []byte("unused")},
+	}
+	template := []byte(`#import "@preview/cetz:0.3.0": canvas
+#import "lib/util.typ": rows
+#let data = json("data.json")
+#image("img/logo.png")
+// #image("commented.png")
+#toml("given.toml")`)
+
+	media, err := ResolveMedia(fsys, template, []MediaFile{{Name: "given.toml", Data: []byte("x = 1")}})
+	if err != nil {
+		t.Fatalf("Failed to resolve media: %v", err)
+	}
+	want := []string{"chapters/intro.typ", "chapters/meta.yaml", "data.json", "img/logo.png", "lib/rows.csv", "lib/util.typ"}
+	if got := mediaNames(media); !slices.Equal(got, want) {
+		t.Errorf("Resolved %v, want %v", got, want)
+	}
+}
+
+func TestResolveMediaUnresolved(t *testing.T) {
+	fsys := fstest.MapFS{"img/logo.png": {Data: []byte("logo")}}
+	template := []byte("#image(\"img/logo.png\")\n#read(\"missing.txt\")\n#image(\"../outside.png\")")
+
+	media, err := ResolveMedia(fsys, template, nil)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("Expected ResolveError, got %v", err)
+	}
+	if got := mediaNames(media); !slices.Equal(got, []string{"img/logo.png"}) {
+		t.Errorf("Resolved %v, want the files that exist", got)
+	}
+	if len(resolveErr.Unresolved) != 2 {
+		t.Fatalf("Expected 2 unresolved references, got %+v", resolveErr.Unresolved)
+	}
+
+	missing := resolveErr.Unresolved[0]
+	if missing.Path != "missing.txt" || missing.Line != 2 || missing.Column != 7 || !errors.Is(missing.Err, fs.ErrNotExist) {
+		t.Errorf("Unexpected unresolved reference %+v", missing)
+	}
+	if outside := resolveErr.Unresolved[1]; outside.Path != "../outside.png" || !errors.Is(outside.Err, errOutsideRoot) {
+		t.Errorf("Unexpected unresolved reference %+v", outside)
+	}
+
+	diags := DiagnosticsFromError(ResponseInfo{}, err)
+	if len(diags) != 2 || diags[0].String() != "main.typ:2:7: error: file not found: missing.txt" {
+		t.Errorf("Unexpected diagnostics %v", diags)
+	}
+}
+
+func TestWithMediaResolver(t *testing.T) {
+	var got map[string]string
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var req typstRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		got = req.Media
+		writePDFResponse(w, fakePDF)
+	})
+
+	fsys := fstest.MapFS{"img/logo.png": {Data: []byte("logo")}, "img/other.png": {Data: []byte("other")}}
+	client, err := New("test-key", server.URL, WithMediaResolver(fsys))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	template := []byte(`#image("img/logo.png") #image("img/other.png")`)
+	if _, err := client.Convert(context.Background(), &buf, "", template, nil, []MediaFile{{Name: "img/other.png", Data: []byte("given")}}); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	if logo, _ := base64.StdEncoding.DecodeString(got["img/logo.png"]); string(logo) != "logo" {
+		t.Errorf("Referenced image not attached: %v", got)
+	}
+	if other, _ := base64.StdEncoding.DecodeString(got["img/other.png"]); string(other) != "given" {
+		t.Errorf("Given media replaced by the resolver: %q", other)
+	}
+
+	got = nil
+	_, err = client.Convert(context.Background(), &buf, "", []byte(`#image("missing.png")`), nil, nil)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("Expected ResolveError, got %v", err)
+	}
+	if got != nil {
+		t.Error("Request sent despite unresolved media")
+	}
+}
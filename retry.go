@@ -0,0 +1,70 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WithRetry retries gateway requests that failed to connect or were answered
+// with 502, 503 or 504, up to attempts tries in total. The wait between tries
+// starts at backoff and doubles after each one.
+//
+// Renders are deterministic, so retrying them is safe; a retried Submit may
+// however queue a job twice.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(c *Client) error {
+		if attempts < 1 {
+			return fmt.Errorf("retry attempts must be at least 1")
+		}
+		if backoff < 0 {
+			return fmt.Errorf("retry backoff cannot be negative")
+		}
+		c.retryAttempts = attempts
+		c.retryBackoff = backoff
+		return nil
+	}
+}
+
+// retryable reports whether a try failed transiently: the gateway could not be
+// reached or answered 502, 503 or 504. Errors building or signing the request
+// would fail again.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var connErr *ConnectionError
+		return errors.As(err, &connErr) && connErr.transport && !isContextError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryRoundTrip performs fn up to the configured number of attempts.
+func (c *Client) retryRoundTrip(ctx context.Context, fn func() (*http.Response, error)) (*http.Response, error) {
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		countAttempt(ctx)
+		resp, err := fn()
+		if attempt >= c.retryAttempts || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &ConnectionError{Err: ctx.Err()}
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
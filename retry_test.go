@@ -0,0 +1,133 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		failures int32
+		attempts int
+		wantErr  bool
+		wantHits int32
+	}{
+		{name: "recovers from 503", status: http.StatusServiceUnavailable, failures: 2, attempts: 3, wantHits: 3},
+		{name: "gives up after attempts", status: http.StatusBadGateway, failures: 5, attempts: 2, wantErr: true, wantHits: 2},
+		{name: "does not retry 500", status: http.StatusInternalServerError, failures: 1, attempts: 3, wantErr: true, wantHits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+				if hits.Add(1) <= tt.failures {
+					http.Error(w, "busy", tt.status)
+					return
+				}
+				writePDFResponse(w, fakePDF)
+			})
+
+			client, err := New("test-key", server.URL, WithRetry(tt.attempts, time.Millisecond))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			var buf bytes.Buffer
+			_, err = client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if hits.Load() != tt.wantHits {
+				t.Errorf("Expected %d requests, got %d", tt.wantHits, hits.Load())
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	})
+
+	client, err := New("test-key", server.URL, WithRetry(5, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	_, err = client.Convert(ctx, &buf, "", []byte("= Hi"), nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+type failingAuth struct{ calls atomic.Int32 }
+
+func (a *failingAuth) Apply(context.Context, *http.Request) error {
+	a.calls.Add(1)
+	return errors.New("no credentials")
+}
+
+func TestRetryOnlyTransportErrors(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		writePDFResponse(w, fakePDF)
+	})
+
+	var tries atomic.Int32
+	flaky := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if tries.Add(1) == 1 {
+			return nil, errors.New("connection reset")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	client, err := New("test-key", server.URL, WithHTTPClient(&http.Client{Transport: flaky}), WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil || tries.Load() != 2 {
+		t.Errorf("Transport error not retried: %d tries, %v", tries.Load(), err)
+	}
+
+	auth := &failingAuth{}
+	client, err = New("", server.URL, WithAuthProvider(auth), WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err == nil || auth.calls.Load() != 1 {
+		t.Errorf("Auth error retried: %d calls, %v", auth.calls.Load(), err)
+	}
+}
+
+func TestMaxRequestSize(t *testing.T) {
+	var hits atomic.Int32
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		writePDFResponse(w, fakePDF)
+	})
+
+	client, err := New("test-key", server.URL, WithMaxRequestSize(256))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	media := []MediaFile{{Name: "big.bin", Data: make([]byte, 1024)}}
+	_, err = client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, media)
+	if !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("Expected ErrRequestTooLarge, got %v", err)
+	}
+	if hits.Load() != 0 {
+		t.Errorf("Expected no request to be sent, got %d", hits.Load())
+	}
+}
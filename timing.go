@@ -0,0 +1,128 @@
+package typstpdfgenerator
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing is the latency breakdown of the render request. Phases that did not
+// happen, such as DNS and TLS on a reused connection, are zero.
+type Timing struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// RequestWrite is the time spent uploading the request once a connection
+	// was available.
+	RequestWrite time.Duration
+	// TimeToFirstByte is measured from the end of the upload, so it covers
+	// the gateway's processing time.
+	TimeToFirstByte time.Duration
+	BodyRead        time.Duration
+	ConnReused      bool
+
+	// ServerTiming holds the metrics of the response's Server-Timing header.
+	ServerTiming map[string]time.Duration
+	// ServerCompile is the "compile" Server-Timing metric, if reported.
+	ServerCompile time.Duration
+}
+
+type timingRecorder struct {
+	mu sync.Mutex
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	gotConn, wroteRequest     time.Time
+	firstByte                 time.Time
+	connReused                bool
+}
+
+func (r *timingRecorder) record(t *time.Time) func() {
+	return func() {
+		r.mu.Lock()
+		*t = time.Now()
+		r.mu.Unlock()
+	}
+}
+
+func (r *timingRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { r.record(&r.dnsStart)() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { r.record(&r.dnsDone)() },
+		ConnectStart:      func(string, string) { r.record(&r.connectStart)() },
+		ConnectDone:       func(string, string, error) { r.record(&r.connectDone)() },
+		TLSHandshakeStart: r.record(&r.tlsStart),
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { r.record(&r.tlsDone)() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.mu.Lock()
+			r.gotConn = time.Now()
+			r.connReused = info.Reused
+			r.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { r.record(&r.wroteRequest)() },
+		GotFirstResponseByte: r.record(&r.firstByte),
+	}
+}
+
+// finish is called once the response body has been read.
+func (r *timingRecorder) finish(serverTiming []string) Timing {
+	done := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := Timing{
+		DNS:             between(r.dnsStart, r.dnsDone),
+		Connect:         between(r.connectStart, r.connectDone),
+		TLSHandshake:    between(r.tlsStart, r.tlsDone),
+		RequestWrite:    between(r.gotConn, r.wroteRequest),
+		TimeToFirstByte: between(r.wroteRequest, r.firstByte),
+		BodyRead:        between(r.firstByte, done),
+		ConnReused:      r.connReused,
+		ServerTiming:    parseServerTiming(serverTiming),
+	}
+	t.ServerCompile = t.ServerTiming["compile"]
+	return t
+}
+
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// parseServerTiming parses Server-Timing header values such as
+// `compile;dur=812.5, total;dur=900;desc="Total"`. Durations are in
+// milliseconds; metrics without one are omitted.
+func parseServerTiming(values []string) map[string]time.Duration {
+	var metrics map[string]time.Duration
+	for _, value := range values {
+		for _, metric := range strings.Split(value, ",") {
+			params := strings.Split(metric, ";")
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+			for _, param := range params[1:] {
+				key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "dur") {
+					continue
+				}
+				ms, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(val), `"`), 64)
+				if err != nil {
+					continue
+				}
+				if metrics == nil {
+					metrics = make(map[string]time.Duration)
+				}
+				metrics[name] = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,80 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseTiming(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Server-Timing", `compile;dur=15.5;desc="typst compile", total;dur=19`)
+		writePDFResponse(w, fakePDF)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := New("test-key", server.URL, WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	info, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	timing := info.Timing
+	if timing.Connect <= 0 || timing.TLSHandshake <= 0 {
+		t.Errorf("Expected connect and TLS phases on a new connection: %+v", timing)
+	}
+	if timing.TimeToFirstByte < 20*time.Millisecond {
+		t.Errorf("TimeToFirstByte = %v, want at least the server delay", timing.TimeToFirstByte)
+	}
+	if timing.ServerCompile != 15500*time.Microsecond || timing.ServerTiming["total"] != 19*time.Millisecond {
+		t.Errorf("Unexpected server timing: %v", timing.ServerTiming)
+	}
+
+	info, err = client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil)
+	if err != nil {
+		t.Fatalf("Second Convert failed: %v", err)
+	}
+	if !info.Timing.ConnReused || info.Timing.TLSHandshake != 0 {
+		t.Errorf("Expected a reused connection without handshake: %+v", info.Timing)
+	}
+}
+
+func TestParseServerTiming(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   map[string]time.Duration
+	}{
+		{name: "none", values: nil, want: nil},
+		{name: "single", values: []string{"compile;dur=100"}, want: map[string]time.Duration{"compile": 100 * time.Millisecond}},
+		{
+			name:   "multiple headers and metrics",
+			values: []string{`cache;desc="miss"`, `db;dur=2.5, compile;desc="x";dur=40`},
+			want:   map[string]time.Duration{"db": 2500 * time.Microsecond, "compile": 40 * time.Millisecond},
+		},
+		{name: "malformed duration", values: []string{"compile;dur=abc"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseServerTiming(tt.values)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package typstpdfgenerator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// configureTLS passes a copy of the client's TLS configuration to fn and
+// installs the configuration fn returns. The transport is cloned so that
+// http.DefaultTransport and transports shared with other clients are never
+// modified.
+func (c *Client) configureTLS(setting string, fn func(*tls.Config) (*tls.Config, error)) error {
+	if c.httpClient.Transport == nil {
+		// http.Client treats nil Transport as http.DefaultTransport.
+		c.httpClient.Transport = http.DefaultTransport
+	}
+
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot enable %s with non-*http.Transport (%T)", setting, c.httpClient.Transport)
+	}
+
+	cloned := transport.Clone()
+	if cloned.TLSClientConfig == nil {
+		cloned.TLSClientConfig = &tls.Config{}
+	}
+	cfg, err := fn(cloned.TLSClientConfig)
+	if err != nil {
+		return err
+	}
+	cloned.TLSClientConfig = cfg
+	c.httpClient.Transport = cloned
+	return nil
+}
+
+// WithTLSConfig replaces the transport's TLS configuration with a clone of cfg.
+// Options applied afterwards refine it.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) error {
+		if cfg == nil {
+			return fmt.Errorf("TLS config cannot be nil")
+		}
+		return c.configureTLS("TLSConfig", func(*tls.Config) (*tls.Config, error) {
+			return cfg.Clone(), nil
+		})
+	}
+}
+
+// WithRootCAs verifies the gateway against pool instead of the system roots.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) error {
+		if pool == nil {
+			return fmt.Errorf("root CA pool cannot be nil")
+		}
+		return c.configureTLS("RootCAs", func(cfg *tls.Config) (*tls.Config, error) {
+			cfg.RootCAs = pool
+			return cfg, nil
+		})
+	}
+}
+
+// WithCAFile verifies the gateway against the PEM certificates in path, e.g.
+// a private CA.
+func WithCAFile(path string) Option {
+	return func(c *Client) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in CA file: %s", path)
+		}
+		return WithRootCAs(pool)(c)
+	}
+}
+
+// WithClientCertificate presents the PEM certificate and key for mutual TLS.
+//
+// The files are reloaded when they change on disk, so rotated certificates are
+// picked up by new connections without restarting.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *Client) error {
+		reloader, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		return c.configureTLS("ClientCertificate", func(cfg *tls.Config) (*tls.Config, error) {
+			cfg.Certificates = nil
+			cfg.GetClientCertificate = reloader.GetClientCertificate
+			return cfg, nil
+		})
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+type certReloader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certStamp fileStamp
+	keyStamp  fileStamp
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	certStamp, err := statFile(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat client certificate: %w", err)
+	}
+	keyStamp, err := statFile(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat client key: %w", err)
+	}
+	if r.cert != nil && certStamp == r.certStamp && keyStamp == r.keyStamp {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	r.cert = &cert
+	r.certStamp = certStamp
+	r.keyStamp = keyStamp
+	return nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it first if
+// the files changed. A failed reload keeps the previous certificate, since the
+// files may be caught halfway through a rotation.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.reload(); err != nil && r.cert == nil {
+		return nil, err
+	}
+	return r.cert, nil
+}
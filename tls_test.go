@@ -0,0 +1,176 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate to dir/client.crt
+// and dir/client.key and returns it.
+func writeClientCert(t *testing.T, dir, commonName string, serial int64) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "client.crt"), certPEM, 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "client.key"), keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	// Make the rotation visible even on filesystems with coarse timestamps.
+	mtime := time.Now().Add(time.Duration(serial) * time.Second)
+	for _, name := range []string{"client.crt", "client.key"} {
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestMutualTLSWithCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	first := writeClientCert(t, dir, "client-1", 1)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(first)
+
+	var peer string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.PeerCertificates[0].Subject.CommonName
+		writePDFResponse(w, fakePDF)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	client, err := New("test-key", server.URL,
+		WithCAFile(caFile),
+		WithClientCertificate(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if peer != "client-1" {
+		t.Errorf("Server saw client %q, want client-1", peer)
+	}
+
+	second := writeClientCert(t, dir, "client-2", 2)
+	clientCAs.AddCert(second)
+	client.httpClient.Transport.(*http.Transport).CloseIdleConnections()
+
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert after rotation failed: %v", err)
+	}
+	if peer != "client-2" {
+		t.Errorf("Server saw client %q after rotation, want client-2", peer)
+	}
+}
+
+func TestTLSOptionsDoNotModifySharedTransport(t *testing.T) {
+	shared := &http.Transport{}
+	pool := x509.NewCertPool()
+
+	_, err := New("test-key", "https://example.com/function/typst",
+		WithHTTPClient(&http.Client{Transport: shared}),
+		WithRootCAs(pool),
+		WithInsecureSkipVerify(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	// Clone may set up HTTP/2 defaults on the original, but must not leak
+	// our settings into it.
+	if cfg := shared.TLSClientConfig; cfg != nil && (cfg.InsecureSkipVerify || cfg.RootCAs != nil) {
+		t.Error("Shared transport was modified")
+	}
+}
+
+func TestWithTLSConfigInstallsClone(t *testing.T) {
+	cfg := &tls.Config{ServerName: "gateway.internal"}
+	client, err := New("test-key", "https://example.com/function/typst",
+		WithTLSConfig(cfg),
+		WithInsecureSkipVerify(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	got := client.httpClient.Transport.(*http.Transport).TLSClientConfig
+	if got == cfg || got.ServerName != "gateway.internal" || !got.InsecureSkipVerify {
+		t.Errorf("Unexpected TLS config %+v", got)
+	}
+	if cfg.InsecureSkipVerify {
+		t.Error("Caller's TLS config was modified")
+	}
+}
+
+func TestTLSOptionsRejectCustomRoundTripper(t *testing.T) {
+	custom := roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, nil })
+	_, err := New("test-key", "https://example.com/function/typst",
+		WithHTTPClient(&http.Client{Transport: custom}),
+		WithRootCAs(x509.NewCertPool()),
+	)
+	if err == nil {
+		t.Error("Expected error for non-*http.Transport")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,192 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Tracer creates spans. Implementations typically adapt an OpenTelemetry
+// tracer; the client only needs the span's W3C trace context to propagate it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+	SpanContext() SpanContext
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// SpanContext is the W3C trace context of a span.
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Sampled    bool
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the context as a traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceParent parses a traceparent header value.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return sc, fmt.Errorf("invalid trace ID in traceparent %q", value)
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return sc, fmt.Errorf("invalid span ID in traceparent %q", value)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid flags in traceparent %q", value)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	return sc, nil
+}
+
+// WithTracer creates a "typst.convert" span for every conversion and
+// propagates it to the gateway in the traceparent and tracestate headers.
+func WithTracer(tracer Tracer) Option {
+	return func(c *Client) error {
+		c.tracer = tracer
+		return nil
+	}
+}
+
+type spanContextKey struct{}
+
+type activeSpan struct {
+	span     Span
+	attempts atomic.Int32
+}
+
+func (c *Client) startSpan(ctx context.Context, correlationID string, templateData []byte) (context.Context, func(ResponseInfo, error)) {
+	ctx, span := c.tracer.Start(ctx, "typst.convert")
+	active := &activeSpan{span: span}
+	ctx = context.WithValue(ctx, spanContextKey{}, active)
+
+	templateSum := sha256.Sum256(templateData)
+	span.SetAttributes(
+		Attribute{Key: "typst.correlation_id", Value: correlationID},
+		Attribute{Key: "typst.template.sha256", Value: hex.EncodeToString(templateSum[:])},
+		Attribute{Key: "typst.template.bytes", Value: len(templateData)},
+	)
+
+	return ctx, func(info ResponseInfo, err error) {
+		attrs := []Attribute{
+			{Key: "typst.attempts", Value: int(active.attempts.Load())},
+			{Key: "http.response.status_code", Value: info.StatusCode},
+			{Key: "typst.request.bytes", Value: info.RequestBytes},
+		}
+		if info.LeaderCorrelationID != "" {
+			attrs = append(attrs, Attribute{Key: "typst.coalesced_with", Value: info.LeaderCorrelationID})
+		}
+		span.SetAttributes(attrs...)
+		if err != nil {
+			span.SetAttributes(Attribute{Key: "error.type", Value: ErrorClass(err)})
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// mediaAttributes describes the media sent with a conversion.
+func mediaAttributes(media []MediaFile) []Attribute {
+	var mediaBytes int
+	for _, m := range media {
+		mediaBytes += len(m.Data)
+	}
+	return []Attribute{
+		{Key: "typst.media.count", Value: len(media)},
+		{Key: "typst.media.bytes", Value: mediaBytes},
+	}
+}
+
+// annotateSpan adds attributes to the conversion span in ctx, if any.
+func annotateSpan(ctx context.Context, attrs ...Attribute) {
+	if active, ok := ctx.Value(spanContextKey{}).(*activeSpan); ok {
+		active.span.SetAttributes(attrs...)
+	}
+}
+
+type renderAttemptKey struct{}
+
+// withRenderAttempts marks ctx as sending the render request, whose tries are
+// counted as attempts on the conversion span. Probes, media store lookups and
+// job requests are not.
+func withRenderAttempts(ctx context.Context, count bool) context.Context {
+	return context.WithValue(ctx, renderAttemptKey{}, count)
+}
+
+// countAttempt counts a try of the render request on the conversion span in
+// ctx, if any.
+func countAttempt(ctx context.Context) {
+	if count, _ := ctx.Value(renderAttemptKey{}).(bool); !count {
+		return
+	}
+	if active, ok := ctx.Value(spanContextKey{}).(*activeSpan); ok {
+		active.attempts.Add(1)
+	}
+}
+
+// injectTraceContext sets the W3C trace headers for the conversion span in ctx.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	active, ok := ctx.Value(spanContextKey{}).(*activeSpan)
+	if !ok {
+		return
+	}
+
+	sc := active.span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	req.Header.Set("traceparent", sc.TraceParent())
+	if sc.TraceState != "" {
+		req.Header.Set("tracestate", sc.TraceState)
+	}
+}
+
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// countPDFPages counts page objects in an uncompressed-xref PDF as produced by
+// typst. It returns 0 when pages are hidden in compressed object streams.
+func countPDFPages(pdf []byte) int {
+	return len(pdfPageObject.FindAllIndex(pdf, -1))
+}
@@ -0,0 +1,175 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSpan struct {
+	mu    sync.Mutex
+	sc    SpanContext
+	attrs map[string]any
+	err   error
+	ended bool
+}
+
+func (s *fakeSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *fakeSpan) RecordError(err error) { s.err = err }
+func (s *fakeSpan) End()                  { s.ended = true }
+func (s *fakeSpan) SpanContext() SpanContext {
+	return s.sc
+}
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &fakeSpan{attrs: map[string]any{}, sc: SpanContext{Sampled: true, TraceState: "vendor=1"}}
+	span.sc.TraceID[0] = 0xab
+	span.sc.SpanID[0] = byte(len(t.spans) + 1)
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracerPropagatesTraceContext(t *testing.T) {
+	pdf := "%PDF-1.7\n1 0 obj << /Type /Pages /Count 2 >>\n2 0 obj << /Type /Page >>\n3 0 obj << /Type/Page >>\n%%EOF"
+
+	var traceparent, tracestate string
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		tracestate = r.Header.Get("tracestate")
+		_ = json.NewEncoder(w).Encode(typstResponse{PDF: base64.StdEncoding.EncodeToString([]byte(pdf))})
+	})
+
+	tracer := &fakeTracer{}
+	client, err := New("test-key", server.URL, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	media := []MediaFile{{Name: "a.json", Data: []byte("{}")}}
+	info, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, media)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if info.Pages != 2 {
+		t.Errorf("Expected 2 pages in response info, got %d", info.Pages)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if !span.ended {
+		t.Error("Span was not ended")
+	}
+	if traceparent != span.sc.TraceParent() || tracestate != "vendor=1" {
+		t.Errorf("Unexpected trace headers: traceparent=%q tracestate=%q", traceparent, tracestate)
+	}
+	if span.attrs["typst.pages"] != 2 || span.attrs["typst.media.bytes"] != 2 || span.attrs["typst.attempts"] != 1 {
+		t.Errorf("Unexpected attributes: %v", span.attrs)
+	}
+	if _, ok := span.attrs["typst.template.sha256"]; !ok {
+		t.Error("Missing template hash attribute")
+	}
+}
+
+func TestTracerCountsRenderAttempts(t *testing.T) {
+	renders := 0
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/capabilities" {
+			_ = json.NewEncoder(w).Encode(Capabilities{OutputFormats: []string{"pdf"}})
+			return
+		}
+		renders++
+		if renders == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writePDFResponse(w, fakePDF)
+	})
+
+	tracer := &fakeTracer{}
+	client, err := New("test-key", server.URL, WithTracer(tracer), WithCapabilityCheck(time.Minute), WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	// The capabilities probe is not an attempt, the retried render is.
+	if got := tracer.spans[0].attrs["typst.attempts"]; got != 2 {
+		t.Errorf("typst.attempts = %v, want 2", got)
+	}
+}
+
+func TestTracerRecordsErrors(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	})
+
+	tracer := &fakeTracer{}
+	client, err := New("test-key", server.URL, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Convert(context.Background(), &buf, "", []byte("= Hi"), nil, nil); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	span := tracer.spans[0]
+	if span.err == nil || span.attrs["error.type"] != "http_500" || !span.ended {
+		t.Errorf("Unexpected span: err=%v attrs=%v ended=%v", span.err, span.attrs, span.ended)
+	}
+
+	if _, err := client.Convert(context.Background(), &buf, "", []byte(`#image("logo.png")`), nil, []MediaFile{{Name: "/logo.png"}}); err == nil {
+		t.Fatal("Expected error")
+	}
+	span = tracer.spans[1]
+	if span.err == nil || span.attrs["error.type"] != "invalid_media" || !span.ended {
+		t.Errorf("Failure before sending not traced: err=%v attrs=%v ended=%v", span.err, span.attrs, span.ended)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if !sc.Sampled || sc.TraceParent() != valid {
+		t.Errorf("Round trip mismatch: %+v -> %s", sc, sc.TraceParent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceParent(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
@@ -9,9 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -37,11 +41,24 @@ func CorrelationIDFromContext(ctx context.Context) string {
 	return v
 }
 
+// contextCorrelationID returns the caller's correlation ID, or a fresh one.
+func contextCorrelationID(ctx context.Context) string {
+	if correlationID := CorrelationIDFromContext(ctx); correlationID != "" {
+		return correlationID
+	}
+	return uuid.NewString()
+}
+
 var (
-	ErrNotGenerated   = errors.New("PDF not generated")
-	ErrConnection     = errors.New("connection error")
-	ErrInvalidAuth    = errors.New("auth key cannot be empty")
-	ErrInvalidGateway = errors.New("FaaS gateway cannot be empty")
+	ErrNotGenerated     = errors.New("PDF not generated")
+	ErrConnection       = errors.New("connection error")
+	ErrInvalidAuth      = errors.New("auth key cannot be empty")
+	ErrInvalidGateway   = errors.New("FaaS gateway cannot be empty")
+	ErrUnsupported      = errors.New("unsupported by gateway")
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrRequestTooLarge  = errors.New("request too large")
+	ErrInvalidConfig    = errors.New("invalid configuration")
+	ErrInvalidMedia     = errors.New("invalid media")
 )
 
 type NotGeneratedError struct {
@@ -63,6 +80,10 @@ func (e *NotGeneratedError) Unwrap() error {
 type ConnectionError struct {
 	Message string
 	Err     error
+
+	// transport is set when the HTTP exchange itself failed, the only
+	// connection errors worth retrying.
+	transport bool
 }
 
 func (e *ConnectionError) Error() string {
@@ -117,10 +138,37 @@ type MediaFile struct {
 	Data []byte
 }
 
+// Request describes a conversion; its fields mirror the arguments of Convert.
+type Request struct {
+	Content  string
+	Template []byte
+	Options  []string
+	Media    []MediaFile
+}
+
 type ResponseInfo struct {
 	Stdout        string
 	Stderr        string
 	CorrelationID string
+	// StatusCode is the HTTP status of the gateway response, or zero if none
+	// was received.
+	StatusCode int
+	// RequestBytes and ResponseBytes count the HTTP bodies exchanged with the
+	// gateway for the final render request. They are zero for conversions
+	// that shared another caller's request (see WithRequestCoalescing).
+	RequestBytes  int
+	ResponseBytes int
+	// Timing breaks down where the time of the render request was spent.
+	Timing Timing
+	// Pages is the number of pages in the PDF, or zero when it cannot be
+	// determined without fully parsing the document.
+	Pages int
+	// LeaderCorrelationID is set when the result was shared from an identical
+	// in-flight conversion started by another caller (see WithRequestCoalescing).
+	LeaderCorrelationID string
+	// MediaBytesSaved is the size by which media transformers shrank the media
+	// (see WithMediaTransformers).
+	MediaBytesSaved int
 }
 
 type typstRequest struct {
@@ -128,6 +176,9 @@ type typstRequest struct {
 	Template string            `json:"template"`
 	Options  []string          `json:"options"`
 	Media    map[string]string `json:"media"`
+	// MediaRefs maps media names to content digests already held by the
+	// gateway's media store (see WithMediaStore).
+	MediaRefs map[string]string `json:"media_refs,omitempty"`
 }
 
 type typstResponse struct {
@@ -139,9 +190,33 @@ type typstResponse struct {
 }
 
 type Client struct {
-	authKey    string
+	auth       AuthProvider
 	gateway    *url.URL
 	httpClient *http.Client
+	flights    *flightGroup
+	mediaStore *mediaStore
+	caps       *capabilityCache
+	logger     *slog.Logger
+	redact     func(content string) string
+	observers  []Observer
+	tracer     Tracer
+	registry   *Registry
+
+	jobWebhook      string
+	jobPollInterval time.Duration
+
+	defaultOptions  []string
+	maxRequestBytes int
+	retryAttempts   int
+	retryBackoff    time.Duration
+
+	mediaFS       fs.FS
+	mediaFoldCase bool
+	fonts         *FontSet
+	transformers  []MediaTransformer
+
+	packageFS   fs.FS
+	packageLock *Lockfile
 }
 
 func correlationIDFromResponse(resp *http.Response) string {
@@ -178,30 +253,16 @@ func WithHTTPClient(client *http.Client) Option {
 
 func WithInsecureSkipVerify() Option {
 	return func(c *Client) error {
-		if c.httpClient.Transport == nil {
-			// http.Client treats nil Transport as http.DefaultTransport.
-			c.httpClient.Transport = http.DefaultTransport
-		}
-
-		transport, ok := c.httpClient.Transport.(*http.Transport)
-		if !ok {
-			return fmt.Errorf("cannot enable InsecureSkipVerify with non-*http.Transport (%T)", c.httpClient.Transport)
-		}
-
-		cloned := transport.Clone()
-		if cloned.TLSClientConfig == nil {
-			cloned.TLSClientConfig = &tls.Config{}
-		}
-		cloned.TLSClientConfig.InsecureSkipVerify = true
-		c.httpClient.Transport = cloned
-		return nil
+		return c.configureTLS("InsecureSkipVerify", func(cfg *tls.Config) (*tls.Config, error) {
+			cfg.InsecureSkipVerify = true
+			return cfg, nil
+		})
 	}
 }
 
+// New creates a client for the gateway at faasGateway. authKey is sent verbatim
+// in the Authorization header; it may be empty when WithAuthProvider is used.
 func New(authKey, faasGateway string, opts ...Option) (*Client, error) {
-	if authKey == "" {
-		return nil, ErrInvalidAuth
-	}
 	if faasGateway == "" {
 		return nil, ErrInvalidGateway
 	}
@@ -216,8 +277,9 @@ func New(authKey, faasGateway string, opts ...Option) (*Client, error) {
 	}
 
 	client := &Client{
-		authKey: authKey,
-		gateway: gatewayURL,
+		gateway:         gatewayURL,
+		jobPollInterval: 5 * time.Second,
+		retryAttempts:   1,
 		httpClient: &http.Client{
 			Timeout: 120 * time.Second,
 			Transport: &http.Transport{
@@ -229,77 +291,251 @@ func New(authKey, faasGateway string, opts ...Option) (*Client, error) {
 		},
 	}
 
+	if authKey != "" {
+		client.auth, err = StaticAuth(authKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, opt := range opts {
 		if err := opt(client); err != nil {
 			return nil, err
 		}
 	}
 
+	if client.auth == nil {
+		return nil, ErrInvalidAuth
+	}
+
 	return client, nil
 }
 
-func (c *Client) Convert(ctx context.Context, w io.Writer, content string, templateData []byte, options []string, media []MediaFile) (ResponseInfo, error) {
-	correlationID := CorrelationIDFromContext(ctx)
-	if correlationID == "" {
-		correlationID = uuid.NewString()
-	}
-
-	defaultOptions := []string{
+// DefaultOptions returns the typst CLI options used when a conversion is
+// given none.
+func DefaultOptions() []string {
+	return []string{
 		"--ignore-system-fonts",
 		"--font-path=fonts",
 		"--diagnostic-format=short",
 	}
-	if len(options) == 0 {
-		options = defaultOptions
+}
+
+// WithDefaultOptions replaces DefaultOptions for conversions given no
+// options.
+func WithDefaultOptions(options ...string) Option {
+	return func(c *Client) error {
+		if len(options) == 0 {
+			return fmt.Errorf("default options cannot be empty")
+		}
+		c.defaultOptions = slices.Clone(options)
+		return nil
 	}
+}
 
-	info := ResponseInfo{CorrelationID: correlationID}
+// WithMaxRequestSize rejects conversions whose encoded request exceeds
+// maxBytes with ErrRequestTooLarge before anything is sent.
+func WithMaxRequestSize(maxBytes int) Option {
+	return func(c *Client) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("max request size must be positive")
+		}
+		c.maxRequestBytes = maxBytes
+		return nil
+	}
+}
 
+// resolveOptions returns the typst CLI options to send, falling back to the
+// defaults when none are given.
+func (c *Client) resolveOptions(options []string) []string {
+	if len(options) > 0 {
+		return options
+	}
+	if c.defaultOptions != nil {
+		return c.defaultOptions
+	}
+	return DefaultOptions()
+}
+
+func newTypstRequest(content string, templateData []byte, options []string, media []MediaFile) typstRequest {
 	mediaEncoded := make(map[string]string, len(media))
 	for _, m := range media {
 		mediaEncoded[m.Name] = base64.StdEncoding.EncodeToString(m.Data)
 	}
 
-	reqBody := typstRequest{
+	return typstRequest{
 		Content:  content,
 		Template: base64.StdEncoding.EncodeToString(templateData),
 		Options:  options,
 		Media:    mediaEncoded,
 	}
+}
+
+func (c *Client) Convert(ctx context.Context, w io.Writer, content string, templateData []byte, options []string, media []MediaFile) (info ResponseInfo, err error) {
+	correlationID := contextCorrelationID(ctx)
+
+	if c.tracer != nil {
+		var finish func(ResponseInfo, error)
+		ctx, finish = c.startSpan(ctx, correlationID, templateData)
+		defer func() { finish(info, err) }()
+	}
+
+	start := time.Now()
+	for _, o := range c.observers {
+		o.ConversionStarted(ctx, StartEvent{CorrelationID: correlationID, TemplateBytes: len(templateData), MediaCount: len(media)})
+	}
+
+	// Failures before sending are reported with the options and media given.
+	options = c.resolveOptions(options)
+	req, err := c.prepare(ctx, content, templateData, options, media)
+	if err != nil {
+		info = ResponseInfo{CorrelationID: correlationID}
+	} else {
+		options, media = req.options, req.media
+		annotateSpan(ctx, mediaAttributes(media)...)
+		info, err = c.convert(ctx, w, correlationID, req)
+		info.MediaBytesSaved = req.mediaBytesSaved
+	}
+
+	latency := time.Since(start)
+	for _, o := range c.observers {
+		o.ConversionFinished(ctx, FinishEvent{
+			CorrelationID: info.CorrelationID,
+			Latency:       latency,
+			RequestBytes:  info.RequestBytes,
+			ResponseBytes: info.ResponseBytes,
+			StatusCode:    info.StatusCode,
+			ErrorClass:    ErrorClass(err),
+			Err:           err,
+		})
+	}
+	if c.logger != nil {
+		c.logConversion(ctx, latency, info, content, templateData, options, media, err)
+	}
+	return info, err
+}
+
+// preparedRequest is a conversion ready to be sent.
+type preparedRequest struct {
+	options         []string
+	media           []MediaFile
+	body            typstRequest
+	json            []byte
+	mediaBytesSaved int
+}
 
-	jsonData, err := json.Marshal(reqBody)
+// prepare runs the client-side steps shared by Convert and Submit on resolved
+// options: it attaches discovered media, fonts and packages, validates the
+// media names, applies the media transformers, and encodes the request and
+// checks it against the size limit and the gateway's capabilities.
+func (c *Client) prepare(ctx context.Context, content string, templateData []byte, options []string, media []MediaFile) (*preparedRequest, error) {
+	media, err := c.resolveMedia(templateData, media)
 	if err != nil {
-		return info, &ConnectionError{Err: err}
+		return nil, err
+	}
+	media, err = c.attachFonts(templateData, options, media)
+	if err != nil {
+		return nil, err
+	}
+	options, media, err = c.vendorPackages(templateData, options, media)
+	if err != nil {
+		return nil, err
+	}
+	media, err = NormalizeMedia(media, c.mediaFoldCase)
+	if err != nil {
+		return nil, err
+	}
+	media, saved, err := c.transformMedia(media)
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.gateway.String(), bytes.NewBuffer(jsonData))
+	body := newTypstRequest(content, templateData, options, media)
+	jsonData, err := json.Marshal(body)
 	if err != nil {
-		return info, &ConnectionError{Err: err}
+		return nil, &ConnectionError{Err: err}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", c.authKey)
-	req.Header.Set("X-Correlation-ID", correlationID)
+	if c.maxRequestBytes > 0 && len(jsonData) > c.maxRequestBytes {
+		return nil, fmt.Errorf("%w: request is %d bytes, limit is %d", ErrRequestTooLarge, len(jsonData), c.maxRequestBytes)
+	}
 
-	resp, err := c.httpClient.Do(req)
+	if c.caps != nil {
+		if err := c.checkCapabilities(ctx, templateData, options, media, len(jsonData)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &preparedRequest{options: options, media: media, body: body, json: jsonData, mediaBytesSaved: saved}, nil
+}
+
+func (c *Client) convert(ctx context.Context, w io.Writer, correlationID string, req *preparedRequest) (ResponseInfo, error) {
+	var (
+		pdfData []byte
+		info    ResponseInfo
+		err     error
+	)
+	if c.flights != nil {
+		pdfData, info, err = c.flights.do(ctx, requestKey(req.json), correlationID, func() ([]byte, ResponseInfo, error) {
+			return c.exchange(ctx, correlationID, req.body, req.json, req.media)
+		})
+	} else {
+		pdfData, info, err = c.exchange(ctx, correlationID, req.body, req.json, req.media)
+	}
 	if err != nil {
-		return info, &ConnectionError{Err: err}
+		return info, err
+	}
+	info.Pages = countPDFPages(pdfData)
+	annotateSpan(ctx, Attribute{Key: "typst.pages", Value: info.Pages})
+
+	if _, err := w.Write(pdfData); err != nil {
+		return info, fmt.Errorf("failed to write PDF data: %w", err)
+	}
+
+	return info, nil
+}
+
+// send performs a single gateway round trip for an encoded typstRequest and
+// returns the decoded PDF.
+func (c *Client) send(ctx context.Context, correlationID string, jsonData []byte) ([]byte, ResponseInfo, error) {
+	return c.result(withRenderAttempts(ctx, true), http.MethodPost, c.gateway.String(), correlationID, jsonData)
+}
+
+// result performs a request whose response is a typstResponse, i.e. a render
+// or the result of an asynchronous job, and returns the decoded PDF.
+func (c *Client) result(ctx context.Context, method, endpoint, correlationID string, body []byte) ([]byte, ResponseInfo, error) {
+	info := ResponseInfo{CorrelationID: correlationID}
+
+	timing := &timingRecorder{}
+	ctx = httptrace.WithClientTrace(ctx, timing.clientTrace())
+
+	resp, err := c.roundTrip(ctx, method, endpoint, correlationID, body)
+	if err != nil {
+		return nil, info, err
 	}
 	defer resp.Body.Close()
 
+	info.StatusCode = resp.StatusCode
+	info.RequestBytes = len(body)
+	if c.mediaStore != nil && method == http.MethodPost {
+		c.mediaStore.observe(resp)
+	}
+
 	if serverCorrelationID := correlationIDFromResponse(resp); serverCorrelationID != "" {
 		correlationID = serverCorrelationID
 		info.CorrelationID = serverCorrelationID
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return info, &ConnectionError{Err: err}
+		return nil, info, &ConnectionError{Err: err}
 	}
+	info.ResponseBytes = len(respBody)
+	info.Timing = timing.finish(resp.Header.Values("Server-Timing"))
 
 	var response typstResponse
-	if len(body) > 0 {
-		_ = json.Unmarshal(body, &response)
+	if len(respBody) > 0 {
+		_ = json.Unmarshal(respBody, &response)
 	}
 	info.Stdout = response.Stdout
 	info.Stderr = response.Stderr
@@ -307,19 +543,19 @@ func (c *Client) Convert(ctx context.Context, w io.Writer, content string, templ
 	if resp.StatusCode != http.StatusOK {
 		msg := strings.TrimSpace(response.Message)
 		if msg == "" {
-			msg = strings.TrimSpace(string(body))
+			msg = strings.TrimSpace(string(respBody))
 		}
 		if msg != "" {
 			if len(msg) > 1024 {
 				msg = msg[:1024] + "..."
 			}
-			return info, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: msg, CorrelationID: correlationID}
+			return nil, info, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: msg, CorrelationID: correlationID}
 		}
-		return info, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, CorrelationID: correlationID}
+		return nil, info, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, CorrelationID: correlationID}
 	}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return info, &ConnectionError{Err: err}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, info, &ConnectionError{Err: err}
 	}
 	info.Stdout = response.Stdout
 	info.Stderr = response.Stderr
@@ -329,23 +565,129 @@ func (c *Client) Convert(ctx context.Context, w io.Writer, content string, templ
 		if msg == "" {
 			msg = "Unknown error"
 		}
-		return info, &NotGeneratedError{Message: msg, CorrelationID: correlationID}
+		return nil, info, &NotGeneratedError{Message: msg, CorrelationID: correlationID}
 	}
 
 	if response.PDF == "" {
-		return info, &NotGeneratedError{Message: "No PDF data in response", CorrelationID: correlationID}
+		return nil, info, &NotGeneratedError{Message: "No PDF data in response", CorrelationID: correlationID}
 	}
 
 	pdfData, err := base64.StdEncoding.DecodeString(response.PDF)
 	if err != nil {
-		return info, fmt.Errorf("failed to decode PDF data: %w", err)
+		return nil, info, fmt.Errorf("failed to decode PDF data: %w", err)
 	}
 
-	if _, err := w.Write(pdfData); err != nil {
-		return info, fmt.Errorf("failed to write PDF data: %w", err)
+	return pdfData, info, nil
+}
+
+// roundTrip sends an authenticated request to the gateway. If the gateway
+// rejects the credentials and the auth provider can refresh them, the request
+// is retried once. Transient failures are retried as configured by WithRetry.
+func (c *Client) roundTrip(ctx context.Context, method, endpoint, correlationID string, body []byte) (*http.Response, error) {
+	resp, err := c.retryRoundTrip(ctx, func() (*http.Response, error) {
+		return c.doRequest(ctx, method, endpoint, correlationID, body)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return info, nil
+	refresher, ok := c.auth.(Refresher)
+	if resp.StatusCode != http.StatusUnauthorized || !ok {
+		return resp, nil
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if err := refresher.Refresh(ctx); err != nil {
+		return nil, fmt.Errorf("failed to refresh auth: %w", err)
+	}
+	// The retry with fresh credentials is not another attempt at the render.
+	ctx = withRenderAttempts(ctx, false)
+	return c.retryRoundTrip(ctx, func() (*http.Response, error) {
+		return c.doRequest(ctx, method, endpoint, correlationID, body)
+	})
+}
+
+func (c *Client) doRequest(ctx context.Context, method, endpoint, correlationID string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, &ConnectionError{Err: err}
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Correlation-ID", correlationID)
+	injectTraceContext(ctx, req)
+	if err := c.auth.Apply(ctx, req); err != nil {
+		return nil, fmt.Errorf("failed to apply auth: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if c.logger != nil {
+		c.logRequest(ctx, req, resp, time.Since(start), err)
+	}
+	if err != nil {
+		return nil, &ConnectionError{Err: err, transport: true}
+	}
+	return resp, nil
+}
+
+// endpoint returns the URL of a protocol extension resource below the gateway.
+func (c *Client) endpoint(elem ...string) string {
+	return c.gateway.JoinPath(elem...).String()
+}
+
+// doJSON performs an authenticated request against a protocol extension
+// endpoint, encoding in (when non-nil) and decoding the response into out
+// (when non-nil).
+func (c *Client) doJSON(ctx context.Context, method, endpoint, correlationID string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return &ConnectionError{Err: err}
+		}
+	}
+
+	resp, err := c.roundTrip(ctx, method, endpoint, correlationID, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if serverCorrelationID := correlationIDFromResponse(resp); serverCorrelationID != "" {
+		correlationID = serverCorrelationID
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &ConnectionError{Err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := strings.TrimSpace(string(respBody))
+		if len(msg) > 1024 {
+			msg = msg[:1024] + "..."
+		}
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: msg, CorrelationID: correlationID}
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &ConnectionError{Err: err}
+	}
+	return nil
 }
 
 func (c *Client) GeneratePDFFromFile(ctx context.Context, w io.Writer, content, templateFilePath string, options []string, media []MediaFile) (ResponseInfo, error) {
@@ -0,0 +1,242 @@
+package typstpdfgenerator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Watcher re-renders a template whenever it or its media change on disk,
+// which makes iterating on a template through the gateway practical.
+//
+// Changes are detected with PollChanges, so editors saving several files
+// trigger a single render.
+type Watcher struct {
+	Client   *Client
+	Template string
+	// Media lists media files and directories, loaded with LoadMedia.
+	Media   []string
+	Content string
+	Options []string
+	// Output is replaced atomically after every successful render; a failed
+	// render leaves the previous PDF in place. It may be below a media
+	// directory, it is neither watched nor sent as media.
+	Output string
+
+	// Interval and Debounce are passed to PollChanges.
+	Interval time.Duration
+	Debounce time.Duration
+
+	// OnRender, if set, is called after every render attempt.
+	OnRender func(WatchEvent)
+}
+
+// WatchEvent reports the outcome of a render triggered by a Watcher.
+type WatchEvent struct {
+	// Changed lists the files that triggered the render; it is empty for
+	// the initial render.
+	Changed     []string
+	Info        ResponseInfo
+	Err         error
+	Diagnostics []Diagnostic
+	Duration    time.Duration
+	Bytes       int
+}
+
+// Run renders once and then on every change until ctx is done, returning
+// ctx's error.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.Client == nil {
+		return fmt.Errorf("watcher client cannot be nil")
+	}
+	if w.Template == "" || w.Output == "" {
+		return fmt.Errorf("watcher template and output must be set")
+	}
+
+	// The output may be written below a media directory; writing it must not
+	// trigger another render.
+	paths := append([]string{w.Template}, w.Media...)
+	return pollChanges(ctx, paths, w.isOutput, w.Interval, w.Debounce, func(changed []string) {
+		w.render(ctx, changed)
+	})
+}
+
+// isOutput reports whether path is the output file or one of the temporary
+// files it is written through.
+func (w *Watcher) isOutput(path string) bool {
+	output, err := filepath.Abs(w.Output)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if path == output {
+		return true
+	}
+	return filepath.Dir(path) == filepath.Dir(output) &&
+		strings.HasPrefix(filepath.Base(path), "."+filepath.Base(output)+".tmp-")
+}
+
+// PollChanges calls fn once with no changed files and then with the changed
+// files every time files below paths change, until ctx is done. It returns
+// ctx's error.
+//
+// Changes are detected by polling modification times and sizes every interval
+// (500ms if zero); fn is only called once the files have been unchanged for
+// debounce (200ms if zero).
+func PollChanges(ctx context.Context, paths []string, interval, debounce time.Duration, fn func(changed []string)) error {
+	return pollChanges(ctx, paths, nil, interval, debounce, fn)
+}
+
+// pollChanges is PollChanges ignoring the files for which skip, if non-nil,
+// returns true.
+func pollChanges(ctx context.Context, paths []string, skip func(path string) bool, interval, debounce time.Duration, fn func(changed []string)) error {
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	if debounce <= 0 {
+		debounce = 200 * time.Millisecond
+	}
+
+	last := snapshot(paths, skip)
+	fn(nil)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current := snapshot(paths, skip)
+		if maps.Equal(last, current) {
+			continue
+		}
+
+		// Wait for the files to settle.
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(debounce):
+			}
+			settled := snapshot(paths, skip)
+			if maps.Equal(settled, current) {
+				break
+			}
+			current = settled
+		}
+
+		changed := changedFiles(last, current)
+		last = current
+		fn(changed)
+	}
+}
+
+// snapshot records the stamps of all files below paths but the skipped ones.
+// Files that cannot be read, e.g. while an editor replaces them, are left out.
+func snapshot(paths []string, skip func(path string) bool) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, root := range paths {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || skip != nil && skip(path) {
+				return nil
+			}
+			if stamp, err := statFile(path); err == nil {
+				stamps[path] = stamp
+			}
+			return nil
+		})
+	}
+	return stamps
+}
+
+func changedFiles(before, after map[string]fileStamp) []string {
+	var changed []string
+	for path, stamp := range after {
+		if prev, ok := before[path]; !ok || prev != stamp {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+func (w *Watcher) render(ctx context.Context, changed []string) {
+	start := time.Now()
+	event := WatchEvent{Changed: changed}
+
+	var buf bytes.Buffer
+	event.Info, event.Err = w.convert(ctx, &buf)
+	if event.Err == nil {
+		event.Err = writeFileAtomic(w.Output, buf.Bytes())
+		event.Bytes = buf.Len()
+	} else {
+		event.Diagnostics = DiagnosticsFromError(event.Info, event.Err)
+	}
+	event.Duration = time.Since(start)
+
+	if w.OnRender != nil {
+		w.OnRender(event)
+	}
+}
+
+func (w *Watcher) convert(ctx context.Context, buf *bytes.Buffer) (ResponseInfo, error) {
+	templateData, err := os.ReadFile(w.Template)
+	if err != nil {
+		return ResponseInfo{}, fmt.Errorf("failed to read template file: %w", err)
+	}
+
+	var media []MediaFile
+	for _, path := range w.Media {
+		files, err := loadMedia(path, w.isOutput)
+		if err != nil {
+			return ResponseInfo{}, err
+		}
+		media = append(media, files...)
+	}
+
+	return w.Client.Convert(ctx, buf, w.Content, templateData, w.Options, media)
+}
+
+// writeFileAtomic replaces path with data so that readers, such as a PDF
+// viewer reloading the file, never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Chmod(tmp.Name(), 0644)
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(tmp.Name(), path)
+	}
+	if writeErr != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write output file: %w", writeErr)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package typstpdfgenerator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var req typstRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template, _ := base64.StdEncoding.DecodeString(req.Template)
+		if strings.Contains(string(template), "broken") {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(typstResponse{
+				Error:   true,
+				Message: "compilation failed",
+				Stderr:  "main.typ:1:1: error: unexpected token\n",
+			})
+			return
+		}
+		writePDFResponse(w, "%PDF-1.7 "+string(template))
+	})
+
+	client, err := New("test-key", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "main.typ")
+	outputPath := filepath.Join(dir, "main.pdf")
+	if err := os.WriteFile(templatePath, []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	events := make(chan WatchEvent, 8)
+	w := &Watcher{
+		Client:   client,
+		Template: templatePath,
+		Output:   outputPath,
+		Interval: 10 * time.Millisecond,
+		Debounce: 10 * time.Millisecond,
+		OnRender: func(ev WatchEvent) { events <- ev },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	next := func() WatchEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for render")
+			return WatchEvent{}
+		}
+	}
+	assertOutput := func(want string) {
+		t.Helper()
+		got, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected output %q, got %q", want, got)
+		}
+	}
+	// Each update changes the size, so it is detected even on file systems
+	// with coarse modification times.
+	update := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(templatePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write template: %v", err)
+		}
+	}
+
+	ev := next()
+	if ev.Err != nil || len(ev.Changed) != 0 {
+		t.Fatalf("Unexpected initial render event: %+v", ev)
+	}
+	assertOutput("%PDF-1.7 first")
+
+	update("second version")
+	ev = next()
+	if ev.Err != nil {
+		t.Fatalf("Failed to re-render: %v", ev.Err)
+	}
+	if len(ev.Changed) != 1 || ev.Changed[0] != templatePath {
+		t.Errorf("Expected changed files [%s], got %v", templatePath, ev.Changed)
+	}
+	assertOutput("%PDF-1.7 second version")
+
+	update("broken template")
+	ev = next()
+	if !errors.Is(ev.Err, ErrNotGenerated) {
+		t.Fatalf("Expected ErrNotGenerated, got %v", ev.Err)
+	}
+	if len(ev.Diagnostics) != 1 || ev.Diagnostics[0].Line != 1 {
+		t.Errorf("Expected one diagnostic on line 1, got %+v", ev.Diagnostics)
+	}
+	assertOutput("%PDF-1.7 second version")
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected only template and output in %s, got %d entries", dir, len(entries))
+	}
+}
+
+func TestWatcherOutputInMediaDir(t *testing.T) {
+	requests := make(chan []string, 16)
+	server := newFakeGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var req typstRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- slices.Sorted(maps.Keys(req.Media))
+		writePDFResponse(w, "%PDF-1.7")
+	})
+
+	client, err := New("test-key", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "main.typ")
+	files := map[string]string{
+		templatePath:                           "= Hi",
+		filepath.Join(dir, "logo.png"):         "png",
+		filepath.Join(dir, "main.pdf"):         "%PDF-1.7 stale",
+		filepath.Join(dir, ".main.pdf.tmp-42"): "left over",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	w := &Watcher{
+		Client:   client,
+		Template: templatePath,
+		Media:    []string{dir},
+		Output:   filepath.Join(dir, "main.pdf"),
+		Interval: 10 * time.Millisecond,
+		Debounce: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := w.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(requests)
+	var renders [][]string
+	for media := range requests {
+		renders = append(renders, media)
+	}
+	if len(renders) != 1 {
+		t.Fatalf("Expected writing the output not to trigger renders, got %d renders", len(renders))
+	}
+	if want := []string{"logo.png", "main.typ"}; !slices.Equal(renders[0], want) {
+		t.Errorf("Expected media %v, got %v", want, renders[0])
+	}
+}